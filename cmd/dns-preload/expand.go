@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+)
+
+// expand prints every name of the configuration of config expand with its query type, one per
+// line in the order of the query types, the names of a group are followed by the group.
+func (c *Config) expand(w io.Writer) error {
+	cfg, err := confighandlers.LoadConfigFromFiles(c.Expand.ConfigFile, c.Expand.ConfDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range cfg.Entries() {
+		if e.Group != "" {
+			fmt.Fprintf(w, "%s %s (%s)\n", e.Type, e.Name, e.Group)
+			continue
+		}
+		fmt.Fprintf(w, "%s %s\n", e.Type, e.Name)
+	}
+	return nil
+}
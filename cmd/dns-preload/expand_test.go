@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestConfigExpand(t *testing.T) {
+	c := &Config{}
+	c.Expand.ConfigFile = "../../pkg/confighandlers/test_data/templates_config_sample.yaml"
+	buf := &bytes.Buffer{}
+	if err := c.expand(buf); err != nil {
+		t.Fatalf("Config.expand() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 38 || lines[0] != "hosts www.example.com" || lines[len(lines)-1] != "https us-edge2.example.com (edge)" {
+		t.Errorf("Config.expand() printed %d names:\n%s", len(lines), buf)
+	}
+	c.Expand.ConfigFile = "../../pkg/confighandlers/test_data/invalid_templates_config_sample.yaml"
+	if err := c.expand(buf); err == nil {
+		t.Errorf("Config.expand() expected an error for invalid templates")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+	"github.com/jimmystewpot/dns-preload/pkg/preload"
+)
+
+// selector returns the selector of the --tag and --exclude-tag flags.
+func (p *Preload) selector() confighandlers.Selector {
+	return confighandlers.Selector{Tags: p.Tag, Exclude: p.ExcludeTag}
+}
+
+// groupServer returns the server and port the names of group are preloaded from, the server and
+// port of the group are used when the flags are not set on the command line or in the environment.
+func (p *Preload) groupServer(group *confighandlers.Group) (string, string) {
+	server, port := p.Server, p.Port
+	if group == nil {
+		return server, port
+	}
+	if group.Server != "" && !p.explicit("server") {
+		server = group.Server
+	}
+	if group.Port != 0 && !p.explicit("port") {
+		port = strconv.Itoa(group.Port)
+	}
+	return server, port
+}
+
+// groupOptions returns the settings the names of group are preloaded with, the workers and timeout
+// of the group are used when the flags are not set on the command line or in the environment. A
+// group on a server of its own waits for the rate limit of that server in servers.
+func (p *Preload) groupOptions(group *confighandlers.Group, rl *confighandlers.RateLimit, servers map[string][]*dns.TokenBucket) preload.GroupOptions {
+	opts := preload.GroupOptions{Resolver: p.resolver}
+	if group == nil {
+		return opts
+	}
+	server, port := p.groupServer(group)
+	if nameserver := net.JoinHostPort(server, port); nameserver != p.nameserver {
+		opts.Nameserver = nameserver
+		opts.ServerRateLimits = serverLimits(rl, server, port, servers)
+	}
+	if group.Workers > 0 && !p.explicit("workers") {
+		opts.Workers = group.Workers
+	}
+	if group.Timeout != nil && !p.explicit("timeout") {
+		opts.Timeout = *group.Timeout
+	}
+	return opts
+}
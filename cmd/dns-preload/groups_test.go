@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+)
+
+const testGroupsConfig = `---
+query_type:
+  hosts:
+    - foo.bar
+groups:
+  work:
+    tags: [office]
+    server: 10.0.0.53
+    port: 5353
+    workers: 4
+    timeout: 5s
+    query_type:
+      mx:
+        - foo.bar
+      cname:
+        - www.foo.bar
+  streaming:
+    tags: [media]
+    query_type:
+      txt:
+        - foo.bar
+`
+
+func TestPreloadGroupOptions(t *testing.T) {
+	timeout := 5 * time.Second
+	group := &confighandlers.Group{Server: "10.0.0.53", Port: 5353, Workers: 4, Timeout: &timeout}
+	tests := []struct {
+		name           string
+		group          *confighandlers.Group
+		sources        map[string]string
+		wantNameserver string
+		wantWorkers    int
+		wantTimeout    time.Duration
+	}{
+		{name: "outside of the groups"},
+		{name: "group defaults", group: group, wantNameserver: "10.0.0.53:5353", wantWorkers: 4, wantTimeout: timeout},
+		{
+			name:           "flags take precedence",
+			group:          group,
+			sources:        map[string]string{"server": sourceFlag, "workers": sourceEnv, "timeout": sourceFlag, "port": sourceConfig},
+			wantNameserver: "localhost:5353",
+		},
+		{name: "empty group", group: &confighandlers.Group{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Preload{
+				Server:     "localhost",
+				Port:       "53",
+				nameserver: "localhost:53",
+				Workers:    2,
+				Timeout:    30 * time.Second,
+				OnError:    "abort",
+				sources:    tt.sources,
+			}
+			opts := p.groupOptions(tt.group, nil, make(map[string][]*dns.TokenBucket))
+			if opts.Nameserver != tt.wantNameserver || opts.Workers != tt.wantWorkers || opts.Timeout != tt.wantTimeout {
+				t.Errorf("groupOptions() = %s %d %s, want %s %d %s", opts.Nameserver, opts.Workers, opts.Timeout,
+					tt.wantNameserver, tt.wantWorkers, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestPreloadRunQueriesTags(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "groups.yaml")
+	if err := os.WriteFile(file, []byte(testGroupsConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := confighandlers.LoadConfigFromFile(&file)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	tests := []struct {
+		name       string
+		cmd        string
+		tag        []string
+		excludeTag []string
+		want       []string
+		wantErr    bool
+	}{
+		{name: "every name", cmd: cmdAll, want: []string{"foo.bar A, AAAA", "foo.bar MX", "foo.bar TXT", "www.foo.bar CNAME"}},
+		{name: "tag", cmd: cmdAll, tag: []string{"office"}, want: []string{"foo.bar MX", "www.foo.bar CNAME"}},
+		{name: "group name", cmd: cmdAll, tag: []string{"streaming", "work"}, excludeTag: []string{"office"}, want: []string{"foo.bar TXT"}},
+		{name: "exclude tag", cmd: cmdAll, excludeTag: []string{"media", "work"}, want: []string{"foo.bar A, AAAA"}},
+		{name: "single query type", cmd: confighandlers.Mx, tag: []string{"office", "media"}, want: []string{"foo.bar MX"}},
+		{name: "no match", cmd: cmdAll, tag: []string{"home"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nameserver := net.JoinHostPort(testDNSServer, testDNSServerPort)
+			out, err := newEmitter(io.Discard, "json", nameserver)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := &Preload{
+				Workers:    1,
+				Quiet:      true,
+				Mute:       true,
+				OnError:    "continue",
+				Tag:        tt.tag,
+				ExcludeTag: tt.excludeTag,
+				resolver:   NewMockResolver(),
+				nameserver: nameserver,
+				out:        out,
+			}
+			err = p.RunQueries(context.Background(), tt.cmd, cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunQueries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := make([]string, 0, len(p.out.Lookups()))
+			for _, ev := range p.out.Lookups() {
+				got = append(got, ev.Name+" "+ev.QType)
+			}
+			slices.Sort(got)
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("RunQueries() looked up %v, want %v", got, tt.want)
+			}
+			if p.nameserver != nameserver {
+				t.Errorf("RunQueries() left the nameserver at %s", p.nameserver)
+			}
+		})
+	}
+}
+
+func TestPreloadOptionsSharedLimits(t *testing.T) {
+	qps := 5.0
+	cfg := &confighandlers.Configuration{
+		RateLimit: &confighandlers.RateLimit{Servers: map[string]confighandlers.ServerRateLimit{"10.0.0.53": {QPS: qps}}},
+		Groups: map[string]*confighandlers.Group{
+			"work":      {Server: "10.0.0.53"},
+			"office":    {Server: "10.0.0.53"},
+			"streaming": {Workers: 4},
+		},
+		Routes: []confighandlers.Route{{Suffixes: []string{"corp"}, Server: "10.0.0.53"}},
+	}
+	p := &Preload{Server: "localhost", Port: "53", nameserver: "localhost:53", Workers: 2, OnError: "abort"}
+	opts, err := p.options(cmdAll, cfg)
+	if err != nil {
+		t.Fatalf("options() error = %v", err)
+	}
+	work, office := opts.Groups["work"].ServerRateLimits, opts.Groups["office"].ServerRateLimits
+	if len(work) != 1 || len(office) != 1 || work[0] != office[0] {
+		t.Errorf("expected the groups on the same server to share its rate limit, got %v and %v", work, office)
+	}
+	if route := opts.Routes[0].RateLimits; len(route) != 1 || route[0] != work[0] {
+		t.Errorf("expected the route to the server of the groups to share its rate limit, got %v", route)
+	}
+	if streaming := opts.Groups["streaming"]; streaming.Nameserver != "" || streaming.Workers != 4 {
+		t.Errorf("expected the streaming group on the server of the run with 4 workers, got %+v", streaming)
+	}
+}
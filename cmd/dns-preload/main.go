@@ -2,32 +2,30 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"net"
 	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
 	"github.com/jimmystewpot/dns-preload/pkg/dns"
-	"golang.org/x/sync/errgroup"
+	"github.com/jimmystewpot/dns-preload/pkg/preload"
 )
 
 const (
-	// these const strings are used to store the DNS query types for reuse.
-	queryTypeAStr     string = "A, AAAA"
-	queryTypeCNAMEStr string = "CNAME"
-	queryTypeMXStr    string = "MX"
-	queryTypeNSStr    string = "NS"
-	queryTypeTXTStr   string = "TXT"
-	queryTypePTRStr   string = "PTR"
 	// print messages that are used more than once.
-	infoMessage          string = "Preloading Nameserver: %s with query type: %s for domains: %s"
-	batchMessage         string = "Preloaded batch for query type: %s completed in: %s"
-	qTypeEmptyErrMessage string = "Preloading error: query type %s has no entries in the configuration"
-	qTypeErrMessage      string = "preloading error: query type %s is not a valid query type"
-	completedMessage     string = "Preload completed in %s"
+	infoMessage      string = "Preloading Nameserver: %s with query type: %s for domains: %s"
+	batchMessage     string = "Preloaded batch for query type: %s completed in: %s"
+	completedMessage string = "Preload completed in %s"
+	// cmdAll is the command that runs every query type.
+	cmdAll string = "all"
 )
 
 var (
@@ -40,36 +38,95 @@ var (
 		Ns     Preload       `cmd:"" help:"preload only the ns entries from the configuration file"`
 		Txt    Preload       `cmd:"" help:"preload only the txt entries from the configuration file"`
 		Ptr    Preload       `cmd:"" help:"preload only the ptr entries from the configuration file"`
+		Srv    Preload       `cmd:"" help:"preload only the srv entries from the configuration file"`
+		HTTPS  Preload       `cmd:"" name:"https" help:"preload only the https entries from the configuration file"`
 		Config Config        `cmd:"" help:"generate an empty configuration file to stdout"`
 		Delay  time.Duration `default:"0s" help:"How long to wait until the queries are executed"`
-		Sleep  time.Duration `default:"100ms" help:"Sleep between the different tests when query type all has been chosen"`
+		//nolint:lll // the help text explains both modes.
+		Sleep time.Duration `default:"100ms" help:"Sleep between the different tests when query type all has been chosen, 0s starts each type without waiting for the previous one to complete"`
 	}
 	start time.Time
-	quiet bool
 )
 
 type Preload struct {
+	// resolver replaces the resolver for the nameserver in tests.
 	resolver   dns.CustomResolver
-	ConfigFile string `required:"" help:"The configuration file to read the domain list to query from"`
+	ConfigFile string `required:"" help:"The configuration file to read the domain list to query from, or an http:// or https:// URL to fetch it from"`
+	ConfDir    string `help:"A directory of configuration files merged after the configuration file and its includes, every .yaml and .yml file in name order"`
 	Server     string `default:"localhost" help:"The server to query to seed the domain list into"`
 	Port       string `default:"53" help:"The port the DNS server listens for requests on"`
 	nameserver string
-	Timeout    time.Duration `default:"30s" help:"The timeout for each DNS query to succeed (not implemented)"`
-	Workers    uint8         `default:"2" help:"The number of concurrent goroutines used to query the DNS server"`
+	Timeout    time.Duration `default:"30s" help:"The time each lookup may spend querying, it bounds every attempt and retry of the lookup but not the waits for the rate limits"`
+	Workers    WorkerCount   `default:"2" help:"The number of concurrent goroutines used to query the DNS server, auto adapts it to the latency and errors"`
 	Mute       bool          `default:"false" help:"Suppress the preload task output to the console"`
 	Quiet      bool          `default:"false" help:"Suppress the preload response output to the console"`
-	Full       bool          `default:"true" help:"For record types that return a Hostname ensure that these are resolved"`
-	Debug      bool          `default:"false" help:"Debug mode"`
+	//nolint:lll // the help text lists every dependency.
+	Full       bool   `default:"true" help:"Preload the names the answers depend on, the hops of CNAME chains and the addresses of every hop and of the MX, NS, SRV and HTTPS targets"`
+	MaxDepth   int    `default:"8" help:"The number of levels of dependencies followed by --full"`
+	PtrForward bool   `default:"false" help:"With --full preload the addresses of the names returned by the PTR lookups"`
+	Tree       bool   `default:"false" help:"Print the tree of the dependencies pulled in by --full, in json mode it is added to the run summary"`
+	Debug      bool   `default:"false" help:"Debug mode"`
+	Output     string `default:"text" enum:"text,json,ndjson" help:"The output format for the preload results (text, json, ndjson)"`
+	ReportDir  string `help:"Write a JUnit XML and HTML report of the results into this directory"`
+	Slowest    int    `default:"5" help:"The number of slowest lookups listed in the run summary"`
+	OnError    string `default:"abort" help:"What to do when a lookup fails (continue, abort, threshold:N%)"`
+	//nolint:lll // the help text lists every class.
+	ErrorAction string `help:"Comma separated class=action pairs, action is retry, fail or skip, classes are nxdomain, nodata, servfail, refused, timeout, unreachable, truncated, malformed, unknown"`
+	//nolint:lll // flags are easier to read on a single line.
+	RetryAttempts   *int           `help:"The number of attempts for each lookup including the first, retries only the retryable error classes (default 1)"`
+	RetryBackoff    *time.Duration `help:"The delay before the first retry, it doubles for every retry and is randomised with jitter (default 200ms)"`
+	RetryMaxBackoff *time.Duration `help:"The maximum delay between two attempts (default 5s)"`
+	RetryTimeout    *time.Duration `help:"The timeout of each attempt, the --timeout still bounds all of the attempts of a lookup (default none)"`
+	RetryBudget     *int           `help:"The number of retries allowed for the whole run, 0 is unlimited (default 0)"`
+	Order           string         `help:"Comma separated query types in the order the all command starts them, types that are not listed follow in the default order"`
+	QPS             *float64       `name:"qps" help:"The maximum number of queries per second for the whole run including retries and follow up lookups, 0 is unlimited (default 0)"`
+	Burst           *int           `help:"The number of queries that may be sent at once before the --qps limit applies (default the qps)"`
+	MaxRuntime      time.Duration  `default:"0s" help:"The maximum time for the whole run, lookups that have not completed by then are skipped and a partial summary is reported, 0 is unlimited"`
+	Tag             []string       `help:"Preload only the names with one of these tags, the name of a group is a tag of its names"`
+	ExcludeTag      []string       `help:"Do not preload the names with one of these tags"`
+	//nolint:lll // a remote configuration is fetched into the cache directory, see configPath.
+	ConfigCacheDir     string        `help:"The directory the last good copy of a remote configuration is kept in (default the user cache directory)"`
+	ConfigSHA256       string        `name:"config-sha256" help:"The hex SHA-256 checksum a remote configuration must have to be accepted"`
+	ConfigFetchTimeout time.Duration `default:"30s" help:"The timeout to fetch a remote configuration, the last good copy is used when it expires"`
+	ConfigPublicKey    string        `help:"The minisign public key, or a file holding it, that must have signed the configuration and every file it includes, the signature of a file is the file with .sig appended"`
+	// sources holds where the flags that are not at their default were set, see flagSources.
+	sources map[string]string
+	// cfg is the configuration once it is loaded.
+	cfg    *confighandlers.Configuration
+	sleep  time.Duration
+	out    *emitter
+	result *preload.Result
+	// groups holds the settings of the groups of the run.
+	groups map[string]preload.GroupOptions
 }
 
 type Config struct {
 	Validate struct {
 		ConfigFile string `required:"" help:"The configuration file to load"`
-	} `cmd:"" help:"Validate a configuration file"`
-	Quiet    bool `default:"false" help:"Suppress the info output to the console"`
+		ConfDir    string `help:"A directory of configuration files to validate with the configuration file"`
+		Output     string `default:"text" enum:"text,json" help:"The output format for the diagnostics (text, json)"`
+		Strict     bool   `default:"false" help:"Fail on warnings as well as errors"`
+	} `cmd:"" help:"Validate a configuration file, every problem is reported with its line and column"`
+	Quiet   bool `default:"false" help:"Suppress the info output to the console"`
+	Migrate struct {
+		ConfigFile string `required:"" help:"The version 1 configuration file to convert, the files it includes are merged into the output"`
+	} `cmd:"" help:"Convert a version 1 configuration file to the version 2 layout and output it to stdout"`
 	Generate struct {
 		Generate bool `default:"true" help:"Generate an empty configuration and output it to stdout"`
 	} `cmd:"" help:"Generate a configuration file"`
+	Expand struct {
+		ConfigFile string `required:"" help:"The configuration file to expand"`
+		ConfDir    string `help:"A directory of configuration files merged after the configuration file and its includes"`
+	} `cmd:"" help:"Print every name of a configuration with its query type once the templates are expanded"`
+	Sign struct {
+		ConfigFile string `required:"" help:"The configuration file to sign"`
+		SecretKey  string `required:"" help:"The minisign secret key file to sign with, it must not be encrypted"`
+	} `cmd:"" help:"Write the detached signature of a configuration file next to it with .sig appended"`
+	Keygen struct {
+		SecretKey string `required:"" help:"The file to write the secret key to"`
+		PublicKey string `required:"" help:"The file to write the public key to"`
+		Force     bool   `default:"false" help:"Replace the key files when they exist"`
+	} `cmd:"" help:"Create a key pair for config sign, the keys are minisign keys without a password"`
 }
 
 // Config Run() prints an empty YAML configuration to stdout.
@@ -79,318 +136,284 @@ func (c *Config) Run(cmd string) error {
 		cfg := confighandlers.Configuration{}
 		return cfg.PrintEmptyConfigration(c.Quiet)
 	case "config validate":
-		return fmt.Errorf("not yet implemented")
+		return c.validate(os.Stdout)
+	case "config expand":
+		return c.expand(os.Stdout)
+	case "config sign":
+		return c.sign(os.Stdout)
+	case "config keygen":
+		return c.keygen(os.Stdout)
+	case "config migrate":
+		cfg, err := confighandlers.LoadConfigFromFile(&c.Migrate.ConfigFile)
+		if err != nil {
+			return err
+		}
+		data, err := confighandlers.Migrate(cfg)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
 	}
 	return fmt.Errorf("unknown command %s", cmd)
 }
 
-func (p *Preload) Run(cmd string) error {
-	quiet = p.Quiet
-	cfg, err := confighandlers.LoadConfigFromFile(&p.ConfigFile)
+// Run preloads the query types of cmd, the run stops once ctx is done.
+func (p *Preload) Run(ctx context.Context, cmd string) error {
+	cfg, err := p.setup(ctx)
 	if err != nil {
 		return err
 	}
 
-	p.nameserver = net.JoinHostPort(p.Server, p.Port)
-	p.resolver = dns.NewResolver(p.nameserver, p.Timeout)
-
-	ctx := context.Background()
-
 	return p.RunQueries(ctx, cmd, cfg)
 }
 
-// RunQueries breaks out the command switch statement allowing me to write better tests by adding a mock resolver.
-func (p *Preload) RunQueries(ctx context.Context, cmd string, cfg *confighandlers.Configuration) error {
-	switch cmd {
-	case confighandlers.Cname:
-		if cfg.QueryType.CnameCount != 0 {
-			p.IntroPrinter(queryTypeCNAMEStr, cfg.QueryType.Cname)
-			return p.CNAME(ctx, cfg.QueryType.Cname)
-		}
-	case confighandlers.Hosts:
-		if cfg.QueryType.HostsCount != 0 {
-			p.IntroPrinter(queryTypeAStr, cfg.QueryType.Hosts)
-			return p.Hosts(ctx, cfg.QueryType.Hosts)
-		}
-	case confighandlers.Mx:
-		if cfg.QueryType.MXCount != 0 {
-			p.IntroPrinter(queryTypeMXStr, cfg.QueryType.MX)
-			return p.MX(ctx, cfg.QueryType.MX)
-		}
-	case confighandlers.Ns:
-		if cfg.QueryType.NSCount != 0 {
-			p.IntroPrinter(queryTypeNSStr, cfg.QueryType.NS)
-			return p.NS(ctx, cfg.QueryType.NS)
-		}
-	case confighandlers.Txt:
-		if cfg.QueryType.TXTCount != 0 {
-			p.IntroPrinter(queryTypeTXTStr, cfg.QueryType.TXT)
-			return p.TXT(ctx, cfg.QueryType.TXT)
-		}
-	case confighandlers.Ptr:
-		if cfg.QueryType.PTRCount != 0 {
-			p.IntroPrinter(queryTypePTRStr, cfg.QueryType.PTR)
-			return p.PTR(ctx, cfg.QueryType.PTR)
+// load reads the configuration file once and applies its settings to the flags, the fetch of a
+// remote configuration stops once ctx is done.
+func (p *Preload) load(ctx context.Context) (*confighandlers.Configuration, error) {
+	if p.cfg != nil {
+		return p.cfg, nil
+	}
+	var key *confighandlers.PublicKey
+	if p.ConfigPublicKey != "" {
+		var err error
+		if key, err = readPublicKey(p.ConfigPublicKey); err != nil {
+			return nil, err
 		}
-	default: // no known query type fallback error handling.
-		return fmt.Errorf(qTypeErrMessage, cmd)
 	}
-	if p.Debug {
-		fmt.Printf(qTypeEmptyErrMessage+"\n", cmd)
+	file, err := p.configPath(ctx, key, os.Stderr)
+	if err != nil {
+		return nil, err
 	}
-	return nil
-}
-
-// CNAME preload the nameserver with CNAME lookups for a given list of hostnames.
-//
-//nolint:dupl // duplication of logic but not functionality
-func (p *Preload) CNAME(ctx context.Context, hosts []string) error {
-	batch := time.Now()
-	g := createErrGroup(p.Workers)
-	for i := 0; i < len(hosts); i++ {
-		host := hosts[i]
-		g.Go(func() error {
-			s := time.Now()
-			deadline, cancel := context.WithDeadline(ctx, time.Now().Add(p.Timeout))
-			defer cancel()
-			result, err := p.resolver.LookupCNAME(deadline, host)
-			if err != nil {
-				return err
-			}
-			err = p.ResultsPrinter(host, queryTypeCNAMEStr, time.Since(s), result)
-			if err != nil {
-				return err
-			}
-			return nil
-		})
+	var cfg *confighandlers.Configuration
+	if confighandlers.IsRemote(p.ConfigFile) {
+		cfg, err = confighandlers.LoadRemoteConfig(p.ConfigFile, file, p.ConfDir, key)
+	} else {
+		cfg, err = confighandlers.LoadSignedConfigFromFiles(file, p.ConfDir, key)
 	}
-	if err := g.Wait(); err != nil {
-		return err
+	if err != nil {
+		return nil, err
 	}
-
-	if !p.Quiet {
-		fmt.Printf(batchMessage+"\n", queryTypeCNAMEStr, time.Since(batch))
+	if err = p.applySettings(cfg.Settings); err != nil {
+		return nil, err
 	}
-
-	return nil
+	p.cfg = cfg
+	return cfg, nil
 }
 
-// Hosts preload the nameserver with IP addresses for a given list of hostnames.
-//
-//nolint:dupl // duplication of logic but not functionality
-func (p *Preload) Hosts(ctx context.Context, hosts []string) error {
-	batch := time.Now()
-	g := createErrGroup(p.Workers)
-	for i := 0; i < len(hosts); i++ {
-		host := hosts[i]
-		g.Go(func() error {
-			s := time.Now()
-			deadline, cancel := context.WithDeadline(ctx, time.Now().Add(p.Timeout))
-			defer cancel()
-			result, err := p.resolver.LookupIPAddr(deadline, host)
-			if err != nil {
-				return err
-			}
-			err = p.ResultsPrinter(host, queryTypeAStr, time.Since(s), result)
-			if err != nil {
-				return err
-			}
-			return nil
-		})
-	}
-	// wait for all of the goroutines in the error group to complete, any errors are handled uniformly.
-	if err := g.Wait(); err != nil {
-		return err
+// setup loads the configuration and creates the output of the run.
+func (p *Preload) setup(ctx context.Context) (*confighandlers.Configuration, error) {
+	cfg, err := p.load(ctx)
+	if err != nil {
+		return nil, err
 	}
-
-	if !p.Quiet {
-		fmt.Printf(batchMessage+"\n", queryTypeAStr, time.Since(batch))
+	p.nameserver = net.JoinHostPort(p.Server, p.Port)
+	if p.out == nil {
+		p.out, err = newEmitter(nil, p.Output, p.nameserver)
+		if err != nil {
+			return nil, err
+		}
 	}
-
-	return nil
+	return cfg, nil
 }
 
-// MX preloads the nameserver with the MX records for a given list of hostnames.
-//
-//nolint:dupl // duplication of logic but not functionality
-func (p *Preload) MX(ctx context.Context, hosts []string) error {
-	batch := time.Now()
-	g := createErrGroup(p.Workers)
-	for i := 0; i < len(hosts); i++ {
-		host := hosts[i]
-		g.Go(func() error {
-			s := time.Now()
-			deadline, cancel := context.WithDeadline(ctx, time.Now().Add(p.Timeout))
-			defer cancel()
-			result, err := p.resolver.LookupMX(deadline, host)
-			if err != nil {
-				return err
-			}
-
-			err = p.ResultsPrinter(host, queryTypeMXStr, time.Since(s), result)
-			if err != nil {
-				return err
-			}
-			return nil
-		})
+// Begin loads the configuration, sets up the output for a run of cmd and emits the run-start event.
+func (p *Preload) Begin(ctx context.Context, cmd string) error {
+	if _, err := p.load(ctx); err != nil {
+		return err
 	}
-	if err := g.Wait(); err != nil {
+	var err error
+	if _, _, err = p.errorFlags(); err != nil {
 		return err
 	}
-
-	if !p.Quiet {
-		fmt.Printf(batchMessage+"\n", queryTypeMXStr, time.Since(batch))
+	p.nameserver = net.JoinHostPort(p.Server, p.Port)
+	p.out, err = newEmitter(nil, p.Output, p.nameserver)
+	if err != nil {
+		return err
 	}
-
-	return nil
+	if p.Debug {
+		p.debugf("Effective settings:\n  %s", strings.Join(p.settings(), "\n  "))
+	}
+	return p.out.RunStart(cmd)
 }
 
-// NS preloads the nameserver records for a given list of hostnames.
-func (p *Preload) NS(ctx context.Context, hosts []string) error {
-	batch := time.Now()
-	g := createErrGroup(p.Workers)
-	for i := 0; i < len(hosts); i++ {
-		host := hosts[i]
-		g.Go(func() error {
-			s := time.Now()
-			deadline, cancel := context.WithDeadline(ctx, time.Now().Add(p.Timeout))
-			defer cancel()
-			result, err := p.resolver.LookupNS(deadline, host)
-			if err != nil {
-				return err
-			}
-			err = p.ResultsPrinter(host, queryTypeNSStr, time.Since(s), result)
-			if err != nil {
-				completedPrinter(quiet, start)
-
+// End prints the run summary once every query type has completed, emits the run-summary event and writes the reports.
+func (p *Preload) End(duration time.Duration, runErr error) error {
+	sum := p.out.Summary(duration, p.Slowest)
+	if p.result != nil {
+		// the engine records every lookup, the events of the lookups that complete once the run is
+		// cancelled are not delivered to the output.
+		sum = p.result.Summary
+		sum.WallTime = duration
+	}
+	var cancelled *preload.CancelledError
+	if errors.As(runErr, &cancelled) {
+		sum.Partial = cancelled.Cause.Error()
+	}
+	var tree []*preload.Node
+	if p.Tree && p.result != nil {
+		tree = p.result.Tree
+	}
+	if !p.Quiet && !p.out.structured() {
+		if err := sum.WriteText(os.Stdout); err != nil {
+			return err
+		}
+		if len(tree) > 0 {
+			fmt.Println("\nDependency tree:")
+			if err := preload.WriteTree(os.Stdout, tree); err != nil {
 				return err
 			}
-			return nil
-		})
+		}
 	}
-	if err := g.Wait(); err != nil {
-		return err
+	if p.ReportDir != "" {
+		err := WriteReports(p.ReportDir, p.nameserver, time.Now().Add(-duration), duration, p.out.Lookups())
+		if err != nil {
+			return err
+		}
 	}
+	return p.out.RunSummary(sum, tree, runErr)
+}
 
-	if !p.Quiet {
-		fmt.Printf(batchMessage+"\n", queryTypeNSStr, time.Since(batch))
+// errorFlags parses the --on-error and --error-action flags.
+func (p *Preload) errorFlags() (preload.ErrorPolicy, preload.ErrorActions, error) {
+	policy, err := preload.ParseErrorPolicy(p.OnError)
+	if err != nil {
+		return policy, nil, err
 	}
-
-	return nil
+	actions, err := preload.ParseErrorActions(p.ErrorAction)
+	return policy, actions, err
 }
 
-// TXT preloads the nameserver with the TXT records for a given list of hostnames.
-//
-//nolint:dupl // duplication of logic but not functionality
-func (p *Preload) TXT(ctx context.Context, hosts []string) error {
-	batch := time.Now()
-	g := createErrGroup(p.Workers)
-	for i := 0; i < len(hosts); i++ {
-		host := hosts[i]
-		g.Go(func() error {
-			s := time.Now()
-			deadline, cancel := context.WithDeadline(ctx, time.Now().Add(p.Timeout))
-			defer cancel()
-			result, err := p.resolver.LookupTXT(deadline, host)
-			if err != nil {
-				return err
-			}
-			err = p.ResultsPrinter(host, queryTypeTXTStr, time.Since(s), result)
-			if err != nil {
-				return err
-			}
-			return nil
-		})
+// options converts the flags and the configuration into the options of the preload engine for cmd,
+// the defaults of each group replace the flags that are not set for the names of the group.
+func (p *Preload) options(cmd string, cfg *confighandlers.Configuration) (preload.Options, error) {
+	policy, actions, err := p.errorFlags()
+	if err != nil {
+		return preload.Options{}, err
 	}
-	if err := g.Wait(); err != nil {
-		return err
+	types := []string{cmd}
+	if cmd == cmdAll {
+		types, err = preload.ParseOrder(p.Order)
+		if err != nil {
+			return preload.Options{}, err
+		}
 	}
-
-	if !p.Quiet {
-		fmt.Printf(batchMessage+"\n", queryTypeTXTStr, time.Since(batch))
+	buckets := p.rateLimits(cfg, p.Server, p.Port)
+	opts := preload.Options{
+		Nameserver:  p.nameserver,
+		Resolver:    p.resolver,
+		Types:       types,
+		Timeout:     p.Timeout,
+		Workers:     int(p.Workers),
+		AutoWorkers: p.Workers.auto(),
+		Full:        p.Full,
+		MaxDepth:    p.MaxDepth,
+		ForwardPTR:  p.PtrForward,
+		MaxRuntime:  p.MaxRuntime,
+		Sleep:       p.sleep,
+		OnError:     policy,
+		Actions:     actions,
+		Retry:       p.retryPolicy(cfg),
+		// the limit of the server only applies to the names that are not routed elsewhere.
+		RateLimits:       buckets[:1],
+		ServerRateLimits: buckets[1:],
+		Slowest:          p.Slowest,
+		OnEvent:          p.handle,
 	}
-
-	return nil
+	// the groups and routes on the server of the run share its rate limit.
+	servers := map[string][]*dns.TokenBucket{p.nameserver: buckets[1:]}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Groups)) {
+		if opts.Groups == nil {
+			opts.Groups = make(map[string]preload.GroupOptions)
+		}
+		opts.Groups[name] = p.groupOptions(cfg.Groups[name], cfg.RateLimit, servers)
+		if nameserver := opts.Groups[name].Nameserver; nameserver != "" && p.Debug {
+			p.debugf("Preloading group %s from %s", name, nameserver)
+		}
+	}
+	if opts.Routes, err = p.routes(cfg, opts.Timeout, servers); err != nil {
+		return preload.Options{}, err
+	}
+	if p.Debug {
+		opts.Debugf = p.debugf
+	}
+	return opts, nil
 }
 
-// PTR preloads the nameserver with the PTR records for a given list of hostnames.
-//
-//nolint:dupl // duplication of logic but not functionality
-func (p *Preload) PTR(ctx context.Context, hosts []string) error {
-	batch := time.Now()
-	g := createErrGroup(p.Workers)
-	for i := 0; i < len(hosts); i++ {
-		host := hosts[i]
-		g.Go(func() error {
-			s := time.Now()
-			deadline, cancel := context.WithDeadline(ctx, time.Now().Add(p.Timeout))
-			defer cancel()
-			result, err := p.resolver.LookupAddr(deadline, host)
-			if err != nil {
-				return err
-			}
-			err = p.ResultsPrinter(host, queryTypePTRStr, time.Since(s), result)
-			if err != nil {
-				return err
-			}
-			return nil
-		})
-	}
-	if err := g.Wait(); err != nil {
+// RunQueries runs the query types of cmd with the preload engine, the resolver can be replaced to test it.
+// The names selected by --tag and --exclude-tag are preloaded in a single run that shares the rate
+// limits, the retry budget and the memo of the run, the names of each group with the defaults of
+// the group. Higher priorities start first across every group.
+func (p *Preload) RunQueries(ctx context.Context, cmd string, cfg *confighandlers.Configuration) error {
+	selected, err := cfg.Select(p.selector())
+	if err != nil {
 		return err
 	}
-
-	if !p.Quiet {
-		fmt.Printf(batchMessage+"\n", queryTypePTRStr, time.Since(batch))
+	if len(selected.Entries()) == 0 {
+		if len(p.Tag)+len(p.ExcludeTag) > 0 {
+			return fmt.Errorf("no names match --tag %s --exclude-tag %s", strings.Join(p.Tag, ","), strings.Join(p.ExcludeTag, ","))
+		}
+		selected = cfg
 	}
-
-	return nil
+	opts, err := p.options(cmd, selected)
+	if err != nil {
+		return err
+	}
+	p.groups = opts.Groups
+	p.result, err = preload.New(opts).Run(ctx, selected)
+	return err
 }
 
-// String provides output to the console for the results of the preloading.
-func (p *Preload) ResultsPrinter(hostname string, qtype string, duration time.Duration, results interface{}) error {
-	// str is used to store the string conversions of the results.
-	str := make([]string, 0)
-	switch r := results.(type) {
-	case string:
-		str = append(str, results.(string))
-	case []string:
-		str = append(str, results.([]string)...)
-	case []*net.MX:
-		for _, mx := range results.([]*net.MX) {
-			str = append(str, mx.Host)
+// handle prints the events of the preload engine in text mode and records the lookups in the output.
+func (p *Preload) handle(ev preload.Event) error {
+	text := !p.Quiet && !p.out.structured()
+	switch ev.Kind {
+	case preload.EventTypeStart:
+		qtype := ev.QType
+		if ev.Priority != 0 {
+			qtype = fmt.Sprintf("%s priority %d", ev.QType, ev.Priority)
 		}
-	case []*net.NS:
-		for _, ns := range results.([]*net.NS) {
-			str = append(str, ns.Host)
+		nameserver := p.nameserver
+		if group := p.groups[ev.Group]; group.Nameserver != "" {
+			nameserver = group.Nameserver
 		}
-	case []net.IPAddr:
-		for _, ip := range results.([]net.IPAddr) {
-			str = append(str, ip.IP.String())
+		p.IntroPrinter(nameserver, qtype, ev.Names)
+	case preload.EventTypeDone:
+		if text {
+			fmt.Printf(batchMessage+"\n", ev.QType, ev.Duration)
 		}
-	default:
-		return fmt.Errorf("error: unknown type %T", r)
-	}
-	if p.Full {
-		// mx and ns record types return hostnames, if full is on we should resolve the final targets.
-		if (qtype == queryTypeMXStr) || (qtype == queryTypeNSStr) {
-			err := p.Hosts(context.Background(), str)
-			if err != nil {
-				return err
+	case preload.EventLookup:
+		if text && ev.Err == nil {
+			answers := strings.Join(ev.Answers, ", ")
+			if ev.QType == preload.QTypeCNAME && len(ev.Records) > 0 {
+				answers = chainString(ev.Name, ev.Records)
 			}
+			fmt.Printf("Preloaded %s type %s in %s to %+s%s%s\n", ev.Name, ev.QType, ev.Duration, answers, routeSuffix(ev.Route), attemptsSuffix(ev.Attempts))
 		}
+		return p.out.Lookup(ev)
+	case preload.EventSkip:
+		if text && ev.Err != nil {
+			fmt.Printf("Skipped %s type %s after %s failed with %s%s%s\n", ev.Name, ev.QType, ev.Duration, ev.Class, routeSuffix(ev.Route), attemptsSuffix(ev.Attempts))
+		}
+		return p.out.Skip(ev)
 	}
-	if !p.Quiet {
-		fmt.Printf("Preloaded %s type %s in %s to %+s\n", hostname, qtype, duration, strings.Join(str, ", "))
-	}
-
 	return nil
 }
 
-// IntroPrinter outputs the info on what domains and servers are being reloaded.
-func (p *Preload) IntroPrinter(queryType string, hosts []string) {
-	if !p.Mute {
-		fmt.Printf("\n"+infoMessage, p.nameserver, queryType, strings.Join(hosts, ", ")+"\n")
+// chainString formats the hops of a CNAME chain with their TTL, e.g. "www -> edge. (300s) -> cdn. (60s)".
+func chainString(name string, chain []dns.Record) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, rr := range chain {
+		fmt.Fprintf(&b, " -> %s (%ds)", rr.Target, rr.TTL)
+	}
+	return b.String()
+}
+
+// IntroPrinter outputs the info on what domains are being reloaded from nameserver.
+func (p *Preload) IntroPrinter(nameserver, queryType string, hosts []string) {
+	if !p.Mute && !p.out.structured() {
+		fmt.Printf("\n"+infoMessage, nameserver, queryType, strings.Join(hosts, ", ")+"\n")
 	}
 }
 
@@ -402,56 +425,67 @@ func completedPrinter(quiet bool, t time.Time) string {
 	return ""
 }
 
-// createErrGroup handles the logic to setup an errgroup for concurrency.
-func createErrGroup(limit uint8) *errgroup.Group {
-	g := new(errgroup.Group)
-	// if limit is not set, i.e. in testing set it.
-	if limit < 1 {
-		g.SetLimit(1)
-		return g
-	}
-	g.SetLimit(int(limit))
-
-	return g
-}
-
 func main() {
 	start = time.Now()
 	cmd := kong.Parse(&cli,
 		kong.Name(os.Args[0]),
 		kong.Description("Preload a DNS cache with a list of hostnames from a YAML configuration file."),
 		kong.UsageOnError(),
+		kong.DefaultEnvars(envPrefix),
 		kong.ConfigureHelp(kong.HelpOptions{
 			Compact: true,
 		}),
 	)
 
-	time.Sleep(cli.Delay)
-	switch cmd.Command() {
-	case "all":
-		errGrp := createErrGroup(1)
-		for _, queryType := range confighandlers.QueryTypes {
-			errGrp.Go(func() error {
-				err := cmd.Run(queryType)
-				if err != nil {
-					if !quiet {
-						fmt.Printf("%s\n", err)
-					}
-					return err
-				}
-				return nil
-			})
-			// add a small sleep so that the queries can complete before the next batch.
-			time.Sleep(cli.Sleep)
-		}
+	// the first SIGINT or SIGTERM cancels the run so the partial summary is still reported, a second
+	// one stops the process immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	cmd.BindTo(ctx, (*context.Context)(nil))
+
+	select {
+	case <-time.After(cli.Delay):
+	case <-ctx.Done():
+	}
+	p := selectedPreload(cmd.Command())
+	if p == nil {
+		cmd.FatalIfErrorf(cmd.Run(cmd.Command()))
+		return
+	}
+	p.sleep = cli.Sleep
+	p.sources = flagSources(cmd)
+	cmd.FatalIfErrorf(p.Begin(ctx, cmd.Command()))
+	// the all command runs every query type as a single plan, see preload.Preloader.Run.
+	err := cmd.Run(cmd.Command())
+	fmt.Print(completedPrinter(p.Quiet || p.out.structured(), start))
+	cmd.FatalIfErrorf(p.End(time.Since(start), err))
+	cmd.FatalIfErrorf(err)
+}
 
-		completedPrinter(quiet, start)
-		if err := errGrp.Wait(); err != nil {
-			cmd.FatalIfErrorf(err)
-		}
-	default:
-		err := cmd.Run(cmd.Command())
-		completedPrinter(quiet, start)
-		cmd.FatalIfErrorf(err)
+// selectedPreload returns the Preload flags for the chosen command, or nil for non preload commands.
+func selectedPreload(cmd string) *Preload {
+	switch cmd {
+	case cmdAll:
+		return &cli.All
+	case confighandlers.Cname:
+		return &cli.Cname
+	case confighandlers.Hosts:
+		return &cli.Hosts
+	case confighandlers.Mx:
+		return &cli.Mx
+	case confighandlers.Ns:
+		return &cli.Ns
+	case confighandlers.Txt:
+		return &cli.Txt
+	case confighandlers.Ptr:
+		return &cli.Ptr
+	case confighandlers.Srv:
+		return &cli.Srv
+	case confighandlers.HTTPS:
+		return &cli.HTTPS
 	}
+	return nil
 }
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
 	"github.com/jimmystewpot/dns-preload/pkg/dns"
+	"github.com/jimmystewpot/dns-preload/pkg/preload"
+	"github.com/jimmystewpot/dns-preload/pkg/summary"
 )
 
 const (
@@ -135,466 +143,42 @@ func (m *Mockresolver) LookupNS(ctx context.Context, host string) ([]*net.NS, er
 	return []*net.NS{}, fmt.Errorf(nxDomainErr, host)
 }
 
-func TestPreloadHosts(t *testing.T) {
-	ctx := context.Background()
-	type fields struct {
-		ConfigFile string
-		Server     string
-		Port       string
-		Workers    uint8
-		Quiet      bool
-		Full       bool
-		Debug      bool
-		Timeout    time.Duration
-		Delay      time.Duration
-		resolver   *Mockresolver
-		nameserver string
-	}
-	type args struct {
-		ctx   context.Context
-		hosts []string
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		{
-			name: "Test Case Without Error",
-			fields: fields{
-				resolver:   NewMockResolver(),
-				nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
-			},
-			args: args{
-				ctx:   ctx,
-				hosts: []string{testDomainNoErr},
-			},
-			wantErr: false,
-		},
-		{
-			name: "Test Case With Error",
-			fields: fields{
-				resolver:   NewMockResolver(),
-				nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
-				Workers:    1,
-			},
-			args: args{
-				ctx:   ctx,
-				hosts: []string{testDomainWithErr},
-			},
-			wantErr: true,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			p := &Preload{
-				ConfigFile: tt.fields.ConfigFile,
-				Server:     tt.fields.Server,
-				Port:       tt.fields.Port,
-				Workers:    tt.fields.Workers,
-				Quiet:      tt.fields.Quiet,
-				Full:       tt.fields.Full,
-				Debug:      tt.fields.Debug,
-				Timeout:    tt.fields.Timeout,
-				resolver:   tt.fields.resolver,
-				nameserver: tt.fields.nameserver,
-			}
-			if err := p.Hosts(tt.args.ctx, tt.args.hosts); (err != nil) != tt.wantErr {
-				t.Errorf("Preload.Hosts() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
-}
-
-func TestPreloadPtr(t *testing.T) {
-	ctx := context.Background()
-	type fields struct {
-		ConfigFile string
-		Server     string
-		Port       string
-		Workers    uint8
-		Quiet      bool
-		Full       bool
-		Debug      bool
-		Timeout    time.Duration
-		Delay      time.Duration
-		resolver   *Mockresolver
-		nameserver string
-	}
-	type args struct {
-		ctx   context.Context
-		hosts []string
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		{
-			name: "Test Case Without Error",
-			fields: fields{
-				resolver:   NewMockResolver(),
-				nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
-				Workers:    1,
-			},
-			args: args{
-				ctx:   ctx,
-				hosts: []string{googleIpv6},
-			},
-			wantErr: false,
-		},
-		{
-			name: "Test Case With Error",
-			fields: fields{
-				resolver:   NewMockResolver(),
-				nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
-				Quiet:      false,
-				Workers:    1,
-			},
-			args: args{
-				ctx:   ctx,
-				hosts: []string{testDomainNoErr},
-			},
-			wantErr: true,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			p := &Preload{
-				ConfigFile: tt.fields.ConfigFile,
-				Server:     tt.fields.Server,
-				Port:       tt.fields.Port,
-				Workers:    tt.fields.Workers,
-				Quiet:      tt.fields.Quiet,
-				Full:       tt.fields.Full,
-				Debug:      tt.fields.Debug,
-				Timeout:    tt.fields.Timeout,
-				resolver:   tt.fields.resolver,
-				nameserver: tt.fields.nameserver,
-			}
-			if err := p.PTR(tt.args.ctx, tt.args.hosts); (err != nil) != tt.wantErr {
-				t.Errorf("Preload.PTR() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
-}
-
-func TestPreloadMX(t *testing.T) {
-	ctx := context.Background()
-	type fields struct {
-		ConfigFile string
-		Server     string
-		Port       string
-		Workers    uint8
-		Quiet      bool
-		Full       bool
-		Debug      bool
-		Timeout    time.Duration
-		Delay      time.Duration
-		resolver   *Mockresolver
-		nameserver string
-	}
-	type args struct {
-		ctx   context.Context
-		hosts []string
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		{
-			name: "IN MX",
-			fields: fields{
-				resolver:   NewMockResolver(),
-				Full:       false,
-				Quiet:      false,
-				nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
-				Workers:    1,
-			},
-			args: args{
-				ctx:   ctx,
-				hosts: []string{testDomainNoErr},
-			},
-			wantErr: false,
-		},
-		{
-			name: "IN MX full recursion with error",
-			fields: fields{
-				resolver:   NewMockResolver(),
-				Full:       true,
-				Quiet:      false,
-				nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
-				Workers:    1,
-			},
-			args: args{
-				ctx: ctx,
-				// full recursive second lookup MX has a failure test case.
-				hosts: []string{testDomainNoErr},
-			},
-			wantErr: true,
-		},
-		{
-			name: "IN MX with Error",
-			fields: fields{
-				resolver:   NewMockResolver(),
-				nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
-				Workers:    1,
-			},
-			args: args{
-				ctx:   ctx,
-				hosts: []string{testDomainWithErr},
-			},
-			wantErr: true,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			p := &Preload{
-				ConfigFile: tt.fields.ConfigFile,
-				Server:     tt.fields.Server,
-				Port:       tt.fields.Port,
-				Workers:    tt.fields.Workers,
-				Quiet:      tt.fields.Quiet,
-				Full:       tt.fields.Full,
-				Debug:      tt.fields.Debug,
-				Timeout:    tt.fields.Timeout,
-				resolver:   tt.fields.resolver,
-				nameserver: tt.fields.nameserver,
-			}
-			if err := p.MX(tt.args.ctx, tt.args.hosts); (err != nil) != tt.wantErr {
-				t.Errorf("Preload.Hosts() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
-}
-
-func TestPreloadTXT(t *testing.T) {
-	ctx := context.Background()
-	type fields struct {
-		ConfigFile string
-		Server     string
-		Port       string
-		Workers    uint8
-		Quiet      bool
-		Full       bool
-		Debug      bool
-		Timeout    time.Duration
-		Delay      time.Duration
-		resolver   *Mockresolver
-		nameserver string
-	}
-	type args struct {
-		ctx   context.Context
-		hosts []string
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		{
-			name: "IN TXT",
-			fields: fields{
-				resolver:   NewMockResolver(),
-				Full:       true,
-				Quiet:      false,
-				nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
-				Workers:    1,
-			},
-			args: args{
-				ctx:   ctx,
-				hosts: []string{testDomainNoErr},
-			},
-			wantErr: false,
-		},
-		{
-			name: "IN TXT error",
-			fields: fields{
-				resolver:   NewMockResolver(),
-				nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
-				Workers:    1,
-			},
-			args: args{
-				ctx:   ctx,
-				hosts: []string{testDomainWithErr},
-			},
-			wantErr: true,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			p := &Preload{
-				ConfigFile: tt.fields.ConfigFile,
-				Server:     tt.fields.Server,
-				Port:       tt.fields.Port,
-				Workers:    tt.fields.Workers,
-				Quiet:      tt.fields.Quiet,
-				Full:       tt.fields.Full,
-				Debug:      tt.fields.Debug,
-				Timeout:    tt.fields.Timeout,
-				resolver:   tt.fields.resolver,
-				nameserver: net.JoinHostPort(tt.fields.Server, tt.fields.Port),
-			}
-			if err := p.TXT(tt.args.ctx, tt.args.hosts); (err != nil) != tt.wantErr {
-				t.Errorf("Preload.TXT() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
+func (m *Mockresolver) LookupRecords(ctx context.Context, name string, qtype dns.Type) ([]dns.Record, error) {
+	defer ctx.Done()
+	target := func(t dns.Type, target string) []dns.Record {
+		return []dns.Record{{Name: name, Type: t, TTL: 300, Target: target, Data: target}}
+	}
+	switch {
+	case name == testDomainWithErr || name == "www.bar.foo":
+		return nil, &dns.ResponseError{Name: name, Rcode: dns.RcodeNameError}
+	case qtype == dns.TypeA && name == "www.foo.bar":
+		return append(target(dns.TypeCNAME, testDomainNoErr), dns.Record{Name: testDomainNoErr, Type: dns.TypeA, TTL: 60, Data: googlePubDNS1}), nil
+	case qtype == dns.TypeSRV && name == "_sip._tcp.foo.bar":
+		return target(qtype, testDomainMX0), nil
+	case qtype == dns.TypeHTTPS && name == testDomainNoErr:
+		return target(qtype, "."), nil
+	case qtype == dns.TypeA || qtype == dns.TypeAAAA:
+		return addressRecords(ctx, m, name, qtype)
+	}
+	return nil, &dns.ResponseError{Name: name, NoData: true}
 }
 
-func TestPreloadNS(t *testing.T) {
-	ctx := context.Background()
-	type fields struct {
-		ConfigFile string
-		Server     string
-		Port       string
-		Workers    uint8
-		Quiet      bool
-		Full       bool
-		Debug      bool
-		Timeout    time.Duration
-		Delay      time.Duration
-		resolver   *Mockresolver
-		nameserver string
-	}
-	type args struct {
-		ctx   context.Context
-		hosts []string
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		{
-			name: "IN NS",
-			fields: fields{
-				resolver:   NewMockResolver(),
-				Full:       true,
-				Quiet:      false,
-				nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
-				Workers:    1,
-			},
-			args: args{
-				ctx:   ctx,
-				hosts: []string{testDomainNoErr},
-			},
-			wantErr: false,
-		},
-		{
-			name: "IN NS error",
-			fields: fields{
-				resolver:   NewMockResolver(),
-				nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
-				Workers:    1,
-			},
-			args: args{
-				ctx:   ctx,
-				hosts: []string{testDomainWithErr},
-			},
-			wantErr: true,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			p := &Preload{
-				ConfigFile: tt.fields.ConfigFile,
-				Server:     tt.fields.Server,
-				Port:       tt.fields.Port,
-				Workers:    tt.fields.Workers,
-				Quiet:      tt.fields.Quiet,
-				Full:       tt.fields.Full,
-				Debug:      tt.fields.Debug,
-				Timeout:    tt.fields.Timeout,
-				resolver:   tt.fields.resolver,
-				nameserver: net.JoinHostPort(tt.fields.Server, tt.fields.Port),
-			}
-			if err := p.NS(tt.args.ctx, tt.args.hosts); (err != nil) != tt.wantErr {
-				t.Errorf("Preload.NS() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
-}
-
-func TestPreloadCNAME(t *testing.T) {
-	ctx := context.Background()
-	type fields struct {
-		ConfigFile string
-		Server     string
-		Port       string
-		Workers    uint8
-		Quiet      bool
-		Full       bool
-		Debug      bool
-		Timeout    time.Duration
-		Delay      time.Duration
-		resolver   *Mockresolver
-		nameserver string
-	}
-	type args struct {
-		ctx   context.Context
-		hosts []string
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		{
-			name: "IN CNAME",
-			fields: fields{
-				resolver:   NewMockResolver(),
-				Full:       true,
-				Quiet:      false,
-				nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
-				Workers:    1,
-			},
-			args: args{
-				ctx:   ctx,
-				hosts: []string{"www.foo.bar"},
-			},
-			wantErr: false,
-		},
-		{
-			name: "IN CNAME error",
-			fields: fields{
-				resolver:   NewMockResolver(),
-				nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
-				Workers:    1,
-			},
-			args: args{
-				ctx:   ctx,
-				hosts: []string{"www.bar.foo"},
-			},
-			wantErr: true,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			p := &Preload{
-				ConfigFile: tt.fields.ConfigFile,
-				Server:     tt.fields.Server,
-				Port:       tt.fields.Port,
-				Workers:    tt.fields.Workers,
-				Quiet:      tt.fields.Quiet,
-				Full:       tt.fields.Full,
-				Debug:      tt.fields.Debug,
-				Timeout:    tt.fields.Timeout,
-				resolver:   tt.fields.resolver,
-				nameserver: tt.fields.nameserver,
-			}
-			if err := p.CNAME(tt.args.ctx, tt.args.hosts); (err != nil) != tt.wantErr {
-				t.Errorf("Preload.CNAME() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
+// addressRecords answers the A and AAAA queries of the mock with the addresses of LookupIPAddr.
+func addressRecords(ctx context.Context, r dns.CustomResolver, name string, qtype dns.Type) ([]dns.Record, error) {
+	addrs, err := r.LookupIPAddr(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]dns.Record, 0, len(addrs))
+	for _, addr := range addrs {
+		if (addr.IP.To4() != nil) == (qtype == dns.TypeA) {
+			records = append(records, dns.Record{Name: name, Type: qtype, TTL: 60, Data: addr.IP.String()})
+		}
+	}
+	if len(records) == 0 {
+		return nil, &dns.ResponseError{Name: name, NoData: true}
+	}
+	return records, nil
 }
 
 func TestPreloadRunQueries(t *testing.T) {
@@ -602,7 +186,7 @@ func TestPreloadRunQueries(t *testing.T) {
 		ConfigFile string
 		Server     string
 		Port       string
-		Workers    uint8
+		Workers    WorkerCount
 		Quiet      bool
 		Full       bool
 		Debug      bool
@@ -749,6 +333,36 @@ func TestPreloadRunQueries(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "good config test - srv full",
+			fields: fields{
+				ConfigFile: "../../pkg/confighandlers/test_data/basic_test_data_config.yaml",
+				Server:     testDNSServer,
+				Port:       testDNSServerPort,
+				nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
+				Full:       true,
+				Workers:    1,
+			},
+			args: args{
+				cmd: "srv",
+			},
+			wantErr: false,
+		},
+		{
+			name: "good config test - https full",
+			fields: fields{
+				ConfigFile: "../../pkg/confighandlers/test_data/basic_test_data_config.yaml",
+				Server:     testDNSServer,
+				Port:       testDNSServerPort,
+				nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
+				Full:       true,
+				Workers:    1,
+			},
+			args: args{
+				cmd: "https",
+			},
+			wantErr: false,
+		},
 	}
 	for i, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -772,70 +386,12 @@ func TestPreloadRunQueries(t *testing.T) {
 	}
 }
 
-func TestPreloadPrinter(t *testing.T) {
-	type fields struct {
-		ConfigFile string
-		Server     string
-		Port       string
-		Workers    uint8
-		Quiet      bool
-		Full       bool
-		Debug      bool
-		Timeout    time.Duration
-		Delay      time.Duration
-		resolver   *Mockresolver
-		nameserver string
-	}
-	type args struct {
-		hostname string
-		qtype    string
-		duration time.Duration
-		results  interface{}
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		{
-			name:   "test other interface underlying data type",
-			fields: fields{},
-			args: args{
-				hostname: testDomainNoErr,
-				qtype:    "foo",
-				duration: time.Second,
-				results:  returnIntInterface(),
-			},
-			wantErr: true,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			p := &Preload{
-				ConfigFile: tt.fields.ConfigFile,
-				Server:     tt.fields.Server,
-				Port:       tt.fields.Port,
-				Workers:    tt.fields.Workers,
-				Quiet:      tt.fields.Quiet,
-				Full:       tt.fields.Full,
-				Debug:      tt.fields.Debug,
-				Timeout:    tt.fields.Timeout,
-				resolver:   tt.fields.resolver,
-				nameserver: tt.fields.nameserver,
-			}
-			if err := p.ResultsPrinter(tt.args.hostname, tt.args.qtype, tt.args.duration, tt.args.results); (err != nil) != tt.wantErr {
-				t.Errorf("Preload.Printer() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
-}
-
 func TestConfigRun(t *testing.T) {
 	type fields struct {
 		Quiet    bool
 		Generate bool
 		Validate struct{ ConfigFile string }
+		Migrate  struct{ ConfigFile string }
 	}
 	type args struct {
 		cmd string
@@ -857,7 +413,7 @@ func TestConfigRun(t *testing.T) {
 			wantErr: false,
 		},
 		{
-			name: "Validate",
+			name: "Validate missing file",
 			fields: fields{
 				Quiet: true,
 			},
@@ -866,6 +422,39 @@ func TestConfigRun(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "Validate",
+			fields: fields{
+				Quiet:    true,
+				Validate: struct{ ConfigFile string }{ConfigFile: "../../pkg/confighandlers/test_data/complete_config_sample.yaml"},
+			},
+			args: args{
+				cmd: "config validate",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Migrate",
+			fields: fields{
+				Quiet:   true,
+				Migrate: struct{ ConfigFile string }{ConfigFile: "../../pkg/confighandlers/test_data/basic_test_data_config.yaml"},
+			},
+			args: args{
+				cmd: "config migrate",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Migrate version 2",
+			fields: fields{
+				Quiet:   true,
+				Migrate: struct{ ConfigFile string }{ConfigFile: "../../pkg/confighandlers/test_data/v2_config_sample.yaml"},
+			},
+			args: args{
+				cmd: "config migrate",
+			},
+			wantErr: true,
+		},
 		{
 			name: "error",
 			fields: fields{
@@ -882,6 +471,8 @@ func TestConfigRun(t *testing.T) {
 			c := &Config{
 				Quiet: tt.fields.Quiet,
 			}
+			c.Validate.ConfigFile = tt.fields.Validate.ConfigFile
+			c.Migrate.ConfigFile = tt.fields.Migrate.ConfigFile
 			if err := c.Run(tt.args.cmd); (err != nil) != tt.wantErr {
 				t.Errorf("Config.Run() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -894,7 +485,7 @@ func TestPreloadRun(t *testing.T) {
 		ConfigFile string
 		Server     string
 		Port       string
-		Workers    uint8
+		Workers    WorkerCount
 		Mute       bool
 		Quiet      bool
 		Full       bool
@@ -950,7 +541,7 @@ func TestPreloadRun(t *testing.T) {
 				resolver:   tt.fields.resolver,
 				nameserver: tt.fields.nameserver,
 			}
-			if err := p.Run(tt.args.cmd); (err != nil) != tt.wantErr {
+			if err := p.Run(context.Background(), tt.args.cmd); (err != nil) != tt.wantErr {
 				t.Errorf("Preload.Run() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -1006,3 +597,70 @@ func returnIntInterface() interface{} {
 	x := []int{1, 2, 3, 4, 5}
 	return x
 }
+
+func TestPreloadRunConfDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.yaml":         "query_type:\n  hosts: [foo.bar]\n",
+		"conf.d/mail.yaml":  "query_type:\n  mx: [foo.bar]\n",
+		"conf.d/hosts.yaml": "query_type:\n  hosts: [foo.bar]\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := newEmitter(io.Discard, outputJSON, testDNSServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Preload{
+		ConfigFile: filepath.Join(dir, "main.yaml"),
+		ConfDir:    filepath.Join(dir, "conf.d"),
+		Workers:    1,
+		OnError:    "continue",
+		resolver:   NewMockResolver(),
+		out:        out,
+	}
+	_ = p.Run(context.Background(), cmdAll)
+	got := make(map[string]string)
+	for _, ev := range p.out.Lookups() {
+		got[ev.Name+" "+ev.QType], _ = filepath.Rel(dir, ev.File)
+	}
+	// the hosts of conf.d/hosts.yaml are already in main.yaml so they are looked up once.
+	want := map[string]string{"foo.bar A, AAAA": "main.yaml", "foo.bar MX": "conf.d/mail.yaml"}
+	if len(p.out.Lookups()) != 2 || !reflect.DeepEqual(got, want) {
+		t.Errorf("Preload.Run() looked up %v, want %v", got, want)
+	}
+}
+
+func TestPreloadEndCancelled(t *testing.T) {
+	buf := &bytes.Buffer{}
+	out, err := newEmitter(buf, outputJSON, testDNSServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := summary.NewRecorder()
+	record.Record(testDomainNoErr, preload.QTypeA, time.Millisecond, 1, nil)
+	record.Skip(testDomainMX0, preload.QTypeA)
+	// the engine recorded both lookups, their events were not delivered once the run was cancelled.
+	p := &Preload{out: out, result: &preload.Result{Summary: record.Summary(time.Second, preload.DefaultSlowest)}}
+	runErr := &preload.CancelledError{Cause: context.Canceled}
+	if err = p.End(2*time.Second, runErr); err != nil {
+		t.Fatalf("End() error = %v", err)
+	}
+	doc := struct {
+		Summary *Event `json:"summary"`
+	}{}
+	if err = json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("the json output is not valid: %v", err)
+	}
+	sum := doc.Summary.Summary
+	if sum.Partial == "" || sum.Total.Succeeded != 1 || sum.Total.Skipped != 1 || sum.WallTime != 2*time.Second {
+		t.Errorf("expected a partial summary of the lookups of the engine, got %+v", sum)
+	}
+}
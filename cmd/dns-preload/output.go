@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+	"github.com/jimmystewpot/dns-preload/pkg/preload"
+	"github.com/jimmystewpot/dns-preload/pkg/summary"
+)
+
+const (
+	// output modes supported by the --output flag.
+	outputText   string = "text"
+	outputJSON   string = "json"
+	outputNDJSON string = "ndjson"
+	// event names used in the json and ndjson output modes.
+	eventRunStart   string = "run-start"
+	eventLookup     string = "lookup"
+	eventRunSummary string = "run-summary"
+)
+
+// Event is a single structured record written in the json and ndjson output modes.
+//
+//nolint:govet // fieldalignment is not required here, field order matches the json output.
+type Event struct {
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	Command string    `json:"command,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	QType   string    `json:"qtype,omitempty"`
+	Server  string    `json:"server,omitempty"`
+	// Route is the name of the route the lookup took when the configuration has routes.
+	Route string `json:"route,omitempty"`
+	// File is the configuration file the name is listed in.
+	File string `json:"file,omitempty"`
+	// Depth is the number of lookups above a lookup of a dependency with --full.
+	Depth    int      `json:"depth,omitempty"`
+	Duration float64  `json:"duration_ms,omitempty"`
+	Attempts int      `json:"attempts,omitempty"`
+	Answers  []string `json:"answers,omitempty"`
+	// TTL is the lowest TTL of the records when the resolver reports them, net.Resolver does not.
+	TTL *uint32 `json:"ttl,omitempty"`
+	// Chain lists the hops of the CNAME chain of a CNAME lookup, or of an address lookup with --full.
+	Chain   []chainHop       `json:"chain,omitempty"`
+	Error   string           `json:"error,omitempty"`
+	Class   dns.Class        `json:"class,omitempty"`
+	Skipped bool             `json:"skipped,omitempty"`
+	Summary *summary.Summary `json:"summary,omitempty"`
+	// Tree is the dependency tree of a run with --tree.
+	Tree []*preload.Node `json:"tree,omitempty"`
+}
+
+// chainHop is a CNAME record of a chain.
+type chainHop struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+	TTL    uint32 `json:"ttl"`
+}
+
+// document is the single JSON object written at the end of a run in json mode.
+type document struct {
+	Start   *Event   `json:"start"`
+	Lookups []*Event `json:"lookups"`
+	Summary *Event   `json:"summary"`
+}
+
+// emitter writes the preload events in the selected output mode, it is safe for concurrent use.
+//
+//nolint:govet // fieldalignment is not required here.
+type emitter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	mode   string
+	server string
+	start  *Event
+	events []*Event
+	record *summary.Recorder
+}
+
+// newEmitter returns an emitter for mode writing to w, if w is nil stdout is used.
+func newEmitter(w io.Writer, mode, server string) (*emitter, error) {
+	switch mode {
+	case "", outputText:
+		mode = outputText
+	case outputJSON, outputNDJSON:
+	default:
+		return nil, fmt.Errorf("unknown output mode %s", mode)
+	}
+	if w == nil {
+		w = os.Stdout
+	}
+	return &emitter{
+		w:      w,
+		mode:   mode,
+		server: server,
+		events: make([]*Event, 0),
+		record: summary.NewRecorder(),
+	}, nil
+}
+
+// structured returns true when the output is machine readable and the text messages must be suppressed.
+func (e *emitter) structured() bool {
+	return e != nil && e.mode != outputText
+}
+
+// RunStart records the start of a run for cmd.
+func (e *emitter) RunStart(cmd string) error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.start = &Event{
+		Event:   eventRunStart,
+		Time:    time.Now(),
+		Command: cmd,
+		Server:  e.server,
+	}
+	return e.write(e.start)
+}
+
+// Lookup records the outcome of a single query of the preload engine, the records of the event
+// are the answers with their TTL when the resolver reports them.
+func (e *emitter) Lookup(lookup preload.Event) error {
+	if e == nil {
+		return nil
+	}
+	ev := &Event{
+		Event:    eventLookup,
+		Time:     time.Now(),
+		Name:     lookup.Name,
+		QType:    lookup.QType,
+		Server:   lookup.Server,
+		Route:    lookup.Route,
+		File:     lookup.File,
+		Depth:    lookup.Depth,
+		Duration: milliseconds(lookup.Duration),
+		Attempts: lookup.Attempts,
+		Answers:  lookup.Answers,
+	}
+	for _, rr := range lookup.Records {
+		if ev.TTL == nil || rr.TTL < *ev.TTL {
+			ev.TTL = &rr.TTL
+		}
+		if rr.Type == dns.TypeCNAME {
+			ev.Chain = append(ev.Chain, chainHop{Name: rr.Name, Target: rr.Target, TTL: rr.TTL})
+		}
+	}
+	if lookup.Err != nil {
+		ev.Error = lookup.Err.Error()
+		ev.Class = dns.Classify(lookup.Err)
+	}
+	e.record.Record(lookup.Name, lookup.QType, lookup.Duration, lookup.Attempts, lookup.Err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, ev)
+	return e.write(ev)
+}
+
+// Skip records a name that was skipped, the error of the event is the failure that was ignored or
+// nil when the name was not queried because the run was aborted.
+func (e *emitter) Skip(skip preload.Event) error {
+	if e == nil {
+		return nil
+	}
+	e.record.Skip(skip.Name, skip.QType)
+	ev := &Event{
+		Event:   eventLookup,
+		Time:    time.Now(),
+		Name:    skip.Name,
+		QType:   skip.QType,
+		Server:  skip.Server,
+		Route:   skip.Route,
+		File:    skip.File,
+		Depth:   skip.Depth,
+		Skipped: true,
+	}
+	if skip.Err != nil {
+		ev.Error = skip.Err.Error()
+		ev.Class = dns.Classify(skip.Err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, ev)
+	return e.write(ev)
+}
+
+// Summary returns the run summary for the lookups recorded so far.
+func (e *emitter) Summary(wallTime time.Duration, slowest int) *summary.Summary {
+	if e == nil {
+		return summary.NewRecorder().Summary(wallTime, slowest)
+	}
+	return e.record.Summary(wallTime, slowest)
+}
+
+// RunSummary records the end of the run with the dependency tree when it is not nil, in json mode
+// this writes the complete document.
+func (e *emitter) RunSummary(sum *summary.Summary, tree []*preload.Node, runErr error) error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ev := &Event{
+		Event:    eventRunSummary,
+		Time:     time.Now(),
+		Server:   e.server,
+		Duration: milliseconds(sum.WallTime),
+		Summary:  sum,
+		Tree:     tree,
+	}
+	if runErr != nil {
+		ev.Error = runErr.Error()
+	}
+	switch e.mode {
+	case outputJSON:
+		enc := json.NewEncoder(e.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(&document{
+			Start:   e.start,
+			Lookups: e.events,
+			Summary: ev,
+		})
+	case outputNDJSON:
+		return json.NewEncoder(e.w).Encode(ev)
+	}
+	return nil
+}
+
+// Lookups returns a copy of every lookup event recorded so far.
+func (e *emitter) Lookups() []*Event {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	events := make([]*Event, len(e.events))
+	copy(events, e.events)
+	return events
+}
+
+// write streams a single event, only ndjson mode streams events as they happen.
+func (e *emitter) write(ev *Event) error {
+	if e.mode != outputNDJSON {
+		return nil
+	}
+	return json.NewEncoder(e.w).Encode(ev)
+}
+
+// milliseconds converts a duration to fractional milliseconds for the json output.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
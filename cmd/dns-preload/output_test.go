@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+	"github.com/jimmystewpot/dns-preload/pkg/preload"
+)
+
+func TestNewEmitter(t *testing.T) {
+	tests := []struct {
+		name           string
+		mode           string
+		wantStructured bool
+		wantErr        bool
+	}{
+		{
+			name:           "default text mode",
+			mode:           "",
+			wantStructured: false,
+		},
+		{
+			name:           "json mode",
+			mode:           outputJSON,
+			wantStructured: true,
+		},
+		{
+			name:           "ndjson mode",
+			mode:           outputNDJSON,
+			wantStructured: true,
+		},
+		{
+			name:    "unknown mode",
+			mode:    "xml",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := newEmitter(&bytes.Buffer{}, tt.mode, testDNSServer)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newEmitter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if e.structured() != tt.wantStructured {
+				t.Errorf("emitter.structured() = %v, want %v", e.structured(), tt.wantStructured)
+			}
+		})
+	}
+}
+
+func TestEmitterNDJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	e, err := newEmitter(buf, outputNDJSON, testDNSServer)
+	if err != nil {
+		t.Fatalf("newEmitter() error = %v", err)
+	}
+	if err := e.RunStart("hosts"); err != nil {
+		t.Fatalf("emitter.RunStart() error = %v", err)
+	}
+	if err := e.Lookup(preload.Event{Name: testDomainNoErr, QType: preload.QTypeA, Duration: time.Millisecond, Attempts: 1, Answers: []string{googlePubDNS1}}); err != nil {
+		t.Fatalf("emitter.Lookup() error = %v", err)
+	}
+	if err := e.Lookup(preload.Event{Name: testDomainWithErr, QType: preload.QTypeA, Duration: time.Millisecond, Attempts: 1, Err: fmt.Errorf(nxDomainErr, testDomainWithErr)}); err != nil {
+		t.Fatalf("emitter.Lookup() error = %v", err)
+	}
+	if err := e.RunSummary(e.Summary(time.Second, 1), nil, nil); err != nil {
+		t.Fatalf("emitter.RunSummary() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{eventRunStart, eventLookup, eventLookup, eventRunSummary}
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d events got %d", len(want), len(lines))
+	}
+	for i, line := range lines {
+		ev := &Event{}
+		if err := json.Unmarshal([]byte(line), ev); err != nil {
+			t.Fatalf("line %d is not valid json: %s", i, err)
+		}
+		if ev.Event != want[i] {
+			t.Errorf("line %d expected event %s got %s", i, want[i], ev.Event)
+		}
+	}
+	summary := &Event{}
+	if err := json.Unmarshal([]byte(lines[3]), summary); err != nil {
+		t.Fatalf("summary is not valid json: %s", err)
+	}
+	if summary.Summary.Total.Succeeded != 1 || summary.Summary.Total.Failed != 1 {
+		t.Errorf("expected 1 succeeded and 1 failed got %+v", summary.Summary.Total)
+	}
+}
+
+func TestEmitterJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	e, err := newEmitter(buf, outputJSON, testDNSServer)
+	if err != nil {
+		t.Fatalf("newEmitter() error = %v", err)
+	}
+	_ = e.RunStart("mx")
+	_ = e.Lookup(preload.Event{Name: testDomainNoErr, QType: preload.QTypeMX, Duration: time.Millisecond, Attempts: 1, Answers: []string{testDomainMX0, testDomainMX1}})
+	if buf.Len() != 0 {
+		t.Fatalf("json mode must not write until the summary, got %s", buf.String())
+	}
+	if err := e.RunSummary(e.Summary(time.Second, 1), nil, nil); err != nil {
+		t.Fatalf("emitter.RunSummary() error = %v", err)
+	}
+	doc := &document{}
+	if err := json.Unmarshal(buf.Bytes(), doc); err != nil {
+		t.Fatalf("output is not a valid json document: %s", err)
+	}
+	if len(doc.Lookups) != 1 || len(doc.Lookups[0].Answers) != 2 {
+		t.Errorf("expected 1 lookup with 2 answers got %+v", doc.Lookups)
+	}
+}
+
+func TestEmitterChain(t *testing.T) {
+	buf := &bytes.Buffer{}
+	e, err := newEmitter(buf, outputNDJSON, testDNSServer)
+	if err != nil {
+		t.Fatalf("newEmitter() error = %v", err)
+	}
+	chain := []dns.Record{
+		{Name: "www.foo.bar.", Type: dns.TypeCNAME, TTL: 300, Target: "edge.foo.bar.", Data: "edge.foo.bar."},
+		{Name: "edge.foo.bar.", Type: dns.TypeCNAME, TTL: 60, Target: "foo.bar.", Data: "foo.bar."},
+	}
+	if err = e.Lookup(preload.Event{Name: "www.foo.bar", QType: preload.QTypeCNAME, Duration: time.Millisecond, Attempts: 1, Answers: []string{"edge.foo.bar.", "foo.bar."}, Records: chain}); err != nil {
+		t.Fatalf("emitter.Lookup() error = %v", err)
+	}
+	ev := &Event{}
+	if err = json.Unmarshal(buf.Bytes(), ev); err != nil {
+		t.Fatalf("output is not valid json: %s", err)
+	}
+	if ev.TTL == nil || *ev.TTL != 60 || len(ev.Chain) != 2 || ev.Chain[1].Target != "foo.bar." {
+		t.Errorf("expected the chain with the lowest ttl, got %+v", ev)
+	}
+	if got, want := chainString("www.foo.bar", chain), "www.foo.bar -> edge.foo.bar. (300s) -> foo.bar. (60s)"; got != want {
+		t.Errorf("chainString() = %s, want %s", got, want)
+	}
+}
+
+func TestEmitterNil(t *testing.T) {
+	var e *emitter
+	if e.structured() {
+		t.Errorf("nil emitter must not be structured")
+	}
+	if err := e.Lookup(preload.Event{Name: testDomainNoErr, QType: preload.QTypeA, Duration: time.Millisecond, Attempts: 1}); err != nil {
+		t.Errorf("nil emitter Lookup() error = %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+)
+
+// rateLimits returns the token buckets for the run, the first limits every query and the second
+// the queries sent to server and port. The --qps and --burst flags take precedence over the
+// rate_limit block of the configuration file, nil buckets do not limit.
+func (p *Preload) rateLimits(cfg *confighandlers.Configuration, server, port string) []*dns.TokenBucket {
+	var (
+		qps   float64
+		burst int
+		rl    *confighandlers.RateLimit
+	)
+	if cfg != nil && cfg.RateLimit != nil {
+		rl = cfg.RateLimit
+		setIf(&qps, rl.QPS)
+		setIf(&burst, rl.Burst)
+	}
+	setIf(&qps, p.QPS)
+	setIf(&burst, p.Burst)
+	buckets := []*dns.TokenBucket{dns.NewTokenBucket(qps, burst)}
+	return append(buckets, serverLimits(rl, server, port, nil)...)
+}
+
+// serverLimits returns the token bucket of the queries sent to server and port when rl limits
+// them. The buckets are kept in servers, when it is not nil, so every group sent to the same
+// server shares them.
+func serverLimits(rl *confighandlers.RateLimit, server, port string, servers map[string][]*dns.TokenBucket) []*dns.TokenBucket {
+	address := net.JoinHostPort(server, port)
+	if buckets, ok := servers[address]; ok {
+		return buckets
+	}
+	var buckets []*dns.TokenBucket
+	if limit, ok := rl.ServerLimit(server, port); ok {
+		buckets = append(buckets, dns.NewTokenBucket(limit.QPS, limit.Burst))
+	}
+	if servers != nil {
+		servers[address] = buckets
+	}
+	return buckets
+}
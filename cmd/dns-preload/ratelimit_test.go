@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+)
+
+func TestPreloadRateLimits(t *testing.T) {
+	cfgQPS := 50.0
+	cliQPS := 0.0
+	servers := map[string]confighandlers.ServerRateLimit{"10.0.0.53": {QPS: 5}}
+	tests := []struct {
+		name   string
+		qps    *float64
+		server string
+		cfg    *confighandlers.Configuration
+		want   []bool
+	}{
+		{
+			name:   "unlimited by default",
+			server: "localhost",
+			cfg:    &confighandlers.Configuration{},
+			want:   []bool{false},
+		},
+		{
+			name:   "configuration file with a server limit",
+			server: "10.0.0.53",
+			cfg:    &confighandlers.Configuration{RateLimit: &confighandlers.RateLimit{QPS: &cfgQPS, Servers: servers}},
+			want:   []bool{true, true},
+		},
+		{
+			name:   "command line takes precedence",
+			qps:    &cliQPS,
+			server: "localhost",
+			cfg:    &confighandlers.Configuration{RateLimit: &confighandlers.RateLimit{QPS: &cfgQPS, Servers: servers}},
+			want:   []bool{false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Preload{QPS: tt.qps, Server: tt.server, Port: "53"}
+			buckets := p.rateLimits(tt.cfg, p.Server, p.Port)
+			if len(buckets) != len(tt.want) {
+				t.Fatalf("rateLimits() returned %d buckets, want %d", len(buckets), len(tt.want))
+			}
+			for i, limited := range tt.want {
+				if (buckets[i] != nil) != limited {
+					t.Errorf("rateLimits() bucket %d limited = %v, want %v", i, buckets[i] != nil, limited)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+)
+
+// configPath returns the local path of the configuration file, a remote configuration is fetched into
+// the cache first and its last good copy is used with a warning when it can not be fetched. A remote
+// configuration must be signed with key when it is not nil.
+func (p *Preload) configPath(ctx context.Context, key *confighandlers.PublicKey, warn io.Writer) (string, error) {
+	if !confighandlers.IsRemote(p.ConfigFile) {
+		return p.ConfigFile, nil
+	}
+	dir := p.ConfigCacheDir
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("no --config-cache-dir for the remote configuration: %w", err)
+		}
+		dir = filepath.Join(cache, "dns-preload")
+	}
+	f := &confighandlers.Fetcher{CacheDir: dir, SHA256: p.ConfigSHA256, Timeout: p.ConfigFetchTimeout, PublicKey: key}
+	result, err := f.Fetch(ctx, p.ConfigFile)
+	if err != nil {
+		return "", err
+	}
+	if result.Fallback != nil {
+		fmt.Fprintf(warn, "warning: using the last good copy of %s: %s\n", p.ConfigFile, result.Fallback)
+	}
+	return result.Path, nil
+}
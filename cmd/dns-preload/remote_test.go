@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+)
+
+func TestPreloadConfigPath(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("query_type:\n  hosts: [foo.bar]\n"))
+	}))
+	p := &Preload{ConfigFile: ts.URL + "/dns-preload.yaml", ConfigCacheDir: t.TempDir()}
+	warn := &bytes.Buffer{}
+	path, err := p.configPath(context.Background(), nil, warn)
+	if err != nil || warn.Len() != 0 {
+		t.Fatalf("configPath() = %s, %v, warned %q", path, err, warn)
+	}
+	cfg, err := confighandlers.LoadConfigFromFiles(path, "")
+	if err != nil || len(cfg.QueryType.Hosts) != 1 {
+		t.Fatalf("LoadConfigFromFiles() of the fetched copy = %+v, %v", cfg, err)
+	}
+	ts.Close()
+	if got, err := p.configPath(context.Background(), nil, warn); err != nil || got != path {
+		t.Fatalf("configPath() = %s, %v, want the last good copy %s", got, err, path)
+	}
+	if !strings.Contains(warn.String(), "using the last good copy of "+p.ConfigFile) {
+		t.Errorf("configPath() expected a warning for the last good copy, got %q", warn)
+	}
+	p.ConfigFile = "dns-preload.yaml"
+	if got, err := p.configPath(context.Background(), nil, warn); err != nil || got != p.ConfigFile {
+		t.Errorf("configPath() = %s, %v, want the local file unchanged", got, err)
+	}
+}
+
+func TestPreloadLoadCancelled(t *testing.T) {
+	// the server never answers, the fetch must stop with the context of the command.
+	ts := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+	p := &Preload{ConfigFile: ts.URL + "/dns-preload.yaml", ConfigCacheDir: t.TempDir(), ConfigFetchTimeout: time.Minute}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := p.load(ctx); err == nil || time.Since(start) > 10*time.Second {
+		t.Errorf("load() = %v after %s, want the cancelled fetch to fail without a cached copy", err, time.Since(start))
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/preload"
+)
+
+const (
+	// file names written into the --report-dir directory.
+	junitReportFile string = "dns-preload-junit.xml"
+	htmlReportFile  string = "dns-preload-report.html"
+	reportFileMode         = 0o644
+	reportDirMode          = 0o755
+	// dependencySuite is the suite of the lookups of the dependencies followed by --full.
+	dependencySuite string = "dependencies"
+	// chart dimensions for the html latency charts.
+	chartBarHeight int = 18
+	chartWidth     int = 600
+	chartLabelSize int = 260
+	chartTextSize  int = 100
+)
+
+// suite groups the lookup events for a single query type.
+type suite struct {
+	QType    string
+	Cases    []*Event
+	Failures []*Event
+	Time     time.Duration
+	Slowest  float64
+}
+
+// junitTestSuites is the root element of a JUnit XML report.
+//
+//nolint:govet // fieldalignment is not required here, field order matches the xml output.
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+//nolint:govet // fieldalignment is not required here, field order matches the xml output.
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+//nolint:govet // fieldalignment is not required here, field order matches the xml output.
+type junitTestCase struct {
+	Name       string          `xml:"name,attr"`
+	ClassName  string          `xml:"classname,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitFailure   `xml:"failure,omitempty"`
+	Skipped    *junitSkipped   `xml:"skipped,omitempty"`
+	SystemOut  string          `xml:"system-out,omitempty"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// WriteReports writes the JUnit XML and HTML reports for the lookups into dir.
+func WriteReports(dir, server string, start time.Time, duration time.Duration, lookups []*Event) error {
+	if err := os.MkdirAll(dir, reportDirMode); err != nil {
+		return err
+	}
+	suites := groupSuites(lookups)
+	if err := writeJUnit(filepath.Join(dir, junitReportFile), server, start, duration, suites); err != nil {
+		return err
+	}
+	return writeHTML(filepath.Join(dir, htmlReportFile), server, start, duration, suites)
+}
+
+// groupSuites groups the lookups of the configuration by query type, the suites keep the order the
+// types were run in. The lookups of the dependencies are grouped in a last suite of their own.
+func groupSuites(lookups []*Event) []*suite {
+	suites := make([]*suite, 0)
+	index := make(map[string]*suite)
+	var deps *suite
+	for _, ev := range lookups {
+		s, ok := index[ev.QType]
+		switch {
+		case ev.Depth > 0:
+			if deps == nil {
+				deps = &suite{QType: dependencySuite}
+			}
+			s = deps
+		case !ok:
+			s = &suite{QType: ev.QType}
+			index[ev.QType] = s
+			suites = append(suites, s)
+		}
+		s.Cases = append(s.Cases, ev)
+		s.Time += time.Duration(ev.Duration * float64(time.Millisecond))
+		if ev.Error != "" && !ev.Skipped {
+			s.Failures = append(s.Failures, ev)
+		}
+		if ev.Duration > s.Slowest {
+			s.Slowest = ev.Duration
+		}
+	}
+	if deps != nil {
+		suites = append(suites, deps)
+	}
+	for _, s := range suites {
+		sort.SliceStable(s.Cases, func(i, j int) bool {
+			return s.Cases[i].Name < s.Cases[j].Name
+		})
+	}
+	return suites
+}
+
+// writeJUnit writes a JUnit XML report with a testsuite per query type and a testcase per domain.
+func writeJUnit(path, server string, start time.Time, duration time.Duration, suites []*suite) error {
+	report := junitTestSuites{
+		Name:   "dns-preload",
+		Time:   seconds(duration),
+		Suites: make([]junitTestSuite, 0, len(suites)),
+	}
+	for _, s := range suites {
+		ts := junitTestSuite{
+			Name:       s.QType,
+			Tests:      len(s.Cases),
+			Failures:   len(s.Failures),
+			Time:       seconds(s.Time),
+			Timestamp:  start.Format(time.RFC3339),
+			Properties: []junitProperty{{Name: "server", Value: server}},
+			Cases:      make([]junitTestCase, 0, len(s.Cases)),
+		}
+		for _, ev := range s.Cases {
+			tc := junitTestCase{
+				Name:      ev.Name,
+				ClassName: "dns-preload." + className(ev.QType),
+				Time:      seconds(time.Duration(ev.Duration * float64(time.Millisecond))),
+				SystemOut: strings.Join(ev.Answers, "\n"),
+			}
+			// the server of the run is a property of the suite, a case sent elsewhere has its own.
+			if ev.Server != "" && ev.Server != server {
+				tc.Properties = []junitProperty{{Name: "server", Value: ev.Server}}
+			}
+			switch {
+			case ev.Skipped && ev.Error != "":
+				tc.Skipped = &junitSkipped{Message: ev.Error}
+				ts.Skipped++
+			case ev.Skipped:
+				tc.Skipped = &junitSkipped{Message: "not queried, the run was aborted"}
+				ts.Skipped++
+			case ev.Error != "":
+				tc.Failure = &junitFailure{
+					Message: ev.Error,
+					Type:    string(ev.Class),
+					Body:    ev.Error,
+				}
+			}
+			ts.Cases = append(ts.Cases, tc)
+		}
+		report.Tests += ts.Tests
+		report.Failures += ts.Failures
+		report.Suites = append(report.Suites, ts)
+	}
+	out, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), out...), reportFileMode)
+}
+
+// htmlBar is a single bar in a latency chart.
+type htmlBar struct {
+	Name   string
+	Label  string
+	Y      int
+	Width  int
+	Failed bool
+}
+
+// htmlSuite is the template data for a single query type.
+type htmlSuite struct {
+	QType    string
+	Height   int
+	Bars     []htmlBar
+	Failures []*Event
+}
+
+// writeHTML writes a self contained HTML report with a latency chart per query type and the failures grouped by type.
+func writeHTML(path, server string, start time.Time, duration time.Duration, suites []*suite) error {
+	data := struct {
+		Server   string
+		Start    string
+		Duration string
+		Tests    int
+		Failures int
+		Width    int
+		Label    int
+		Suites   []htmlSuite
+	}{
+		Server:   server,
+		Start:    start.Format(time.RFC3339),
+		Duration: duration.String(),
+		Width:    chartLabelSize + chartWidth + chartTextSize,
+		Label:    chartLabelSize,
+		Suites:   make([]htmlSuite, 0, len(suites)),
+	}
+	for _, s := range suites {
+		hs := htmlSuite{
+			QType:    s.QType,
+			Height:   len(s.Cases) * chartBarHeight,
+			Failures: s.Failures,
+		}
+		for i, ev := range s.Cases {
+			width := 1
+			if s.Slowest > 0 {
+				width = max(1, int(ev.Duration/s.Slowest*float64(chartWidth)))
+			}
+			hs.Bars = append(hs.Bars, htmlBar{
+				Name:   ev.Name,
+				Label:  fmt.Sprintf("%.2fms", ev.Duration),
+				Y:      i * chartBarHeight,
+				Width:  width,
+				Failed: ev.Error != "",
+			})
+		}
+		data.Tests += len(s.Cases)
+		data.Failures += len(s.Failures)
+		data.Suites = append(data.Suites, hs)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, reportFileMode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return htmlReport.Execute(f, data)
+}
+
+// className returns the junit classname for a query type display string.
+func className(qtype string) string {
+	if name := preload.TypeName(qtype); name != "" {
+		return name
+	}
+	return strings.ToLower(strings.ReplaceAll(qtype, ", ", "-"))
+}
+
+// seconds formats a duration as fractional seconds for the junit time attributes.
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"add": func(a, b int) int { return a + b },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>dns-preload report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.ok { fill: #4c9a2a; }
+.failed { fill: #c0392b; }
+.label { font-size: 12px; }
+</style>
+</head>
+<body>
+<h1>dns-preload report</h1>
+<table>
+<tr><th>Server</th><td>{{.Server}}</td></tr>
+<tr><th>Started</th><td>{{.Start}}</td></tr>
+<tr><th>Duration</th><td>{{.Duration}}</td></tr>
+<tr><th>Lookups</th><td>{{.Tests}}</td></tr>
+<tr><th>Failures</th><td>{{.Failures}}</td></tr>
+</table>
+<h2>Latency</h2>
+{{range .Suites}}
+<h3>{{.QType}}</h3>
+<svg width="{{$.Width}}" height="{{.Height}}" role="img" aria-label="latency for {{.QType}}">
+{{range .Bars}}<text class="label" x="0" y="{{add .Y 13}}">{{.Name}}</text>
+<rect class="{{if .Failed}}failed{{else}}ok{{end}}" x="{{$.Label}}" y="{{add .Y 2}}" width="{{.Width}}" height="14"><title>{{.Name}} {{.Label}}</title></rect>
+<text class="label" x="{{add $.Label (add .Width 4)}}" y="{{add .Y 13}}">{{.Label}}</text>
+{{end}}</svg>
+{{end}}
+<h2>Failures</h2>
+{{range .Suites}}{{if .Failures}}
+<h3>{{.QType}}</h3>
+<table>
+<tr><th>Name</th><th>Class</th><th>Error</th></tr>
+{{range .Failures}}<tr><td>{{.Name}}</td><td>{{.Class}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}
+</body>
+</html>
+`))
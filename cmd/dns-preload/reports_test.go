@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/preload"
+)
+
+func TestWriteReports(t *testing.T) {
+	lookups := []*Event{
+		{
+			Event:    eventLookup,
+			Name:     testDomainNoErr,
+			QType:    preload.QTypeA,
+			Duration: 1.5,
+			Answers:  []string{googlePubDNS1},
+		},
+		{
+			Event:    eventLookup,
+			Name:     testDomainWithErr,
+			QType:    preload.QTypeA,
+			Duration: 3,
+			Error:    "nxdomain bar.foo",
+		},
+		{
+			Event:    eventLookup,
+			Name:     testDomainNoErr,
+			QType:    preload.QTypeMX,
+			Server:   "10.8.0.1:53",
+			Duration: 2,
+			Answers:  []string{testDomainMX0, testDomainMX1},
+		},
+		{
+			Event:    eventLookup,
+			Name:     testDomainMX0,
+			QType:    preload.QTypeA,
+			Depth:    1,
+			Duration: 1,
+			Answers:  []string{googlePubDNS2},
+		},
+	}
+	dir := filepath.Join(t.TempDir(), "reports")
+	if err := WriteReports(dir, testDNSServer, time.Now(), time.Second, lookups); err != nil {
+		t.Fatalf("WriteReports() error = %v", err)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, junitReportFile))
+	if err != nil {
+		t.Fatalf("junit report was not written: %s", err)
+	}
+	report := &junitTestSuites{}
+	if err := xml.Unmarshal(raw, report); err != nil {
+		t.Fatalf("junit report is not valid xml: %s", err)
+	}
+	if report.Tests != 4 || report.Failures != 1 {
+		t.Errorf("expected 4 tests and 1 failure got %d and %d", report.Tests, report.Failures)
+	}
+	if len(report.Suites) != 3 {
+		t.Fatalf("expected a suite per query type and a suite of the dependencies got %d", len(report.Suites))
+	}
+	// the dependency is not a case of the names of the configuration.
+	if deps := report.Suites[2]; deps.Name != dependencySuite || len(deps.Cases) != 1 || len(report.Suites[0].Cases) != 2 {
+		t.Errorf("expected the dependency in the last suite, got %+v", report.Suites)
+	}
+	if deps := report.Suites[2].Cases; len(deps) == 1 && deps[0].ClassName != "dns-preload.hosts" {
+		t.Errorf("expected the classname of the dependency to keep its query type, got %s", deps[0].ClassName)
+	}
+	if report.Suites[0].Cases[0].Failure == nil || report.Suites[0].Cases[0].Name != testDomainWithErr {
+		t.Errorf("expected the first case to be the failed lookup for %s", testDomainWithErr)
+	}
+	if report.Suites[1].Cases[0].ClassName != "dns-preload.mx" {
+		t.Errorf("expected classname dns-preload.mx got %s", report.Suites[1].Cases[0].ClassName)
+	}
+	// only the cases sent to another server than the one of the run have a server of their own.
+	if props := report.Suites[1].Cases[0].Properties; len(props) != 1 || props[0].Value != "10.8.0.1:53" || len(report.Suites[0].Cases[0].Properties) != 0 {
+		t.Errorf("expected the server of the mx case only, got %+v", props)
+	}
+
+	html, err := os.ReadFile(filepath.Join(dir, htmlReportFile))
+	if err != nil {
+		t.Fatalf("html report was not written: %s", err)
+	}
+	for _, want := range []string{"<svg", testDomainWithErr, "nxdomain bar.foo"} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("html report does not contain %s", want)
+		}
+	}
+}
+
+func TestClassName(t *testing.T) {
+	tests := []struct {
+		qtype string
+		want  string
+	}{
+		{qtype: preload.QTypeA, want: "hosts"},
+		{qtype: preload.QTypePTR, want: "ptr"},
+		{qtype: "SRV, HTTPS", want: "srv-https"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.qtype, func(t *testing.T) {
+			if got := className(tt.qtype); got != tt.want {
+				t.Errorf("className() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
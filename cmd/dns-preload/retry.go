@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+)
+
+// retryPolicy merges the retry flags with the retry block of the configuration file, a flag
+// that was set on the command line takes precedence over the configuration file.
+func (p *Preload) retryPolicy(cfg *confighandlers.Configuration) dns.RetryPolicy {
+	policy := dns.RetryPolicy{
+		MaxAttempts: 1,
+		Backoff:     dns.DefaultRetryBackoff,
+		MaxBackoff:  dns.DefaultRetryMaxBackoff,
+	}
+	if cfg != nil && cfg.Retry != nil {
+		setIf(&policy.MaxAttempts, cfg.Retry.Attempts)
+		setIf(&policy.Backoff, cfg.Retry.Backoff)
+		setIf(&policy.MaxBackoff, cfg.Retry.MaxBackoff)
+		setIf(&policy.AttemptTimeout, cfg.Retry.Timeout)
+		setIf(&policy.Budget, cfg.Retry.Budget)
+	}
+	setIf(&policy.MaxAttempts, p.RetryAttempts)
+	setIf(&policy.Backoff, p.RetryBackoff)
+	setIf(&policy.MaxBackoff, p.RetryMaxBackoff)
+	setIf(&policy.AttemptTimeout, p.RetryTimeout)
+	setIf(&policy.Budget, p.RetryBudget)
+	return policy
+}
+
+// setIf sets dst to the value of src when src is not nil.
+func setIf[T any](dst *T, src *T) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+// attemptsSuffix describes the attempts of a lookup that was retried for the text output.
+func attemptsSuffix(attempts int) string {
+	if attempts <= 1 {
+		return ""
+	}
+	return fmt.Sprintf(" after %d attempts", attempts)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+)
+
+func TestPreloadRetryPolicy(t *testing.T) {
+	cfgAttempts := 4
+	cfgBackoff := 500 * time.Millisecond
+	cliAttempts := 2
+	tests := []struct {
+		name        string
+		flags       *int
+		cfg         *confighandlers.Configuration
+		wantAttempt int
+		wantBackoff time.Duration
+	}{
+		{
+			name:        "defaults",
+			cfg:         &confighandlers.Configuration{},
+			wantAttempt: 1,
+			wantBackoff: dns.DefaultRetryBackoff,
+		},
+		{
+			name:        "configuration file",
+			cfg:         &confighandlers.Configuration{Retry: &confighandlers.Retry{Attempts: &cfgAttempts, Backoff: &cfgBackoff}},
+			wantAttempt: 4,
+			wantBackoff: cfgBackoff,
+		},
+		{
+			name:        "command line takes precedence",
+			flags:       &cliAttempts,
+			cfg:         &confighandlers.Configuration{Retry: &confighandlers.Retry{Attempts: &cfgAttempts, Backoff: &cfgBackoff}},
+			wantAttempt: 2,
+			wantBackoff: cfgBackoff,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Preload{RetryAttempts: tt.flags}
+			policy := p.retryPolicy(tt.cfg)
+			if policy.MaxAttempts != tt.wantAttempt || policy.Backoff != tt.wantBackoff {
+				t.Errorf("retryPolicy() = %d attempts %s backoff, want %d and %s", policy.MaxAttempts, policy.Backoff, tt.wantAttempt, tt.wantBackoff)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+)
+
+// routes returns the routes of cfg for the preload engine. Each route has its own resolver with the
+// timeout of the run and waits for the rate limit of its server in servers, the replaced resolver of
+// a test is used for every route.
+func (p *Preload) routes(cfg *confighandlers.Configuration, timeout time.Duration, servers map[string][]*dns.TokenBucket) ([]dns.Route, error) {
+	if cfg == nil || len(cfg.Routes) == 0 {
+		return nil, nil
+	}
+	routes := make([]dns.Route, 0, len(cfg.Routes))
+	for i, r := range cfg.Routes {
+		transport, err := dns.ParseTransport(r.Transport)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %w", i+1, err)
+		}
+		port := strconv.Itoa(transport.DefaultPort())
+		if r.Port != 0 {
+			port = strconv.Itoa(r.Port)
+		}
+		address := net.JoinHostPort(r.Server, port)
+		match, err := routeMatch(r)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %w", i+1, err)
+		}
+		resolver := p.resolver
+		if resolver == nil {
+			resolver = dns.NewTransportResolver(address, transport, timeout)
+		}
+		name := r.Name
+		if name == "" {
+			name = address
+		}
+		routes = append(routes, dns.Route{
+			Name:       name,
+			Match:      match,
+			Server:     address,
+			Resolver:   resolver,
+			RateLimits: serverLimits(cfg.RateLimit, r.Server, port, servers),
+		})
+	}
+	return routes, nil
+}
+
+// routeMatch returns the function that matches the names of the route by their suffix or regex.
+func routeMatch(r confighandlers.Route) (func(name string) bool, error) {
+	suffix := dns.MatchSuffix(r.Suffixes...)
+	if r.Regex == "" {
+		return suffix, nil
+	}
+	re, err := regexp.Compile(r.Regex)
+	if err != nil {
+		return nil, err
+	}
+	return func(name string) bool {
+		return suffix(name) || re.MatchString(name)
+	}, nil
+}
+
+// routeSuffix returns the route of a lookup for the text output, empty without routes.
+func routeSuffix(route string) string {
+	if route == "" {
+		return ""
+	}
+	return " via " + route
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+)
+
+const testRoutesConfig = `---
+query_type:
+  hosts:
+    - foo.bar
+    - www.foo.bar
+  mx:
+    - foo.bar
+routes:
+  - name: vpn
+    suffixes: [www.foo.bar]
+    server: 10.8.0.1
+    transport: tcp
+  - regex: '^mx[0-9]\.'
+    server: 10.8.0.2
+    transport: tls
+`
+
+func TestPreloadRoutes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "routes.yaml")
+	if err := os.WriteFile(file, []byte(testRoutesConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := confighandlers.LoadConfigFromFile(&file)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	nameserver := net.JoinHostPort(testDNSServer, testDNSServerPort)
+	out, err := newEmitter(io.Discard, "json", nameserver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Preload{
+		Workers:    1,
+		Quiet:      true,
+		Mute:       true,
+		Full:       true,
+		OnError:    "continue",
+		resolver:   NewMockResolver(),
+		nameserver: nameserver,
+		out:        out,
+	}
+	// the mock fails some of the lookups, the failed lookups report their route as well.
+	_ = p.RunQueries(context.Background(), cmdAll, cfg)
+	got, servers := make(map[string]string), make(map[string]string)
+	for _, ev := range p.out.Lookups() {
+		got[ev.Name+" "+ev.QType] = ev.Route
+		servers[ev.Name+" "+ev.QType] = ev.Server
+	}
+	// the mx targets are looked up with --full and take the route of the regex.
+	want := map[string]string{
+		"foo.bar A, AAAA":     "default",
+		"www.foo.bar A, AAAA": "vpn",
+		"mx0.foo.bar A, AAAA": "10.8.0.2:853",
+		"mx1.foo.bar A, AAAA": "10.8.0.2:853",
+		"foo.bar MX":          "default",
+	}
+	for name, route := range want {
+		if got[name] != route {
+			t.Errorf("route of %s = %q, want %q", name, got[name], route)
+		}
+	}
+	// the lookups report the server they were sent to.
+	wantServers := map[string]string{
+		"foo.bar A, AAAA":     nameserver,
+		"www.foo.bar A, AAAA": "10.8.0.1:53",
+		"mx0.foo.bar A, AAAA": "10.8.0.2:853",
+	}
+	for name, server := range wantServers {
+		if servers[name] != server {
+			t.Errorf("server of %s = %q, want %q", name, servers[name], server)
+		}
+	}
+}
+
+func TestPreloadRoutesOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   confighandlers.Route
+		want    string
+		wantErr bool
+	}{
+		{name: "default port", route: confighandlers.Route{Suffixes: []string{"corp"}, Server: "10.8.0.1"}, want: "10.8.0.1:53"},
+		{name: "tls port", route: confighandlers.Route{Suffixes: []string{"corp"}, Server: "10.8.0.1", Transport: "tls"}, want: "10.8.0.1:853"},
+		{name: "name and port", route: confighandlers.Route{Name: "vpn", Suffixes: []string{"corp"}, Server: "10.8.0.1", Port: 5353}, want: "vpn"},
+		{name: "transport", route: confighandlers.Route{Server: "10.8.0.1", Transport: "quic"}, wantErr: true},
+		{name: "regex", route: confighandlers.Route{Server: "10.8.0.1", Regex: "[a-"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Preload{}
+			routes, err := p.routes(&confighandlers.Configuration{Routes: []confighandlers.Route{tt.route}}, time.Second, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("routes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (len(routes) != 1 || routes[0].Name != tt.want || !routes[0].Match("host.corp")) {
+				t.Errorf("routes() = %+v, want a route named %s for host.corp", routes, tt.want)
+			}
+		})
+	}
+}
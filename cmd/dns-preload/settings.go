@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+)
+
+const (
+	// envPrefix is the prefix of the environment variables of the flags, e.g. DNS_PRELOAD_SERVER.
+	envPrefix string = "DNS_PRELOAD"
+	// sources of the value of a flag, from the highest precedence to the lowest.
+	sourceFlag    string = "flag"
+	sourceEnv     string = "env"
+	sourceConfig  string = "config"
+	sourceDefault string = "default"
+)
+
+// flagSources returns the flags set on the command line or in their environment variable with the
+// source of their value.
+func flagSources(ctx *kong.Context) map[string]string {
+	sources := make(map[string]string)
+	for _, flag := range ctx.Flags() {
+		for _, env := range flag.Envs {
+			if _, ok := os.LookupEnv(env); ok {
+				sources[flag.Name] = sourceEnv
+			}
+		}
+	}
+	for _, path := range ctx.Path {
+		if path.Flag != nil {
+			sources[path.Flag.Name] = sourceFlag
+		}
+	}
+	return sources
+}
+
+// explicit returns true when the flag is set on the command line or in the environment, the
+// defaults of the configuration file do not replace it.
+func (p *Preload) explicit(flag string) bool {
+	source := p.sources[flag]
+	return source == sourceFlag || source == sourceEnv
+}
+
+// applySettings sets the flags that are not set on the command line or in the environment to the
+// settings of the configuration file.
+func (p *Preload) applySettings(s *confighandlers.Settings) error {
+	if s == nil {
+		return nil
+	}
+	if p.sources == nil {
+		p.sources = make(map[string]string)
+	}
+	setting(p, "server", &p.Server, s.Server)
+	if s.Port != nil {
+		port := strconv.Itoa(*s.Port)
+		setting(p, "port", &p.Port, &port)
+	}
+	setting(p, "timeout", &p.Timeout, s.Timeout)
+	if s.Workers != nil {
+		workers, err := parseWorkers(*s.Workers)
+		if err != nil {
+			return fmt.Errorf("settings: %w", err)
+		}
+		setting(p, "workers", &p.Workers, &workers)
+	}
+	setting(p, "mute", &p.Mute, s.Mute)
+	setting(p, "quiet", &p.Quiet, s.Quiet)
+	setting(p, "full", &p.Full, s.Full)
+	setting(p, "max-depth", &p.MaxDepth, s.MaxDepth)
+	setting(p, "ptr-forward", &p.PtrForward, s.PtrForward)
+	setting(p, "tree", &p.Tree, s.Tree)
+	setting(p, "debug", &p.Debug, s.Debug)
+	setting(p, "output", &p.Output, s.Output)
+	setting(p, "report-dir", &p.ReportDir, s.ReportDir)
+	setting(p, "slowest", &p.Slowest, s.Slowest)
+	setting(p, "on-error", &p.OnError, s.OnError)
+	setting(p, "error-action", &p.ErrorAction, s.ErrorAction)
+	setting(p, "order", &p.Order, s.Order)
+	setting(p, "max-runtime", &p.MaxRuntime, s.MaxRuntime)
+	if len(s.Tag) > 0 {
+		setting(p, "tag", &p.Tag, &s.Tag)
+	}
+	if len(s.ExcludeTag) > 0 {
+		setting(p, "exclude-tag", &p.ExcludeTag, &s.ExcludeTag)
+	}
+	return nil
+}
+
+// setting sets the flag to value when the value is set and the flag is not.
+func setting[T any](p *Preload, flag string, dst, value *T) {
+	if value == nil || p.explicit(flag) {
+		return
+	}
+	*dst = *value
+	p.sources[flag] = sourceConfig
+}
+
+// settings returns the effective value of every flag that can be set in the configuration file
+// with its source, e.g. "server=10.0.0.53 (config)".
+func (p *Preload) settings() []string {
+	workers := strconv.Itoa(int(p.Workers))
+	if p.Workers.auto() {
+		workers = workersAuto
+	}
+	values := []struct {
+		flag  string
+		value any
+	}{
+		{"server", p.Server}, {"port", p.Port}, {"timeout", p.Timeout}, {"workers", workers},
+		{"mute", p.Mute}, {"quiet", p.Quiet}, {"full", p.Full}, {"max-depth", p.MaxDepth},
+		{"ptr-forward", p.PtrForward}, {"tree", p.Tree}, {"debug", p.Debug}, {"output", p.Output},
+		{"report-dir", p.ReportDir}, {"slowest", p.Slowest}, {"on-error", p.OnError},
+		{"error-action", p.ErrorAction}, {"order", p.Order}, {"max-runtime", p.MaxRuntime},
+		{"tag", strings.Join(p.Tag, ",")}, {"exclude-tag", strings.Join(p.ExcludeTag, ",")},
+	}
+	lines := make([]string, 0, len(values))
+	for _, v := range values {
+		source := p.sources[v.flag]
+		if source == "" {
+			source = sourceDefault
+		}
+		lines = append(lines, fmt.Sprintf("%s=%v (%s)", v.flag, v.value, source))
+	}
+	return lines
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/alecthomas/kong"
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+)
+
+const testSettingsConfig = `---
+query_type:
+  hosts:
+    - foo.bar
+settings:
+  server: 10.0.0.53
+  port: 5353
+  workers: 4
+  timeout: 5s
+  full: false
+  tag: [office]
+`
+
+func TestPreloadSettings(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "settings.yaml")
+	if err := os.WriteFile(file, []byte(testSettingsConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want []string
+	}{
+		{
+			name: "config file",
+			want: []string{"server=10.0.0.53 (config)", "port=5353 (config)", "timeout=5s (config)", "workers=4 (config)", "full=false (config)", "tag=office (config)", "slowest=5 (default)"},
+		},
+		{
+			name: "environment",
+			env:  map[string]string{"DNS_PRELOAD_SERVER": "10.0.0.1", "DNS_PRELOAD_WORKERS": "auto", "DNS_PRELOAD_MAX_DEPTH": "3"},
+			want: []string{"server=10.0.0.1 (env)", "port=5353 (config)", "workers=auto (env)", "max-depth=3 (env)"},
+		},
+		{
+			name: "command line",
+			args: []string{"--server=127.0.0.1", "--full", "--tag=media"},
+			env:  map[string]string{"DNS_PRELOAD_SERVER": "10.0.0.1", "DNS_PRELOAD_TIMEOUT": "1s"},
+			want: []string{"server=127.0.0.1 (flag)", "timeout=1s (env)", "full=true (flag)", "tag=media (flag)", "workers=4 (config)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			var flags struct {
+				Hosts Preload `cmd:""`
+			}
+			parser, err := kong.New(&flags, kong.DefaultEnvars(envPrefix), kong.Exit(func(int) { t.FailNow() }))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, err := parser.Parse(append([]string{"hosts", "--config-file=" + file}, tt.args...))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			p := &flags.Hosts
+			p.sources = flagSources(ctx)
+			if _, err = p.load(context.Background()); err != nil {
+				t.Fatalf("load() error = %v", err)
+			}
+			got := p.settings()
+			for _, want := range tt.want {
+				if !slices.Contains(got, want) {
+					t.Errorf("settings() = %v, want %s", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPreloadSettingsGroups(t *testing.T) {
+	// the server of a group replaces the settings of the file but not the environment.
+	p := &Preload{Server: "10.0.0.53", Port: "53", sources: map[string]string{"server": sourceConfig, "port": sourceEnv}}
+	server, port := p.groupServer(&confighandlers.Group{Server: "10.1.1.1", Port: 5353})
+	if server != "10.1.1.1" || port != "53" {
+		t.Errorf("groupServer() = %s %s, want 10.1.1.1 53", server, port)
+	}
+}
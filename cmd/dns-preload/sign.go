@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+)
+
+const (
+	// the secret key is only readable by its owner, the signature and the public key are published.
+	secretKeyMode  = 0o600
+	publicFileMode = 0o644
+)
+
+// readPublicKey returns the public key of value, the path of a public key file or the key itself.
+func readPublicKey(value string) (*confighandlers.PublicKey, error) {
+	data, err := os.ReadFile(value)
+	switch {
+	case err == nil:
+		return confighandlers.ParsePublicKey(string(data))
+	case errors.Is(err, fs.ErrNotExist):
+		return confighandlers.ParsePublicKey(value)
+	}
+	return nil, err
+}
+
+// sign writes the detached signature of the configuration file of config sign.
+func (c *Config) sign(w io.Writer) error {
+	data, err := os.ReadFile(c.Sign.SecretKey)
+	if err != nil {
+		return err
+	}
+	key, err := confighandlers.ParseSecretKey(string(data))
+	if err != nil {
+		return err
+	}
+	if data, err = os.ReadFile(c.Sign.ConfigFile); err != nil {
+		return err
+	}
+	sig := c.Sign.ConfigFile + confighandlers.SignatureExt
+	if err = os.WriteFile(sig, key.Sign(data, c.Sign.ConfigFile), publicFileMode); err != nil {
+		return err
+	}
+	if !c.Quiet {
+		fmt.Fprintf(w, "Signed %s with key %s, the signature is in %s\n", c.Sign.ConfigFile, key.Public(), sig)
+	}
+	return nil
+}
+
+// keygen writes a new key pair for config sign, existing key files are only replaced with --force.
+func (c *Config) keygen(w io.Writer) error {
+	pub, secret, err := confighandlers.GenerateKey()
+	if err != nil {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !c.Keygen.Force {
+		flags |= os.O_EXCL
+	}
+	for _, file := range []struct {
+		path string
+		data []byte
+		mode os.FileMode
+	}{{c.Keygen.SecretKey, secret.Marshal(), secretKeyMode}, {c.Keygen.PublicKey, pub.Marshal(), publicFileMode}} {
+		f, err := os.OpenFile(file.path, flags, file.mode)
+		if err != nil {
+			return err
+		}
+		if _, err = f.Write(file.data); err != nil {
+			f.Close()
+			return err
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+	}
+	if !c.Quiet {
+		fmt.Fprintf(w, "The public key to pin with --config-public-key is %s\n", pub)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+)
+
+func TestConfigSign(t *testing.T) {
+	dir := t.TempDir()
+	c := &Config{Quiet: true}
+	c.Keygen.SecretKey = filepath.Join(dir, "dns-preload.key")
+	c.Keygen.PublicKey = filepath.Join(dir, "dns-preload.pub")
+	if err := c.Run("config keygen"); err != nil {
+		t.Fatalf("Config.Run(config keygen) error = %v", err)
+	}
+	if err := c.Run("config keygen"); err == nil {
+		t.Fatalf("Config.Run(config keygen) expected an error for existing key files without --force")
+	}
+	file := filepath.Join(dir, "dns-preload.yaml")
+	if err := os.WriteFile(file, []byte("query_type:\n  hosts: [foo.bar]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c.Sign.ConfigFile, c.Sign.SecretKey = file, c.Keygen.SecretKey
+	if err := c.sign(io.Discard); err != nil {
+		t.Fatalf("Config.sign() error = %v", err)
+	}
+	// a file written with the mode of the published files under the umask of the test.
+	published := filepath.Join(dir, "published")
+	if err := os.WriteFile(published, nil, publicFileMode); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path string
+		mode string
+	}{
+		{c.Keygen.SecretKey, "-rw-------"},
+		{c.Keygen.PublicKey, fileMode(t, published)},
+		{file + confighandlers.SignatureExt, fileMode(t, published)},
+	} {
+		if got := fileMode(t, tt.path); got != tt.mode {
+			t.Errorf("%s has mode %s, want %s", tt.path, got, tt.mode)
+		}
+	}
+	pub, err := os.ReadFile(c.Keygen.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(pub)), "\n")
+	for _, key := range []string{c.Keygen.PublicKey, lines[len(lines)-1]} {
+		p := &Preload{ConfigFile: file, ConfigPublicKey: key}
+		if _, err = p.load(context.Background()); err != nil {
+			t.Errorf("Preload.load() with the public key %s error = %v", key, err)
+		}
+	}
+	c.Keygen.Force = true
+	if err = c.Run("config keygen"); err != nil {
+		t.Fatalf("Config.Run(config keygen) with --force error = %v", err)
+	}
+	p := &Preload{ConfigFile: file, ConfigPublicKey: c.Keygen.PublicKey}
+	if _, err = p.load(context.Background()); err == nil || !strings.Contains(err.Error(), "signed with key") {
+		t.Errorf("Preload.load() error = %v, want the signature of the old key to be rejected", err)
+	}
+}
+
+// fileMode returns the permissions of the file at path.
+func fileMode(t *testing.T, path string) string {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Mode().Perm().String()
+}
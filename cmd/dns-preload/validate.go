@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+)
+
+// exitCodeInvalidConfig is used when config validate finds errors in the configuration.
+const exitCodeInvalidConfig int = 2
+
+// validationReport is the json output of config validate.
+//
+//nolint:govet // fieldalignment is not required here, field order matches the json output.
+type validationReport struct {
+	File        string                      `json:"file"`
+	Valid       bool                        `json:"valid"`
+	Errors      int                         `json:"errors"`
+	Warnings    int                         `json:"warnings"`
+	Diagnostics []confighandlers.Diagnostic `json:"diagnostics"`
+}
+
+// invalidConfigError is returned by config validate when the configuration has errors, or warnings
+// with --strict.
+type invalidConfigError struct {
+	file     string
+	errors   int
+	warnings int
+}
+
+func (e *invalidConfigError) Error() string {
+	return fmt.Sprintf("%s is not valid: %d errors, %d warnings", e.file, e.errors, e.warnings)
+}
+
+// ExitCode implements kong.ExitCoder.
+func (e *invalidConfigError) ExitCode() int {
+	return exitCodeInvalidConfig
+}
+
+// validate checks the configuration file and writes every diagnostic to w, one per line in text
+// mode or as a single document in json mode.
+func (c *Config) validate(w io.Writer) error {
+	diags, err := confighandlers.ValidateFiles(c.Validate.ConfigFile, c.Validate.ConfDir)
+	if err != nil {
+		return err
+	}
+	report := validationReport{File: c.Validate.ConfigFile, Diagnostics: diags}
+	report.Errors = len(confighandlers.Errors(diags))
+	report.Warnings = len(diags) - report.Errors
+	report.Valid = report.Errors == 0 && (!c.Validate.Strict || report.Warnings == 0)
+
+	switch {
+	case c.Validate.Output == outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(report); err != nil {
+			return err
+		}
+	case !c.Quiet:
+		for _, d := range diags {
+			if _, err = fmt.Fprintln(w, d); err != nil {
+				return err
+			}
+		}
+		if report.Valid {
+			_, err = fmt.Fprintf(w, "%s is valid: %d warnings\n", report.File, report.Warnings)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !report.Valid {
+		return &invalidConfigError{file: report.File, errors: report.Errors, warnings: report.Warnings}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		output    string
+		strict    bool
+		wantLines int
+		wantErr   bool
+	}{
+		{name: "valid", file: "complete_config_sample.yaml", output: outputText, wantLines: 1},
+		{name: "warnings", file: "basic_test_no_cname_config.yaml", output: outputText, wantLines: 3},
+		{name: "warnings strict", file: "basic_test_no_cname_config.yaml", output: outputText, strict: true, wantLines: 2, wantErr: true},
+		{name: "errors", file: "invalid_config_sample.yaml", output: outputText, wantLines: 11, wantErr: true},
+		{name: "errors json", file: "invalid_config_sample.yaml", output: outputJSON, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{}
+			c.Validate.ConfigFile = "../../pkg/confighandlers/test_data/" + tt.file
+			c.Validate.Output = tt.output
+			c.Validate.Strict = tt.strict
+			buf := &bytes.Buffer{}
+			err := c.validate(buf)
+			var invalid *invalidConfigError
+			if (err != nil) != tt.wantErr || (err != nil && (!errors.As(err, &invalid) || invalid.ExitCode() != exitCodeInvalidConfig)) {
+				t.Fatalf("Config.validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.output == outputJSON {
+				report := &validationReport{}
+				if err = json.Unmarshal(buf.Bytes(), report); err != nil {
+					t.Fatalf("output is not valid json: %s", err)
+				}
+				if report.Valid || report.Errors != 9 || report.Warnings != 2 || report.Diagnostics[0].Line != 3 {
+					t.Errorf("unexpected report %+v", report)
+				}
+				return
+			}
+			if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != tt.wantLines {
+				t.Errorf("expected %d lines of output, got %d:\n%s", tt.wantLines, len(lines), buf)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/alecthomas/kong"
+	"github.com/jimmystewpot/dns-preload/pkg/preload"
+)
+
+const (
+	// workersAuto is the --workers value that enables the adaptive concurrency controller.
+	workersAuto string      = "auto"
+	autoWorkers WorkerCount = -1
+)
+
+// WorkerCount is the value of the --workers flag, a fixed number of workers or auto.
+type WorkerCount int
+
+// Decode implements kong.MapperValue so the flag accepts a number or auto.
+func (w *WorkerCount) Decode(ctx *kong.DecodeContext) error {
+	var s string
+	if err := ctx.Scan.PopValueInto("workers", &s); err != nil {
+		return err
+	}
+	n, err := parseWorkers(s)
+	if err != nil {
+		return err
+	}
+	*w = n
+	return nil
+}
+
+// parseWorkers parses a number of workers or auto.
+func parseWorkers(s string) (WorkerCount, error) {
+	if s == workersAuto {
+		return autoWorkers, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > preload.MaxWorkers {
+		return 0, fmt.Errorf("invalid workers %s, expected auto or a number between 0 and %d", s, preload.MaxWorkers)
+	}
+	return WorkerCount(n), nil
+}
+
+// auto returns true when the adaptive controller chooses the number of workers.
+func (w WorkerCount) auto() bool {
+	return w == autoWorkers
+}
+
+// debugf prints a debug message, it is written to stderr in the structured output modes so the
+// output stays parseable.
+func (p *Preload) debugf(format string, args ...any) {
+	w := os.Stdout
+	if p.out.structured() {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, format+"\n", args...)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/alecthomas/kong"
+)
+
+func TestWorkerCountDecode(t *testing.T) {
+	tests := []struct {
+		arg     string
+		want    WorkerCount
+		wantErr bool
+	}{
+		{arg: "auto", want: autoWorkers},
+		{arg: "16", want: 16},
+		{arg: "1000", want: 1000},
+		{arg: "-1", wantErr: true},
+		{arg: "2000", wantErr: true},
+		{arg: "many", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			var flags struct {
+				Workers WorkerCount `default:"2"`
+			}
+			parser, err := kong.New(&flags)
+			if err != nil {
+				t.Fatalf("kong.New() error = %v", err)
+			}
+			_, err = parser.Parse([]string{"--workers=" + tt.arg})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(--workers=%s) error = %v, wantErr %v", tt.arg, err, tt.wantErr)
+			}
+			if !tt.wantErr && flags.Workers != tt.want {
+				t.Errorf("Parse(--workers=%s) = %d, want %d", tt.arg, flags.Workers, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+// Package adaptive provides a concurrency limit that adjusts itself to the health of the server
+// that is being queried.
+package adaptive
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultInitial is the concurrency limit before any lookups complete.
+	DefaultInitial int = 2
+	// DefaultMax caps the concurrency limit.
+	DefaultMax int = 1024
+	// DefaultTolerance is how far the latency of a window may rise above the baseline before the
+	// limit is reduced.
+	DefaultTolerance float64 = 2
+	// DefaultBackoff multiplies the limit when it is reduced.
+	DefaultBackoff float64 = 0.5
+	// DefaultErrorRate is the share of failed lookups in a window that reduces the limit.
+	DefaultErrorRate float64 = 0.05
+	// baselineDrift lets the baseline rise by a twentieth per window so a server that became slower
+	// for good does not keep the limit at its minimum.
+	baselineDrift = 20
+)
+
+// Config configures a Controller, zero values use the defaults.
+//
+//nolint:govet // fieldalignment is not required here.
+type Config struct {
+	Initial   int
+	Min       int
+	Max       int
+	Tolerance float64
+	Backoff   float64
+	ErrorRate float64
+	// Logf receives every change to the limit, nil discards them.
+	Logf func(format string, args ...any)
+}
+
+// Controller is an additive increase, multiplicative decrease (AIMD) concurrency limit. After every
+// window of completed lookups the limit grows by one while the latency and error rate stay healthy,
+// it is cut by the backoff factor when the latency rises above the tolerated multiple of the
+// baseline or too many lookups fail with an overload error. A nil Controller does not limit.
+//
+//nolint:govet // fieldalignment is not required here.
+type Controller struct {
+	mu       sync.Mutex
+	cfg      Config
+	limit    float64
+	inflight int
+	wake     chan struct{}
+	baseline time.Duration
+	// the samples of the current window.
+	completed int
+	failed    int
+	latency   time.Duration
+}
+
+// New returns a Controller for cfg.
+func New(cfg Config) *Controller {
+	if cfg.Min < 1 {
+		cfg.Min = 1
+	}
+	if cfg.Max < 1 {
+		cfg.Max = DefaultMax
+	}
+	cfg.Max = max(cfg.Max, cfg.Min)
+	if cfg.Initial < 1 {
+		cfg.Initial = DefaultInitial
+	}
+	if cfg.Tolerance <= 1 {
+		cfg.Tolerance = DefaultTolerance
+	}
+	if cfg.Backoff <= 0 || cfg.Backoff >= 1 {
+		cfg.Backoff = DefaultBackoff
+	}
+	if cfg.ErrorRate <= 0 {
+		cfg.ErrorRate = DefaultErrorRate
+	}
+	return &Controller{
+		cfg:   cfg,
+		limit: float64(min(max(cfg.Initial, cfg.Min), cfg.Max)),
+		wake:  make(chan struct{}),
+	}
+}
+
+// Limit returns the current concurrency limit.
+func (c *Controller) Limit() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int(c.limit)
+}
+
+// Acquire blocks until fewer lookups than the limit are in flight or ctx is done.
+func (c *Controller) Acquire(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	for {
+		c.mu.Lock()
+		if c.inflight < int(c.limit) {
+			c.inflight++
+			c.mu.Unlock()
+			return nil
+		}
+		wake := c.wake
+		c.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// Release frees the slot taken by Acquire.
+func (c *Controller) Release() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inflight--
+	c.broadcast()
+}
+
+// Observe records the latency of a completed lookup, overloaded is set when it failed in a way that
+// suggests the server is struggling such as a timeout or SERVFAIL.
+func (c *Controller) Observe(latency time.Duration, overloaded bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.completed++
+	c.latency += latency
+	if overloaded {
+		c.failed++
+	}
+	if c.completed >= max(int(c.limit), 1) {
+		c.adjust()
+	}
+}
+
+// adjust closes the current window and changes the limit, it must be called with mu held.
+func (c *Controller) adjust() {
+	avg := c.latency / time.Duration(c.completed)
+	rate := float64(c.failed) / float64(c.completed)
+	previous := int(c.limit)
+	var reason string
+	switch {
+	case rate > c.cfg.ErrorRate:
+		reason = "error rate"
+		c.limit = max(float64(c.cfg.Min), c.limit*c.cfg.Backoff)
+	case c.baseline > 0 && float64(avg) > float64(c.baseline)*c.cfg.Tolerance:
+		reason = "latency"
+		c.limit = max(float64(c.cfg.Min), c.limit*c.cfg.Backoff)
+	default:
+		reason = "healthy"
+		c.limit = min(float64(c.cfg.Max), c.limit+1)
+	}
+	if c.baseline == 0 || avg < c.baseline {
+		c.baseline = avg
+	} else {
+		c.baseline = min(avg, c.baseline+c.baseline/baselineDrift)
+	}
+	if int(c.limit) != previous && c.cfg.Logf != nil {
+		c.cfg.Logf("adaptive workers: %d -> %d (%s, latency %s, baseline %s, errors %d/%d)",
+			previous, int(c.limit), reason, avg, c.baseline, c.failed, c.completed)
+	}
+	c.completed, c.failed, c.latency = 0, 0, 0
+	c.broadcast()
+}
+
+// broadcast wakes every goroutine waiting in Acquire, it must be called with mu held.
+func (c *Controller) broadcast() {
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
+
+// String describes the current state of the controller.
+func (c *Controller) String() string {
+	if c == nil {
+		return "fixed"
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fmt.Sprintf("auto (limit %d, in flight %d, baseline %s)", int(c.limit), c.inflight, c.baseline)
+}
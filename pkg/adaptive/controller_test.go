@@ -0,0 +1,101 @@
+package adaptive
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestControllerAdjust(t *testing.T) {
+	tests := []struct {
+		name       string
+		initial    int
+		latency    time.Duration
+		overloaded bool
+		want       int
+	}{
+		{
+			name:    "healthy window grows the limit by one",
+			initial: 4,
+			latency: 10 * time.Millisecond,
+			want:    5,
+		},
+		{
+			name:       "overload errors halve the limit",
+			initial:    4,
+			latency:    10 * time.Millisecond,
+			overloaded: true,
+			want:       2,
+		},
+		{
+			name:    "latency above the tolerance halves the limit",
+			initial: 4,
+			latency: 50 * time.Millisecond,
+			want:    2,
+		},
+		{
+			name:       "the limit does not drop below the minimum",
+			initial:    1,
+			latency:    10 * time.Millisecond,
+			overloaded: true,
+			want:       1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var decisions int
+			c := New(Config{Initial: tt.initial, Logf: func(string, ...any) { decisions++ }})
+			// the first window sets a 10ms baseline, it is healthy so the limit grows by one.
+			for i := 0; i < tt.initial; i++ {
+				c.Observe(10*time.Millisecond, false)
+			}
+			c.limit = float64(tt.initial)
+			for i := 0; i < tt.initial; i++ {
+				c.Observe(tt.latency, tt.overloaded)
+			}
+			if got := c.Limit(); got != tt.want {
+				t.Errorf("Limit() = %d, want %d", got, tt.want)
+			}
+			if tt.want != tt.initial && decisions != 2 {
+				t.Errorf("expected the decisions to be logged, got %d", decisions)
+			}
+		})
+	}
+}
+
+func TestControllerAcquire(t *testing.T) {
+	c := New(Config{Initial: 1})
+	if err := c.Acquire(context.Background()); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Acquire(ctx); err == nil {
+		t.Fatalf("Acquire() beyond the limit expected the context to expire first")
+	}
+	done := make(chan error)
+	go func() {
+		done <- c.Acquire(context.Background())
+	}()
+	c.Release()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Acquire() after Release() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Acquire() was not woken by Release()")
+	}
+}
+
+func TestNilController(t *testing.T) {
+	var c *Controller
+	if err := c.Acquire(context.Background()); err != nil {
+		t.Errorf("nil Controller Acquire() error = %v", err)
+	}
+	c.Release()
+	c.Observe(time.Second, true)
+	if c.Limit() != 0 || c.String() != "fixed" {
+		t.Errorf("unexpected nil Controller state %d %s", c.Limit(), c)
+	}
+}
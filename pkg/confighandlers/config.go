@@ -2,6 +2,8 @@ package confighandlers
 
 import (
 	"fmt"
+	"net"
+	"time"
 
 	yaml "gopkg.in/yaml.v3"
 )
@@ -13,16 +15,155 @@ const (
 	Cname      string = "cname"
 	Hosts      string = "hosts"
 	Ptr        string = "ptr"
+	Srv        string = "srv"
+	HTTPS      string = "https"
 	nilRecords uint16 = 0
 )
 
 var (
 	// queryTypes is used to iterate through all of the commands when the all cmd is used.
-	QueryTypes = []string{Hosts, Cname, Mx, Ns, Txt, Ptr}
+	QueryTypes = []string{Hosts, Cname, Mx, Ns, Txt, Ptr, Srv, HTTPS}
 )
 
 type Configuration struct {
-	QueryType QueryType `yaml:"query_type" json:"query_type" validate:"required"`
+	// Version of the file format, 1 when it is not set. Version 1 lists the names under QueryType by
+	// query type, version 2 lists them under Names with their query types.
+	Version   int        `yaml:"version,omitempty" json:"version,omitempty"`
+	QueryType QueryType  `yaml:"query_type" json:"query_type" validate:"required"`
+	Names     []Name     `yaml:"names,omitempty" json:"names,omitempty"`
+	Retry     *Retry     `yaml:"retry,omitempty" json:"retry,omitempty"`
+	RateLimit *RateLimit `yaml:"rate_limit,omitempty" json:"rate_limit,omitempty"`
+	// Groups of names by group name, each group lists its names in the layout of the version and
+	// has its own tags and defaults.
+	Groups map[string]*Group `yaml:"groups,omitempty" json:"groups,omitempty"`
+	// Routes send the lookups of the names they match to another server, the first route that
+	// matches a name wins and the other names are preloaded from the server of the run.
+	Routes []Route `yaml:"routes,omitempty" json:"routes,omitempty"`
+	// Include lists the files and globs of other configuration files to merge into this one, they
+	// are relative to the directory of the file.
+	Include []string `yaml:"include,omitempty,flow" json:"include,omitempty"`
+	// Settings are the defaults of the flags of the preload commands.
+	Settings *Settings `yaml:"settings,omitempty" json:"settings,omitempty"`
+	// entries are the names of the configuration with their group and tags by query type and name,
+	// see Entries.
+	entries map[string][]Entry
+}
+
+// Name is a name of a version 2 configuration and the query types it is preloaded as.
+type Name struct {
+	Name  string   `yaml:"name" json:"name"`
+	Types []string `yaml:"types,flow" json:"types"`
+	// Tags select the name with --tag and --exclude-tag.
+	Tags []string `yaml:"tags,omitempty,flow" json:"tags,omitempty"`
+	// Priority of the name, it replaces the priority of its group.
+	Priority *int `yaml:"priority,omitempty" json:"priority,omitempty"`
+}
+
+// Group is a named set of names, the group name is a tag of every name in it. The defaults are used
+// for the names of the group when the command line flag is not set, the priority is the priority of
+// every name of the group that does not set its own.
+//
+//nolint:govet // fieldalignment is not required here, field order matches the file.
+type Group struct {
+	Tags    []string       `yaml:"tags,omitempty,flow" json:"tags,omitempty"`
+	Server  string         `yaml:"server,omitempty" json:"server,omitempty" validate:"omitempty,hostname_rfc1123|ip_addr"`
+	Port    int            `yaml:"port,omitempty" json:"port,omitempty" validate:"omitempty,gte=1,lte=65535"`
+	Workers int            `yaml:"workers,omitempty" json:"workers,omitempty" validate:"omitempty,gte=1"`
+	Timeout *time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty" validate:"omitempty,gt=0"`
+	// Priority orders the names across every query type, higher priorities are preloaded first.
+	Priority int `yaml:"priority,omitempty" json:"priority,omitempty"`
+	// QueryType lists the names of the group in a version 1 configuration.
+	QueryType QueryType `yaml:"query_type,omitempty" json:"query_type,omitempty" validate:"-"`
+	// Names lists the names of the group in a version 2 configuration.
+	Names []Name `yaml:"names,omitempty" json:"names,omitempty" validate:"-"`
+}
+
+// Route sends the lookups of the names equal to or below one of Suffixes, or matching Regex, to
+// Server over Transport. The names are matched in lower case without the trailing dot and the
+// addresses of PTR lookups by their name under in-addr.arpa or ip6.arpa.
+//
+//nolint:govet // fieldalignment is not required here, field order matches the file.
+type Route struct {
+	// Name identifies the route in the output, it defaults to the address of the server.
+	Name     string   `yaml:"name,omitempty" json:"name,omitempty"`
+	Suffixes []string `yaml:"suffixes,omitempty,flow" json:"suffixes,omitempty" validate:"-"`
+	Regex    string   `yaml:"regex,omitempty" json:"regex,omitempty" validate:"-"`
+	Server   string   `yaml:"server" json:"server" validate:"required,hostname_rfc1123|ip_addr"`
+	// Port of the server, 53 or 853 for tls when it is not set.
+	Port int `yaml:"port,omitempty" json:"port,omitempty" validate:"omitempty,gte=1,lte=65535"`
+	// Transport is udp, the default, tcp or tls.
+	Transport string `yaml:"transport,omitempty" json:"transport,omitempty" validate:"omitempty,oneof=udp tcp tls"`
+}
+
+// Settings are the defaults of the flags of the preload commands, each key is the name of a flag
+// with underscores. The flags and their DNS_PRELOAD_* environment variables take precedence, the
+// retries and rate limits are set in the retry and rate_limit blocks.
+//
+//nolint:govet // fieldalignment is not required here, field order matches the flags.
+type Settings struct {
+	Server  *string        `yaml:"server,omitempty" json:"server,omitempty" validate:"omitempty,hostname_rfc1123|ip_addr"`
+	Port    *int           `yaml:"port,omitempty" json:"port,omitempty" validate:"omitempty,gte=1,lte=65535"`
+	Timeout *time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty" validate:"omitempty,gt=0"`
+	// Workers is a number of workers or auto.
+	Workers     *string        `yaml:"workers,omitempty" json:"workers,omitempty" validate:"omitempty,number|eq=auto"`
+	Mute        *bool          `yaml:"mute,omitempty" json:"mute,omitempty"`
+	Quiet       *bool          `yaml:"quiet,omitempty" json:"quiet,omitempty"`
+	Full        *bool          `yaml:"full,omitempty" json:"full,omitempty"`
+	MaxDepth    *int           `yaml:"max_depth,omitempty" json:"max_depth,omitempty" validate:"omitempty,gte=1"`
+	PtrForward  *bool          `yaml:"ptr_forward,omitempty" json:"ptr_forward,omitempty"`
+	Tree        *bool          `yaml:"tree,omitempty" json:"tree,omitempty"`
+	Debug       *bool          `yaml:"debug,omitempty" json:"debug,omitempty"`
+	Output      *string        `yaml:"output,omitempty" json:"output,omitempty" validate:"omitempty,oneof=text json ndjson"`
+	ReportDir   *string        `yaml:"report_dir,omitempty" json:"report_dir,omitempty"`
+	Slowest     *int           `yaml:"slowest,omitempty" json:"slowest,omitempty" validate:"omitempty,gte=0"`
+	OnError     *string        `yaml:"on_error,omitempty" json:"on_error,omitempty"`
+	ErrorAction *string        `yaml:"error_action,omitempty" json:"error_action,omitempty"`
+	Order       *string        `yaml:"order,omitempty" json:"order,omitempty"`
+	MaxRuntime  *time.Duration `yaml:"max_runtime,omitempty" json:"max_runtime,omitempty" validate:"omitempty,gte=0"`
+	Tag         []string       `yaml:"tag,omitempty,flow" json:"tag,omitempty"`
+	ExcludeTag  []string       `yaml:"exclude_tag,omitempty,flow" json:"exclude_tag,omitempty"`
+}
+
+// RateLimit configures the rate of queries sent during a run, the command line flags take precedence.
+type RateLimit struct {
+	// QPS is the number of queries per second for the whole run, zero is unlimited.
+	QPS *float64 `yaml:"qps" json:"qps" validate:"omitempty,gte=0"`
+	// Burst is the number of queries that may be sent at once, it defaults to the QPS.
+	Burst *int `yaml:"burst" json:"burst" validate:"omitempty,gte=0"`
+	// Servers limits the queries sent to a single server, keyed by address with an optional port.
+	Servers map[string]ServerRateLimit `yaml:"servers,omitempty" json:"servers,omitempty" validate:"dive"`
+}
+
+// ServerRateLimit is the rate limit for a single server.
+type ServerRateLimit struct {
+	QPS   float64 `yaml:"qps" json:"qps" validate:"gt=0"`
+	Burst int     `yaml:"burst" json:"burst" validate:"gte=0"`
+}
+
+// ServerLimit returns the rate limit for the server at host and port, an entry for host and port
+// takes precedence over an entry for the host alone.
+func (rl *RateLimit) ServerLimit(host, port string) (ServerRateLimit, bool) {
+	if rl == nil {
+		return ServerRateLimit{}, false
+	}
+	if limit, ok := rl.Servers[net.JoinHostPort(host, port)]; ok {
+		return limit, true
+	}
+	limit, ok := rl.Servers[host]
+	return limit, ok
+}
+
+// Retry configures how failed lookups are retried, the command line flags take precedence.
+type Retry struct {
+	// Attempts for each lookup including the first.
+	Attempts *int `yaml:"attempts" json:"attempts" validate:"omitempty,gte=1"`
+	// Backoff before the first retry, it doubles for every retry up to MaxBackoff.
+	Backoff    *time.Duration `yaml:"backoff" json:"backoff" validate:"omitempty,gte=0"`
+	MaxBackoff *time.Duration `yaml:"max_backoff" json:"max_backoff" validate:"omitempty,gte=0"`
+	// Timeout for each attempt.
+	Timeout *time.Duration `yaml:"timeout" json:"timeout" validate:"omitempty,gte=0"`
+	// Budget of retries for the whole run, zero is unlimited.
+	Budget *int `yaml:"budget" json:"budget" validate:"omitempty,gte=0"`
 }
 
 // QueryType lsits out the structure for the different domains and their query type
@@ -39,6 +180,10 @@ type QueryType struct {
 	TXT []string `yaml:"txt" json:"txt" validate:"dive,fqdn"`
 	// PTR for doing a query for type PTR
 	PTR []string `yaml:"ptr" json:"ptr" validate:"dive,ip_addr"`
+	// SRV for doing a query for type SRV, the names are service names such as _sip._tcp.example.com
+	SRV []string `yaml:"srv" json:"srv" validate:"dive,srv_name"`
+	// HTTPS for doing a query for type HTTPS
+	HTTPS []string `yaml:"https" json:"https" validate:"dive,fqdn"`
 	// Metrics values below this point.
 	CnameCount uint16 `yaml:",omitempty"`
 	HostsCount uint16 `yaml:",omitempty"`
@@ -46,6 +191,31 @@ type QueryType struct {
 	MXCount    uint16 `yaml:",omitempty"`
 	TXTCount   uint16 `yaml:",omitempty"`
 	PTRCount   uint16 `yaml:",omitempty"`
+	SRVCount   uint16 `yaml:",omitempty"`
+	HTTPSCount uint16 `yaml:",omitempty"`
+}
+
+// List returns the list of names of the query type typ, or nil for an unknown query type.
+func (q *QueryType) List(typ string) *[]string {
+	switch typ {
+	case Hosts:
+		return &q.Hosts
+	case Cname:
+		return &q.Cname
+	case Mx:
+		return &q.MX
+	case Ns:
+		return &q.NS
+	case Txt:
+		return &q.TXT
+	case Ptr:
+		return &q.PTR
+	case Srv:
+		return &q.SRV
+	case HTTPS:
+		return &q.HTTPS
+	}
+	return nil
 }
 
 // PopulateCounts for how many domains are in each query_type.
@@ -75,6 +245,14 @@ func (cfg *Configuration) PopulateCounts() error {
 	if err != nil {
 		return err
 	}
+	cfg.QueryType.SRVCount, err = count(cfg.QueryType.SRV)
+	if err != nil {
+		return err
+	}
+	cfg.QueryType.HTTPSCount, err = count(cfg.QueryType.HTTPS)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
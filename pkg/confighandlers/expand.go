@@ -0,0 +1,155 @@
+package confighandlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxExpansions is the number of names the templates of a configuration file can expand to.
+const MaxExpansions int = maxNames
+
+// errExpansionLimit is returned by Expand when a template expands to more names than its limit.
+var errExpansionLimit = errors.New("too many names")
+
+// IsTemplate returns true when name has a brace list or a numeric range to expand, see Expand.
+func IsTemplate(name string) bool {
+	return strings.ContainsAny(name, "{}[]")
+}
+
+// Expand returns the names of a template in order. {www,api,cdn}.example.com is a name for each
+// alternative, an alternative can be empty or a template itself, and node[01-24].cluster.local is
+// a name for each number of the range padded to the width of the first number when it starts with
+// a zero. A template that expands to more than limit names is an error.
+func Expand(template string, limit int) ([]string, error) {
+	names, err := expand(template, limit)
+	if errors.Is(err, errExpansionLimit) {
+		return nil, fmt.Errorf("%q expands to more than %d names: %w", template, limit, err)
+	}
+	return names, err
+}
+
+// expand returns the names of template, the first brace list or range is expanded and combined
+// with the names of the rest of the template.
+func expand(template string, limit int) ([]string, error) {
+	start := strings.IndexAny(template, "{}[]")
+	if start < 0 {
+		return []string{template}, nil
+	}
+	var (
+		values []string
+		end    int
+		err    error
+	)
+	switch template[start] {
+	case '{':
+		values, end, err = braces(template, start, limit)
+	case '[':
+		values, end, err = numbers(template, start, limit)
+	default:
+		return nil, fmt.Errorf("unmatched %c at position %d", template[start], start+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rest, err := expand(template[end+1:], limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(values)*len(rest) > limit {
+		return nil, errExpansionLimit
+	}
+	names := make([]string, 0, len(values)*len(rest))
+	for _, value := range values {
+		for _, r := range rest {
+			names = append(names, template[:start]+value+r)
+		}
+	}
+	return names, nil
+}
+
+// braces returns the names of the alternatives of the brace list at start and the position of
+// its closing brace.
+func braces(template string, start, limit int) ([]string, int, error) {
+	depth, from := 0, start+1
+	var alternatives []string
+	for i := start; i < len(template); i++ {
+		switch template[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 1 {
+				alternatives = append(alternatives, template[from:i])
+				from = i + 1
+			}
+		}
+		if depth > 0 {
+			continue
+		}
+		alternatives = append(alternatives, template[from:i])
+		if len(alternatives) < 2 {
+			return nil, 0, fmt.Errorf("the braces at position %d need at least two alternatives separated by commas", start+1)
+		}
+		var names []string
+		for _, alternative := range alternatives {
+			expanded, err := expand(alternative, limit-len(names))
+			if err != nil {
+				return nil, 0, err
+			}
+			names = append(names, expanded...)
+		}
+		return names, i, nil
+	}
+	return nil, 0, fmt.Errorf("unclosed { at position %d", start+1)
+}
+
+// numbers returns the numbers of the range at start and the position of its closing bracket.
+func numbers(template string, start, limit int) ([]string, int, error) {
+	end := strings.IndexByte(template[start:], ']')
+	if end < 0 {
+		return nil, 0, fmt.Errorf("unclosed [ at position %d", start+1)
+	}
+	end += start
+	spec := template[start+1 : end]
+	first, last, ok := strings.Cut(spec, "-")
+	from, errFrom := strconv.Atoi(first)
+	to, errTo := strconv.Atoi(last)
+	switch {
+	case !ok || errFrom != nil || errTo != nil || from < 0 || len(first) > 9 || len(last) > 9:
+		return nil, 0, fmt.Errorf("invalid range [%s] at position %d, expected [first-last] such as [01-24]", spec, start+1)
+	case to < from:
+		return nil, 0, fmt.Errorf("the range [%s] at position %d ends before it starts", spec, start+1)
+	case to-from+1 > limit:
+		return nil, 0, errExpansionLimit
+	}
+	width := 0
+	if len(first) > 1 && first[0] == '0' {
+		width = len(first)
+	}
+	names := make([]string, 0, to-from+1)
+	for n := from; n <= to; n++ {
+		names = append(names, fmt.Sprintf("%0*d", width, n))
+	}
+	return names, end, nil
+}
+
+// expandName returns the names of the template name, or name itself when it is not a template.
+// budget is the number of names the templates of the file can still expand to, it is reduced by
+// the names of the template.
+func expandName(name string, budget *int) ([]string, error) {
+	if !IsTemplate(name) {
+		return []string{name}, nil
+	}
+	names, err := Expand(name, *budget)
+	if err != nil {
+		if errors.Is(err, errExpansionLimit) {
+			return nil, fmt.Errorf("%q expands past the limit of %d names for the templates of a file", name, MaxExpansions)
+		}
+		return nil, fmt.Errorf("invalid template %q: %w", name, err)
+	}
+	*budget -= len(names)
+	return names, nil
+}
@@ -0,0 +1,133 @@
+package confighandlers
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		limit    int
+		want     []string
+		wantErr  string
+	}{
+		{name: "not a template", template: "example.com", limit: 10, want: []string{"example.com"}},
+		{name: "braces", template: "{www,api,cdn}.example.com", limit: 10,
+			want: []string{"www.example.com", "api.example.com", "cdn.example.com"}},
+		{name: "top level domains", template: "www.example.{com,net}", limit: 10, want: []string{"www.example.com", "www.example.net"}},
+		{name: "empty alternative", template: "{,www.}example.com", limit: 10, want: []string{"example.com", "www.example.com"}},
+		{name: "nested braces", template: "{a,b{1,2}}.example.com", limit: 10,
+			want: []string{"a.example.com", "b1.example.com", "b2.example.com"}},
+		{name: "padded range", template: "node[08-11].local", limit: 10,
+			want: []string{"node08.local", "node09.local", "node10.local", "node11.local"}},
+		{name: "range", template: "node[9-10].local", limit: 10, want: []string{"node9.local", "node10.local"}},
+		{name: "range and braces", template: "{eu,us}[1-2].example.com", limit: 10,
+			want: []string{"eu1.example.com", "eu2.example.com", "us1.example.com", "us2.example.com"}},
+		{name: "over the limit", template: "{eu,us}[1-2].example.com", limit: 3, wantErr: "expands to more than 3 names"},
+		{name: "range over the limit", template: "node[1-99999999].local", limit: 100, wantErr: "expands to more than 100 names"},
+		{name: "unclosed brace", template: "{www,api.example.com", limit: 10, wantErr: "unclosed { at position 1"},
+		{name: "unmatched brace", template: "www}.example.com", limit: 10, wantErr: "unmatched } at position 4"},
+		{name: "single alternative", template: "{www}.example.com", limit: 10, wantErr: "need at least two alternatives"},
+		{name: "unclosed range", template: "node[1-2.local", limit: 10, wantErr: "unclosed [ at position 5"},
+		{name: "invalid range", template: "node[a-b].local", limit: 10, wantErr: "invalid range [a-b] at position 5"},
+		{name: "reversed range", template: "node[24-01].local", limit: 10, wantErr: "ends before it starts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Expand(tt.template, tt.limit)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expand() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expand() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFromFileTemplates(t *testing.T) {
+	cfg, err := LoadConfigFromFile(ptr("test_data/templates_config_sample.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	if cfg.QueryType.HostsCount != 30 || cfg.QueryType.PTRCount != 4 || cfg.QueryType.HTTPSCount != 4 {
+		t.Errorf("expected 30 hosts, 4 ptr and 4 https names, got %+v", cfg.QueryType)
+	}
+	if got := cfg.QueryType.Hosts[3]; got != "node01.cluster.local" {
+		t.Errorf("expected the range after the braces, got %s", got)
+	}
+	for _, e := range cfg.Entries() {
+		if e.Name == "us-edge2.example.com" && (e.Group != "edge" || e.Template != "{eu,us}-edge[1-2].example.com") {
+			t.Errorf("unexpected entry %+v", e)
+		}
+	}
+	data, err := Migrate(cfg)
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if !strings.Contains(string(data), "name: node[01-24].cluster.local") || strings.Contains(string(data), "node01") {
+		t.Errorf("Migrate() expected the templates rather than their names, got\n%s", data)
+	}
+	dir := writeFiles(t, map[string]string{
+		"v2.yaml": "version: 2\nnames:\n  - name: \"{mail,smtp}.example.com\"\n    types: [hosts, mx]\n",
+	})
+	if cfg, err = LoadConfigFromFile(ptr(filepath.Join(dir, "v2.yaml"))); err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	if !reflect.DeepEqual(cfg.QueryType.MX, []string{"mail.example.com", "smtp.example.com"}) || cfg.QueryType.HostsCount != 2 {
+		t.Errorf("expected the names of the version 2 template for each type, got %+v", cfg.QueryType)
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	file := "test_data/invalid_templates_config_sample.yaml"
+	got, err := ValidateFile(file)
+	if err != nil {
+		t.Fatalf("ValidateFile() error = %v", err)
+	}
+	want := []Diagnostic{
+		{Line: 4, Column: 7, Severity: SeverityError, Path: "query_type.hosts[0]",
+			Message: `invalid template "{www,api.example.com": unclosed { at position 1`},
+		{Line: 5, Column: 7, Severity: SeverityError, Path: "query_type.hosts[1]",
+			Message: `invalid template "node[24-01].cluster.local": the range [24-01] at position 5 ends before it starts`},
+		{Line: 6, Column: 7, Severity: SeverityError, Path: "query_type.hosts[2]",
+			Message: `invalid template "{www}.example.com": the braces at position 1 need at least two alternatives separated by commas`},
+		{Line: 7, Column: 7, Severity: SeverityError, Path: "query_type.hosts[3]",
+			Message: `"node1 .example.com" is not a valid fully qualified domain name`},
+		{Line: 9, Column: 7, Severity: SeverityWarning, Path: "query_type.hosts[5]",
+			Message: `duplicate name "a.example.com" in section hosts, first listed on line 8`},
+		{Line: 10, Column: 7, Severity: SeverityError, Path: "query_type.hosts[6]",
+			Message: `"node[1-99999].example.com" expands past the limit of 65535 names for the templates of a file`},
+		{Line: 12, Column: 7, Severity: SeverityError, Path: "query_type.ptr[0]",
+			Message: `invalid template "10.0.0.[a-b]": invalid range [a-b] at position 8, expected [first-last] such as [01-24]`},
+		{Line: 13, Column: 7, Severity: SeverityError, Path: "query_type.ptr[1]", Message: `"10.0.0.256" is not a valid IP address`},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ValidateFile() = %d diagnostics, want %d:\n%v", len(got), len(want), got)
+	}
+	for i := range got {
+		want[i].File = file
+		if got[i] != want[i] {
+			t.Errorf("diagnostic %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestValidateTemplatesBudgetOrder(t *testing.T) {
+	// the two sections are over the limit together, the budget is spent in the order of the keys
+	// so the same template is reported on every run.
+	data := []byte("query_type:\n  txt:\n  - t[1-40000].example.com\n  hosts:\n  - h[1-40000].example.com\n")
+	for range 10 {
+		got := Validate("config.yaml", data)
+		if len(got) != 1 || got[0].Path != "query_type.txt[0]" {
+			t.Fatalf("expected the txt template over the limit, got %v", got)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package confighandlers
 import (
 	"fmt"
 	"testing"
+	"time"
 )
 
 func TestLoadConfigFromFile(t *testing.T) {
@@ -91,6 +92,46 @@ func TestLoadConfigFromFile(t *testing.T) {
 	}
 }
 
+func TestLoadConfigFromFileRetry(t *testing.T) {
+	cfg, err := LoadConfigFromFile(ptr("test_data/retry_config_sample.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	if cfg.Retry == nil || *cfg.Retry.Attempts != 4 || *cfg.Retry.Backoff != 500*time.Millisecond || *cfg.Retry.Budget != 50 {
+		t.Errorf("unexpected retry configuration %+v", cfg.Retry)
+	}
+	if cfg.Retry.Timeout != nil {
+		t.Errorf("expected the unset retry timeout to be nil")
+	}
+}
+
+func TestLoadConfigFromFileRateLimit(t *testing.T) {
+	cfg, err := LoadConfigFromFile(ptr("test_data/rate_limit_config_sample.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	if cfg.RateLimit == nil || *cfg.RateLimit.QPS != 100 || *cfg.RateLimit.Burst != 20 {
+		t.Fatalf("unexpected rate limit configuration %+v", cfg.RateLimit)
+	}
+	tests := []struct {
+		host string
+		port string
+		want ServerRateLimit
+		ok   bool
+	}{
+		{host: "10.0.0.53", port: "53", want: ServerRateLimit{QPS: 25}, ok: true},
+		{host: "2001:db8::53", port: "5353", want: ServerRateLimit{QPS: 10, Burst: 2}, ok: true},
+		{host: "2001:db8::53", port: "53"},
+		{host: "localhost", port: "53"},
+	}
+	for _, tt := range tests {
+		got, ok := cfg.RateLimit.ServerLimit(tt.host, tt.port)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("ServerLimit(%s, %s) = %+v, %v, want %+v, %v", tt.host, tt.port, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
 func TestQueryListPopulateCounts(t *testing.T) {
 	type fields struct {
 		QueryType QueryType
@@ -197,6 +238,20 @@ func TestQueryListPopulateCounts(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "overload test srv",
+			fields: fields{
+				QueryType{
+					SRV: garbage(100000000),
+				},
+			},
+			want: fields{
+				QueryType{
+					SRVCount: uint16(0),
+				},
+			},
+			wantErr: true,
+		},
 		{
 			name: "overload test ptr",
 			fields: fields{
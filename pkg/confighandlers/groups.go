@@ -0,0 +1,237 @@
+package confighandlers
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Entry is a name of the configuration with the query type it is preloaded as.
+type Entry struct {
+	Name string
+	Type string
+	// Group the entry is listed in, empty for the names outside of the groups.
+	Group string
+	// Tags of the entry, the name and tags of its group are included.
+	Tags []string
+	// Priority of the entry, higher priorities are preloaded first and zero is the default.
+	Priority int
+	// File the entry is listed in, empty for the names added after the configuration was loaded.
+	File string
+	// Template is the name as it is listed when the entry is one of the names of a template, see
+	// Expand, empty otherwise.
+	Template string
+}
+
+// HasTag returns true when the entry has tag, tags are not case sensitive.
+func (e Entry) HasTag(tag string) bool {
+	return slices.ContainsFunc(e.Tags, func(t string) bool {
+		return strings.EqualFold(t, tag)
+	})
+}
+
+// Selector chooses entries by their tags, an entry is selected when it has one of Tags, or Tags is
+// empty, and none of Exclude.
+type Selector struct {
+	Tags    []string
+	Exclude []string
+}
+
+// Match returns true when the entry is selected.
+func (s Selector) Match(e Entry) bool {
+	if len(s.Tags) > 0 && !slices.ContainsFunc(s.Tags, e.HasTag) {
+		return false
+	}
+	return !slices.ContainsFunc(s.Exclude, e.HasTag)
+}
+
+// Part is a configuration with the selected names of a group, or of the names outside of the groups
+// when Name is empty.
+type Part struct {
+	Name string
+	// Group has the defaults of the group, it is nil outside of the groups.
+	Group  *Group
+	Config *Configuration
+	// Priority is the highest priority of the names of the part.
+	Priority int
+}
+
+// Entries returns the names of QueryType with the group and tags they are listed with in the
+// configuration file, names added to QueryType afterwards are outside of the groups.
+func (cfg *Configuration) Entries() []Entry {
+	var entries []Entry
+	next := make(map[string]int)
+	for _, typ := range QueryTypes {
+		for _, name := range *cfg.QueryType.List(typ) {
+			e := Entry{Name: name, Type: typ}
+			key := typ + " " + name
+			if listed := cfg.entries[key]; next[key] < len(listed) {
+				e = listed[next[key]]
+				next[key]++
+			}
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Select returns a configuration with the entries selected by sel, the entries keep their group and
+// the configuration has the groups, retry, rate limit and routes of cfg.
+func (cfg *Configuration) Select(sel Selector) (*Configuration, error) {
+	var entries []Entry
+	for _, e := range cfg.Entries() {
+		if sel.Match(e) {
+			entries = append(entries, e)
+		}
+	}
+	selected, err := cfg.subset(entries)
+	if err != nil {
+		return nil, err
+	}
+	selected.Groups = cfg.Groups
+	return selected, nil
+}
+
+// subset returns a configuration with entries and the retry, rate limit and routes of cfg.
+func (cfg *Configuration) subset(entries []Entry) (*Configuration, error) {
+	part := &Configuration{Version: cfg.Version, Retry: cfg.Retry, RateLimit: cfg.RateLimit, Routes: cfg.Routes}
+	part.setEntries(entries)
+	if err := part.PopulateCounts(); err != nil {
+		return nil, err
+	}
+	return part, nil
+}
+
+// Split returns a configuration for the entries selected by sel outside of the groups and one for
+// each group, the parts without a selected entry are left out. The parts are in order of their
+// highest priority, parts of the same priority have the names outside of the groups first followed
+// by the groups in order of their name. Each part has the retry, rate limit and routes of the
+// configuration.
+func (cfg *Configuration) Split(sel Selector) ([]Part, error) {
+	selected := make(map[string][]Entry)
+	for _, e := range cfg.Entries() {
+		if sel.Match(e) {
+			selected[e.Group] = append(selected[e.Group], e)
+		}
+	}
+	parts := make([]Part, 0, len(selected))
+	for _, name := range slices.Sorted(maps.Keys(selected)) {
+		part, err := cfg.subset(selected[name])
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, Part{Name: name, Group: cfg.Groups[name], Config: part, Priority: MaxPriority(selected[name])})
+	}
+	slices.SortStableFunc(parts, func(a, b Part) int {
+		return b.Priority - a.Priority
+	})
+	return parts, nil
+}
+
+// collect builds the entries of a loaded configuration and lists every name in QueryType,
+// including the names of version 2, of the groups and of the templates, so a run of the whole
+// configuration preloads all of them.
+func (cfg *Configuration) collect() error {
+	budget := MaxExpansions
+	entries, err := cfg.entriesOf(&cfg.QueryType, cfg.Names, "", nil, 0, &budget)
+	if err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Groups)) {
+		group := cfg.Groups[name]
+		if group == nil {
+			continue
+		}
+		tags := append([]string{name}, group.Tags...)
+		groupEntries, err := cfg.entriesOf(&group.QueryType, group.Names, name, tags, group.Priority, &budget)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, groupEntries...)
+	}
+	cfg.setEntries(entries)
+	return nil
+}
+
+// setEntries lists the names of the entries in QueryType and keeps their group and tags.
+func (cfg *Configuration) setEntries(entries []Entry) {
+	cfg.QueryType = QueryType{}
+	cfg.entries = make(map[string][]Entry, len(entries))
+	for _, e := range entries {
+		list := cfg.QueryType.List(e.Type)
+		*list = append(*list, e.Name)
+		key := e.Type + " " + e.Name
+		cfg.entries[key] = append(cfg.entries[key], e)
+	}
+}
+
+// entriesOf returns the entries of the names in q for version 1, or in names for version 2, with
+// the group, its tags and priority. The templates are expanded within budget, see expandName.
+func (cfg *Configuration) entriesOf(q *QueryType, names []Name, group string, tags []string, priority int, budget *int) ([]Entry, error) {
+	var entries []Entry
+	if cfg.Version != Version2 {
+		for _, typ := range QueryTypes {
+			for _, name := range *q.List(typ) {
+				expanded, err := expandName(name, budget)
+				if err != nil {
+					return nil, err
+				}
+				for _, e := range expanded {
+					entries = append(entries, Entry{Name: e, Type: typ, Group: group, Tags: tags, Priority: priority, Template: template(name)})
+				}
+			}
+		}
+		return entries, nil
+	}
+	for _, name := range names {
+		expanded, err := expandName(name.Name, budget)
+		if err != nil {
+			return nil, err
+		}
+		nameTags := append(slices.Clone(tags), name.Tags...)
+		namePriority := priority
+		if name.Priority != nil {
+			namePriority = *name.Priority
+		}
+		for _, typ := range name.Types {
+			if q.List(typ) == nil {
+				return nil, fmt.Errorf("unknown query type %s for %s", typ, name.Name)
+			}
+			for _, e := range expanded {
+				entries = append(entries, Entry{Name: e, Type: typ, Group: group, Tags: nameTags, Priority: namePriority, Template: template(name.Name)})
+			}
+		}
+	}
+	return entries, nil
+}
+
+// template returns name when it is a template, empty otherwise.
+func template(name string) string {
+	if IsTemplate(name) {
+		return name
+	}
+	return ""
+}
+
+// Priorities returns the distinct priorities of the entries from the highest to the lowest.
+func Priorities(entries []Entry) []int {
+	priorities := make([]int, 0, 1)
+	for _, e := range entries {
+		if !slices.Contains(priorities, e.Priority) {
+			priorities = append(priorities, e.Priority)
+		}
+	}
+	slices.SortFunc(priorities, func(a, b int) int {
+		return b - a
+	})
+	return priorities
+}
+
+// MaxPriority returns the highest priority of the entries, zero when there are none.
+func MaxPriority(entries []Entry) int {
+	if priorities := Priorities(entries); len(priorities) > 0 {
+		return priorities[0]
+	}
+	return 0
+}
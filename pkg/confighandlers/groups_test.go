@@ -0,0 +1,240 @@
+package confighandlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigFromFileGroups(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want QueryType
+	}{
+		{
+			name: "version 1",
+			file: "test_data/groups_config_sample.yaml",
+			want: QueryType{
+				Hosts: []string{"google.com", "github.com", "netflix.com", "salesforce.com"},
+				Cname: []string{"www.netflix.com"},
+				NS:    []string{"github.com"},
+				MX:    []string{"gmail.com"},
+			},
+		},
+		{
+			name: "version 2",
+			file: "test_data/groups_v2_config_sample.yaml",
+			want: QueryType{
+				Hosts: []string{"google.com", "salesforce.com"},
+				TXT:   []string{"salesforce.com"},
+				MX:    []string{"gmail.com"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfigFromFile(&tt.file)
+			if err != nil {
+				t.Fatalf("LoadConfigFromFile() error = %v", err)
+			}
+			want := &Configuration{QueryType: tt.want}
+			if err = want.PopulateCounts(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cfg.QueryType, want.QueryType) {
+				t.Errorf("LoadConfigFromFile() = %+v, want %+v", cfg.QueryType, want.QueryType)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	cfg, err := LoadConfigFromFile(ptr("test_data/groups_config_sample.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	v2, err := LoadConfigFromFile(ptr("test_data/groups_v2_config_sample.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	tests := []struct {
+		name string
+		cfg  *Configuration
+		sel  Selector
+		// want the names of each part by group, "" is outside of the groups.
+		want map[string]QueryType
+	}{
+		{
+			name: "no selector",
+			cfg:  cfg,
+			want: map[string]QueryType{
+				"":          {Hosts: []string{"google.com", "github.com"}},
+				"critical":  {NS: []string{"github.com"}},
+				"streaming": {Hosts: []string{"netflix.com"}, Cname: []string{"www.netflix.com"}},
+				"work":      {Hosts: []string{"salesforce.com"}, MX: []string{"gmail.com"}},
+			},
+		},
+		{
+			name: "group tag",
+			cfg:  cfg,
+			sel:  Selector{Tags: []string{"Office"}},
+			want: map[string]QueryType{
+				"critical": {NS: []string{"github.com"}},
+				"work":     {Hosts: []string{"salesforce.com"}, MX: []string{"gmail.com"}},
+			},
+		},
+		{
+			name: "group name and exclude",
+			cfg:  cfg,
+			sel:  Selector{Tags: []string{"streaming", "office"}, Exclude: []string{"infra"}},
+			want: map[string]QueryType{
+				"streaming": {Hosts: []string{"netflix.com"}, Cname: []string{"www.netflix.com"}},
+				"work":      {Hosts: []string{"salesforce.com"}, MX: []string{"gmail.com"}},
+			},
+		},
+		{
+			name: "exclude only",
+			cfg:  cfg,
+			sel:  Selector{Exclude: []string{"work", "media", "infra"}},
+			want: map[string]QueryType{
+				"": {Hosts: []string{"google.com", "github.com"}},
+			},
+		},
+		{
+			name: "no match",
+			cfg:  cfg,
+			sel:  Selector{Tags: []string{"home"}},
+			want: map[string]QueryType{},
+		},
+		{
+			name: "name tags",
+			cfg:  v2,
+			sel:  Selector{Tags: []string{"search", "mail"}},
+			want: map[string]QueryType{
+				"work": {MX: []string{"gmail.com"}},
+				"":     {Hosts: []string{"google.com"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, err := tt.cfg.Split(tt.sel)
+			if err != nil {
+				t.Fatalf("Split() error = %v", err)
+			}
+			if len(parts) != len(tt.want) {
+				t.Fatalf("Split() = %d parts, want %d: %+v", len(parts), len(tt.want), parts)
+			}
+			for i, part := range parts {
+				if i > 0 {
+					prev := parts[i-1]
+					if prev.Priority < part.Priority || (prev.Priority == part.Priority && prev.Name >= part.Name) {
+						t.Errorf("part %s is listed after %s", part.Name, prev.Name)
+					}
+				}
+				want := &Configuration{QueryType: tt.want[part.Name]}
+				if err = want.PopulateCounts(); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(part.Config.QueryType, want.QueryType) {
+					t.Errorf("part %q = %+v, want %+v", part.Name, part.Config.QueryType, want.QueryType)
+				}
+				if (part.Name == "") != (part.Group == nil) || part.Config.Retry != tt.cfg.Retry {
+					t.Errorf("part %q has the wrong group or retry", part.Name)
+				}
+			}
+		})
+	}
+	work := cfg.Groups["work"]
+	if work.Server != "10.0.0.53" || work.Workers != 4 || work.Timeout == nil || *work.Timeout != 5*time.Second {
+		t.Errorf("the defaults of the work group are %+v", work)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	cfg, err := LoadConfigFromFile(ptr("test_data/groups_config_sample.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	selected, err := cfg.Select(Selector{Tags: []string{"office"}, Exclude: []string{"infra"}})
+	if err != nil {
+		t.Fatalf("Select() error = %v", err)
+	}
+	want := QueryType{Hosts: []string{"salesforce.com"}, MX: []string{"gmail.com"}}
+	if !reflect.DeepEqual(selected.QueryType.Hosts, want.Hosts) || !reflect.DeepEqual(selected.QueryType.MX, want.MX) {
+		t.Errorf("Select() = %+v, want %+v", selected.QueryType, want)
+	}
+	for _, e := range selected.Entries() {
+		if e.Group != "work" {
+			t.Errorf("expected %s %s to keep its group, got %q", e.Type, e.Name, e.Group)
+		}
+	}
+	if selected.Groups["work"] == nil {
+		t.Errorf("Select() must keep the groups of the configuration")
+	}
+}
+
+func TestValidateGroups(t *testing.T) {
+	file := "test_data/invalid_groups_config_sample.yaml"
+	got, err := ValidateFile(file)
+	if err != nil {
+		t.Fatalf("ValidateFile() error = %v", err)
+	}
+	want := []Diagnostic{
+		{Line: 3, Column: 3, Severity: SeverityWarning, Path: "query_type.hosts", Message: "section hosts is empty"},
+		{Line: 6, Column: 20, Severity: SeverityError, Path: "groups.work.tags[1]", Message: `"two words" is not a valid tag, a tag can not be empty or contain commas or spaces`},
+		{Line: 7, Column: 13, Severity: SeverityError, Path: "groups.work.server", Message: `server "not_a_server" is not a valid host name or IP address`},
+		{Line: 8, Column: 11, Severity: SeverityError, Path: "groups.work.port", Message: "port must be at most 65535, got 99999"},
+		{Line: 9, Column: 14, Severity: SeverityError, Path: "groups.work.workers", Message: "workers must be at least 1, got -1"},
+		{Line: 13, Column: 3, Severity: SeverityWarning, Path: "groups.media", Message: "group media has no names"},
+		{Line: 15, Column: 3, Severity: SeverityError, Path: "groups.bad,name", Message: `"bad,name" is not a valid tag, a tag can not be empty or contain commas or spaces`},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ValidateFile() = %d diagnostics, want %d:\n%v", len(got), len(want), got)
+	}
+	for i := range got {
+		want[i].File = file
+		if got[i] != want[i] {
+			t.Errorf("diagnostic %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	for _, data := range []string{"groups:\n  work:\n    tags: [office]\n", "version: 2\nnames: []\ngroups: {}\n"} {
+		if got := Errors(Validate("empty.yaml", []byte(data))); len(got) != 1 || got[0].Message[:19] != "empty configuration" {
+			t.Errorf("expected an empty configuration for %q, got %v", data, got)
+		}
+	}
+	v2 := "version: 2\ngroups:\n  work:\n    query_type:\n      hosts: [foo.bar]\n    names:\n      - name: foo.bar\n        types: [hosts]\n        tags: [a b]\n"
+	if got := Validate("v2.yaml", []byte(v2)); len(got) != 2 || got[0].Path != "groups.work.query_type" || got[1].Path != "groups.work.names[0].tags[0]" {
+		t.Errorf("expected the version 1 layout and the tag to be reported, got %v", got)
+	}
+}
+
+func TestPriorities(t *testing.T) {
+	cfg, err := LoadConfigFromFile(ptr("test_data/groups_v2_config_sample.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	got := make(map[string]int)
+	for _, e := range cfg.Entries() {
+		got[e.Type+" "+e.Name] = e.Priority
+	}
+	want := map[string]int{"hosts google.com": 0, "hosts salesforce.com": 5, "txt salesforce.com": 5, "mx gmail.com": 10}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Entries() priorities = %v, want %v", got, want)
+	}
+	if priorities := Priorities(cfg.Entries()); !reflect.DeepEqual(priorities, []int{10, 5, 0}) {
+		t.Errorf("Priorities() = %v", priorities)
+	}
+	parts, err := cfg.Split(Selector{})
+	if err != nil {
+		t.Fatalf("Split() error = %v", err)
+	}
+	if len(parts) != 2 || parts[0].Name != "work" || parts[0].Priority != 10 || parts[1].Priority != 0 {
+		t.Errorf("Split() = %+v, expected the work group first", parts)
+	}
+	data := "version: 2\nnames:\n  - name: foo.bar\n    types: [hosts]\n    priority: high\n"
+	if got := Validate("priority.yaml", []byte(data)); len(got) != 1 || got[0].Path != "names[0].priority" {
+		t.Errorf("expected the priority to be reported, got %v", got)
+	}
+}
@@ -0,0 +1,277 @@
+package confighandlers
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// confExtensions are the extensions of the configuration files read from a conf.d directory.
+var confExtensions = []string{".yaml", ".yml"}
+
+// LoadConfigFromFiles loads the configuration file, the files it includes and, when confDir is not
+// empty, every YAML file of confDir in name order. The files are merged in the order they are
+// loaded, see Merge, and every error found by Validate in any of them is returned as a
+// ValidationError.
+func LoadConfigFromFiles(cfgfile, confDir string) (*Configuration, error) {
+	return LoadSignedConfigFromFiles(cfgfile, confDir, nil)
+}
+
+// LoadSignedConfigFromFiles loads the configuration like LoadConfigFromFiles, when key is not nil
+// every file must have a valid detached signature of key, see PublicKey.VerifyFile, and a file
+// without one is an error of the ValidationError.
+func LoadSignedConfigFromFiles(cfgfile, confDir string, key *PublicKey) (*Configuration, error) {
+	return loadConfig(cfgfile, "", confDir, key)
+}
+
+// LoadRemoteConfig loads the configuration fetched from url into cfgfile like
+// LoadSignedConfigFromFiles. A relative include of the remote configuration is an error, it would
+// be resolved against the cache directory instead of url.
+func LoadRemoteConfig(url, cfgfile, confDir string, key *PublicKey) (*Configuration, error) {
+	return loadConfig(cfgfile, url, confDir, key)
+}
+
+// loadConfig loads and merges the files of the configuration, remote is the URL cfgfile was
+// fetched from or empty for a local file.
+func loadConfig(cfgfile, remote, confDir string, key *PublicKey) (*Configuration, error) {
+	l, err := load(cfgfile, remote, confDir, key)
+	if err != nil {
+		return &Configuration{}, err
+	}
+	if errs := Errors(l.diags); len(errs) > 0 {
+		return &Configuration{}, &ValidationError{Diagnostics: errs}
+	}
+	cfg := l.configs[0]
+	for _, other := range l.configs[1:] {
+		cfg.Merge(other)
+	}
+	if err = cfg.PopulateCounts(); err != nil {
+		return &Configuration{}, err
+	}
+	return cfg, nil
+}
+
+// ValidateFiles checks the configuration file, the files it includes and the YAML files of confDir
+// and returns every problem found in them, the diagnostics of each file are sorted by position.
+// The error is only set when the configuration file can not be read.
+func ValidateFiles(file, confDir string) ([]Diagnostic, error) {
+	l, err := load(file, "", confDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return l.diags, nil
+}
+
+// loader reads a configuration file and every file it includes, each file is loaded once.
+type loader struct {
+	diags   []Diagnostic
+	configs []*Configuration
+	// loaded holds the absolute path of every file read so far.
+	loaded map[string]bool
+	total  int
+	// key verifies the signature of every file when it is not nil.
+	key *PublicKey
+	// remote is the URL the configuration file was fetched from, empty for a local file.
+	remote string
+}
+
+// load reads file and the files it includes followed by the files of confDir, the files are
+// verified with key when it is not nil. remote is the URL file was fetched from, or empty.
+func load(file, remote, confDir string, key *PublicKey) (*loader, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	l := &loader{loaded: make(map[string]bool), key: key, remote: remote}
+	fragment := confDir != ""
+	v := l.file(file, data, nil, fragment)
+	if confDir != "" {
+		if err = l.confDir(file, confDir); err != nil {
+			return nil, err
+		}
+	}
+	// a file with includes is checked for names with the files it includes.
+	if l.total == 0 && len(Errors(l.diags)) == 0 && (fragment || len(v.includes) > 0) {
+		l.diags = append(l.diags, Diagnostic{File: file, Line: 1, Column: 1, Severity: SeverityError,
+			Message: "empty configuration, none of the files has names"})
+	}
+	return l, nil
+}
+
+// file validates and loads the configuration in data followed by the files it includes, stack holds
+// the files that include it. It returns the validation of the file.
+func (l *loader) file(file string, data []byte, stack []string, fragment bool) *validation {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	l.loaded[abs] = true
+	v := newValidation(file, fragment)
+	if l.key != nil {
+		// a file that is not signed is not parsed, nor are the files it includes.
+		if err = l.key.VerifyFile(file, data); err != nil {
+			v.diags = append(v.diags, Diagnostic{File: file, Line: 1, Column: 1, Severity: SeverityError, Message: err.Error()})
+			l.diags = append(l.diags, v.diags...)
+			return v
+		}
+	}
+	v.run(data)
+	l.total += v.total
+	if len(Errors(v.diags)) == 0 {
+		cfg := new(Configuration)
+		if err = yaml.Unmarshal(data, cfg); err == nil {
+			err = cfg.collect()
+		}
+		if err != nil {
+			v.diags = append(v.diags, Diagnostic{File: file, Line: 1, Column: 1, Severity: SeverityError, Message: err.Error()})
+		} else {
+			cfg.setFile(file)
+			l.configs = append(l.configs, cfg)
+		}
+	}
+	// the diagnostics of the file, including those of its include patterns, go before the
+	// diagnostics of the files it includes.
+	at := len(l.diags)
+	stack = append(stack, abs)
+	for _, pattern := range v.includes {
+		l.include(v, pattern, stack)
+	}
+	v.sort()
+	l.diags = slices.Insert(l.diags, at, v.diags...)
+	return v
+}
+
+// include loads the files of the include pattern of the file of v, the problems are added to v.
+func (l *loader) include(v *validation, pattern *yaml.Node, stack []string) {
+	path := pattern.Value
+	diag := func(severity Severity, format string, args ...any) {
+		v.add(pattern, severity, keyInclude, format, args...)
+	}
+	if !filepath.IsAbs(path) && l.remote != "" && len(stack) == 1 {
+		// the remote configuration is the first file of the stack, its directory is the cache.
+		diag(SeverityError, "include %s is relative to the remote configuration %s, a remote configuration can only include absolute paths", pattern.Value, l.remote)
+		return
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(v.file), path)
+	}
+	matches := []string{path}
+	if strings.ContainsAny(path, "*?[") {
+		// the pattern was checked by the validation so Glob does not fail.
+		matches, _ = filepath.Glob(path)
+		if len(matches) == 0 {
+			diag(SeverityWarning, "include %s matches no files", pattern.Value)
+			return
+		}
+	}
+	for _, match := range matches {
+		abs, err := filepath.Abs(match)
+		if err != nil {
+			abs = match
+		}
+		if i := slices.Index(stack, abs); i >= 0 {
+			diag(SeverityError, "include cycle: %s", cycle(stack[i:], abs))
+			continue
+		}
+		if l.loaded[abs] {
+			continue
+		}
+		data, err := os.ReadFile(match)
+		if err != nil {
+			diag(SeverityError, "cannot include %s: %s", pattern.Value, err)
+			continue
+		}
+		l.file(match, data, stack, true)
+	}
+}
+
+// confDir loads the YAML files of dir in name order as if file included them.
+func (l *loader) confDir(file, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !slices.Contains(confExtensions, filepath.Ext(entry.Name())) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if p, _ := filepath.Abs(path); l.loaded[p] {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		l.file(path, data, []string{abs}, true)
+	}
+	return nil
+}
+
+// cycle formats the files of an include cycle relative to the first one, e.g. a.yaml -> b.yaml -> a.yaml.
+func cycle(stack []string, file string) string {
+	dir := filepath.Dir(stack[0])
+	names := make([]string, 0, len(stack)+1)
+	for _, path := range append(slices.Clone(stack), file) {
+		if rel, err := filepath.Rel(dir, path); err == nil {
+			path = rel
+		}
+		names = append(names, path)
+	}
+	return strings.Join(names, " -> ")
+}
+
+// setFile records file as the file of every entry of the configuration.
+func (cfg *Configuration) setFile(file string) {
+	for key, entries := range cfg.entries {
+		for i := range entries {
+			entries[i].File = file
+		}
+		cfg.entries[key] = entries
+	}
+}
+
+// Merge adds the names, groups and routes of other to the configuration. A name listed for the
+// same query type in the same group of both is kept once with the tags and priority of the
+// configuration. The groups, retry, rate limit and settings of the configuration take precedence
+// over those of other and its routes are matched first.
+func (cfg *Configuration) Merge(other *Configuration) {
+	entries := cfg.Entries()
+	seen := make(map[string]bool, len(entries))
+	merged := make([]Entry, 0, len(entries))
+	for _, e := range append(entries, other.Entries()...) {
+		key := e.Group + " " + e.Type + " " + strings.ToLower(strings.TrimSuffix(e.Name, "."))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		merged = append(merged, e)
+	}
+	cfg.setEntries(merged)
+	for name, group := range other.Groups {
+		if _, ok := cfg.Groups[name]; ok {
+			continue
+		}
+		if cfg.Groups == nil {
+			cfg.Groups = make(map[string]*Group, len(other.Groups))
+		}
+		cfg.Groups[name] = group
+	}
+	cfg.Routes = append(cfg.Routes, other.Routes...)
+	if cfg.Retry == nil {
+		cfg.Retry = other.Retry
+	}
+	if cfg.RateLimit == nil {
+		cfg.RateLimit = other.RateLimit
+	}
+	if cfg.Settings == nil {
+		cfg.Settings = other.Settings
+	}
+}
@@ -0,0 +1,134 @@
+package confighandlers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes the files of a configuration tree into a temporary directory and returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadConfigFromFilesIncludes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.yaml": "query_type:\n  hosts:\n    - google.com\ninclude: [generated/*.yaml, manual.yaml]\n",
+		"generated/a.yaml": "query_type:\n  hosts:\n    - Google.com.\n    - a.example.com\n" +
+			"groups:\n  work:\n    tags: [office]\n    query_type:\n      mx: [example.com]\n",
+		"generated/b.yaml": "query_type:\n  txt: [b.example.com]\ninclude: [../manual.yaml]\n",
+		"manual.yaml":      "version: 2\nnames:\n  - name: manual.example.com\n    types: [hosts, txt]\nretry:\n  attempts: 3\n",
+		"conf.d/c.yml":     "query_type:\n  hosts: [c.example.com]\n",
+		"conf.d/notes.txt": "not a configuration",
+	})
+	cfg, err := LoadConfigFromFiles(filepath.Join(dir, "main.yaml"), filepath.Join(dir, "conf.d"))
+	if err != nil {
+		t.Fatalf("LoadConfigFromFiles() error = %v", err)
+	}
+	got := make(map[string]string)
+	for _, e := range cfg.Entries() {
+		rel, _ := filepath.Rel(dir, e.File)
+		got[e.Group+" "+e.Type+" "+e.Name] = rel
+	}
+	want := map[string]string{
+		" hosts google.com":         "main.yaml",
+		" hosts a.example.com":      "generated/a.yaml",
+		"work mx example.com":       "generated/a.yaml",
+		" txt b.example.com":        "generated/b.yaml",
+		" hosts manual.example.com": "manual.yaml",
+		" txt manual.example.com":   "manual.yaml",
+		" hosts c.example.com":      "conf.d/c.yml",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Entries() = %v, want %v", got, want)
+	}
+	if cfg.Retry == nil || *cfg.Retry.Attempts != 3 || cfg.Groups["work"] == nil {
+		t.Errorf("expected the retry and groups of the included files, got %+v %v", cfg.Retry, cfg.Groups)
+	}
+	if cfg.QueryType.HostsCount != 4 {
+		t.Errorf("HostsCount = %d, want 4", cfg.QueryType.HostsCount)
+	}
+}
+
+func TestValidateFilesIncludes(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{
+			name: "cycle",
+			files: map[string]string{
+				"main.yaml": "include: [a.yaml]\n",
+				"a.yaml":    "query_type:\n  hosts: [a.example.com]\ninclude: [b/b.yaml]\n",
+				"b/b.yaml":  "include: [../a.yaml]\n",
+			},
+			want: []string{"b/b.yaml:1:11: error: include cycle: a.yaml -> b/b.yaml -> a.yaml"},
+		},
+		{
+			name: "missing files",
+			files: map[string]string{
+				"main.yaml": "query_type:\n  hosts: [a.example.com]\ninclude:\n  - missing.yaml\n  - generated/*.yaml\n  - '[bad'\n",
+			},
+			want: []string{
+				"main.yaml:4:5: error: cannot include missing.yaml: open %s/missing.yaml: no such file or directory",
+				"main.yaml:5:5: warning: include generated/*.yaml matches no files",
+				`main.yaml:6:5: error: invalid glob "[bad": syntax error in pattern`,
+			},
+		},
+		{
+			name: "errors of an included file",
+			files: map[string]string{
+				"main.yaml": "query_type:\n  hosts: [a.example.com]\ninclude: [b.yaml]\n",
+				"b.yaml":    "query_type:\n  host: [b.example.com]\n",
+			},
+			want: []string{"b.yaml:2:3: error: unknown key host, did you mean hosts?"},
+		},
+		{
+			name: "no names in any file",
+			files: map[string]string{
+				"main.yaml": "include: [b.yaml]\n",
+				"b.yaml":    "retry:\n  attempts: 2\n",
+			},
+			want: []string{"main.yaml:1:1: error: empty configuration, none of the files has names"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFiles(t, tt.files)
+			diags, err := ValidateFiles(filepath.Join(dir, "main.yaml"), "")
+			if err != nil {
+				t.Fatalf("ValidateFiles() error = %v", err)
+			}
+			got := make([]string, 0, len(diags))
+			for _, d := range diags {
+				d.File, _ = filepath.Rel(dir, d.File)
+				got = append(got, d.String())
+			}
+			want := make([]string, 0, len(tt.want))
+			for _, w := range tt.want {
+				if strings.Contains(w, "%s") {
+					w = fmt.Sprintf(w, dir)
+				}
+				want = append(want, w)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ValidateFiles() = %q, want %q", got, want)
+			}
+		})
+	}
+}
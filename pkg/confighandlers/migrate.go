@@ -0,0 +1,85 @@
+package confighandlers
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	// Version1 lists the names by query type under query_type.
+	Version1 int = 1
+	// Version2 lists the names under names, each with its query types.
+	Version2 int = 2
+)
+
+// configV2 is the layout of a version 2 configuration file.
+//
+//nolint:govet // fieldalignment is not required here, field order matches the file.
+type configV2 struct {
+	Version   int               `yaml:"version"`
+	Names     []Name            `yaml:"names"`
+	Retry     *Retry            `yaml:"retry,omitempty"`
+	RateLimit *RateLimit        `yaml:"rate_limit,omitempty"`
+	Groups    map[string]*Group `yaml:"groups,omitempty"`
+	Routes    []Route           `yaml:"routes,omitempty"`
+	Settings  *Settings         `yaml:"settings,omitempty"`
+}
+
+// Migrate converts a version 1 configuration to a version 2 file, each name is listed once with
+// every query type it appears under. The names keep the order they are first listed in and their
+// types follow the order of QueryTypes, the groups keep their tags and defaults.
+// The routes and settings are kept as they are.
+func Migrate(cfg *Configuration) ([]byte, error) {
+	if cfg.Version == Version2 {
+		return nil, fmt.Errorf("the configuration is already version %d", Version2)
+	}
+	entries := cfg.Entries()
+	out := configV2{Version: Version2, Names: namesOf(entries, ""), Retry: cfg.Retry, RateLimit: cfg.RateLimit, Routes: cfg.Routes, Settings: cfg.Settings}
+	for name, group := range cfg.Groups {
+		if group == nil {
+			continue
+		}
+		migrated := *group
+		migrated.QueryType = QueryType{}
+		migrated.Names = namesOf(entries, name)
+		if out.Groups == nil {
+			out.Groups = make(map[string]*Group, len(cfg.Groups))
+		}
+		out.Groups[name] = &migrated
+	}
+	data, err := yaml.Marshal(&out)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("---\n"), data...), nil
+}
+
+// namesOf returns the version 2 names of the entries of group, names that only differ by case or
+// a trailing dot are listed once and the names of a template are listed as the template.
+func namesOf(entries []Entry, group string) []Name {
+	names := make([]Name, 0)
+	index := make(map[string]int)
+	for _, e := range entries {
+		if e.Group != group {
+			continue
+		}
+		name := e.Name
+		if e.Template != "" {
+			name = e.Template
+		}
+		key := strings.ToLower(strings.TrimSuffix(name, "."))
+		i, ok := index[key]
+		if !ok {
+			i = len(names)
+			index[key] = i
+			names = append(names, Name{Name: name})
+		}
+		if !slices.Contains(names[i].Types, e.Type) {
+			names[i].Types = append(names[i].Types, e.Type)
+		}
+	}
+	return names
+}
@@ -0,0 +1,126 @@
+package confighandlers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfigFromFileV2(t *testing.T) {
+	cfg, err := LoadConfigFromFile(ptr("test_data/v2_config_sample.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	want := &Configuration{QueryType: QueryType{
+		Hosts: []string{"gmail.com", "github.com"},
+		MX:    []string{"gmail.com"},
+		TXT:   []string{"gmail.com"},
+		Cname: []string{"www.salesforce.com"},
+		PTR:   []string{"2404:6800:4006:804::200e"},
+		SRV:   []string{"_xmpp-server._tcp.gmail.com"},
+		NS:    []string{"github.com"},
+	}}
+	if err = want.PopulateCounts(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.QueryType, want.QueryType) || cfg.Retry == nil || *cfg.Retry.Attempts != 2 {
+		t.Errorf("LoadConfigFromFile() = %+v, want %+v", cfg.QueryType, want.QueryType)
+	}
+}
+
+func TestValidateV2(t *testing.T) {
+	got, err := ValidateFile("test_data/invalid_v2_config_sample.yaml")
+	if err != nil {
+		t.Fatalf("ValidateFile() error = %v", err)
+	}
+	want := []Diagnostic{
+		{Line: 3, Column: 1, Severity: SeverityError, Path: "query_type", Message: "query_type is the version 1 layout, version 2 lists the names under names"},
+		{Line: 8, Column: 17, Severity: SeverityError, Path: "names[0].types[1]",
+			Message: "unknown query type mail, expected hosts, cname, mx, ns, txt, ptr, srv, https"},
+		{Line: 9, Column: 11, Severity: SeverityError, Path: "names[1].name", Message: `"not a domain" is not a valid fully qualified domain name`},
+		{Line: 12, Column: 13, Severity: SeverityWarning, Path: "names[2].types[0]", Message: `duplicate name "Gmail.com." for query type mx, first listed on line 7`},
+		{Line: 13, Column: 5, Severity: SeverityError, Path: "names[3].types", Message: "apple.com needs a list of query types"},
+		{Line: 14, Column: 5, Severity: SeverityError, Path: "names[4]", Message: "missing key name"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ValidateFile() = %d diagnostics, want %d:\n%v", len(got), len(want), got)
+	}
+	for i := range got {
+		want[i].File = "test_data/invalid_v2_config_sample.yaml"
+		if got[i] != want[i] {
+			t.Errorf("diagnostic %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if got := Validate("v1.yaml", []byte("names:\n  - name: foo.bar\n    types: [hosts]\n")); len(got) != 2 || got[0].Message != "names needs version: 2" {
+		t.Errorf("expected names to need version 2 in a version 1 file, got %v", got)
+	}
+	if got := Validate("v3.yaml", []byte("version: 3\nquery_type:\n  hosts: [foo.bar]\n")); len(got) != 1 || got[0].Path != keyVersion {
+		t.Errorf("expected an unsupported version, got %v", got)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	cfg, err := LoadConfigFromFile(ptr("test_data/complete_config_sample.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	cfg.QueryType.TXT = append(cfg.QueryType.TXT, "Google.com.")
+	data, err := Migrate(cfg)
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "v2.yaml")
+	if err = os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if diags := Validate(file, data); len(diags) != 0 {
+		t.Fatalf("the migrated configuration has diagnostics %v:\n%s", diags, data)
+	}
+	migrated, err := LoadConfigFromFile(&file)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() of the migrated configuration error = %v", err)
+	}
+	if migrated.Names[0].Name != "google.com" || !reflect.DeepEqual(migrated.Names[0].Types, []string{Hosts, Txt}) {
+		t.Errorf("expected google.com first with the hosts and txt types, got %+v", migrated.Names[0])
+	}
+	// the names are grouped case insensitively so the txt entry is listed under the first spelling.
+	cfg.QueryType.TXT[len(cfg.QueryType.TXT)-1] = "google.com"
+	if !reflect.DeepEqual(migrated.QueryType.Hosts, cfg.QueryType.Hosts) || len(migrated.QueryType.TXT) != len(cfg.QueryType.TXT) {
+		t.Errorf("the migrated configuration has different names %+v", migrated.QueryType)
+	}
+	if _, err = Migrate(&Configuration{Version: Version2}); err == nil {
+		t.Errorf("Migrate() of a version 2 configuration expected an error")
+	}
+}
+
+func TestMigrateGroups(t *testing.T) {
+	cfg, err := LoadConfigFromFile(ptr("test_data/groups_config_sample.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	data, err := Migrate(cfg)
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "v2.yaml")
+	if err = os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	migrated, err := LoadConfigFromFile(&file)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() of the migrated configuration error = %v:\n%s", err, data)
+	}
+	sel := Selector{Tags: []string{"office"}}
+	want, _ := cfg.Split(sel)
+	got, _ := migrated.Split(sel)
+	if len(got) != len(want) {
+		t.Fatalf("the migrated configuration has %d parts, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i].Name != want[i].Name || !reflect.DeepEqual(got[i].Config.QueryType, want[i].Config.QueryType) ||
+			got[i].Group.Server != want[i].Group.Server || len(got[i].Group.Names) == 0 {
+			t.Errorf("migrated part %+v, want %+v", got[i], want[i])
+		}
+	}
+}
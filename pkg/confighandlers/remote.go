@@ -0,0 +1,272 @@
+package confighandlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultFetchTimeout bounds the download of a remote configuration when Fetcher.Timeout is not set.
+	DefaultFetchTimeout time.Duration = 30 * time.Second
+	// maxRemoteSize is the largest remote configuration that is accepted.
+	maxRemoteSize int64 = 64 << 20
+)
+
+// ErrChecksum is returned when a configuration does not match the pinned checksum.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// IsRemote returns true when the configuration file is an http:// or https:// URL.
+func IsRemote(file string) bool {
+	return strings.HasPrefix(file, "https://") || strings.HasPrefix(file, "http://")
+}
+
+// Fetcher downloads remote configurations into a local cache, a configuration that has not changed
+// is not downloaded again and the last good copy is used when the server can not be reached.
+//
+//nolint:govet // fieldalignment is not required here.
+type Fetcher struct {
+	// CacheDir holds the last good copy of every URL.
+	CacheDir string
+	// SHA256 is the hex checksum the configuration must have to be accepted, any when empty.
+	SHA256 string
+	// PublicKey must have signed the configuration to be accepted when it is not nil, the
+	// signature is fetched from the URL with SignatureExt and cached with the configuration.
+	PublicKey *PublicKey
+	// Timeout bounds the download, DefaultFetchTimeout when it is not set.
+	Timeout time.Duration
+	// Client sends the requests, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+// FetchResult is the local copy of a remote configuration.
+type FetchResult struct {
+	// Path of the local copy to load.
+	Path string
+	// NotModified is set when the server reported that the cached copy is current.
+	NotModified bool
+	// Fallback is the error of the download when the last good copy is used instead.
+	Fallback error
+}
+
+// cacheMeta is stored next to the cached copy of a URL for the conditional requests.
+type cacheMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// Fetch downloads the configuration at url unless the cached copy is current and returns the local
+// copy. When the download fails, or the new copy does not match the checksum or is not a valid
+// configuration, the last good copy is returned with the failure in FetchResult.Fallback; the error
+// is only set without a good copy.
+func (f *Fetcher) Fetch(ctx context.Context, url string) (*FetchResult, error) {
+	path, meta := f.paths(url)
+	result := &FetchResult{Path: path}
+	err := f.download(ctx, url, path, meta, result)
+	if err == nil {
+		return result, nil
+	}
+	if _, statErr := os.Stat(path); statErr != nil {
+		return nil, fmt.Errorf("fetch %s: %w", url, err)
+	}
+	if verifyErr := f.verifyFile(path); verifyErr != nil {
+		return nil, fmt.Errorf("fetch %s: %w, the cached copy is not valid either: %w", url, err, verifyErr)
+	}
+	result.Fallback = err
+	return result, nil
+}
+
+// download makes a conditional request for url and replaces the cached copy when it changed.
+func (f *Fetcher) download(ctx context.Context, url, path, metaPath string, result *FetchResult) error {
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = DefaultFetchTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return err
+	}
+	meta := readMeta(metaPath)
+	if _, statErr := os.Stat(path); statErr == nil && meta.URL == url {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		result.NotModified = true
+		return f.verifyFile(path)
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := readBody(resp.Body)
+	if err != nil {
+		return err
+	}
+	var sig []byte
+	if f.PublicKey != nil {
+		if sig, err = f.get(ctx, client, url+SignatureExt); err != nil {
+			return fmt.Errorf("signature: %w", err)
+		}
+	}
+	if err = f.verify(data, sig); err != nil {
+		return err
+	}
+	// the new copy is validated before it replaces the cached copy, an invalid configuration keeps
+	// the last good copy, its signature and metadata.
+	tmp, err := writeTemp(path, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if _, err = loadConfig(tmp, url, "", nil); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if sig != nil {
+		if err = writeAtomic(path+SignatureExt, sig); err != nil {
+			return err
+		}
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+	meta = cacheMeta{URL: url, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	encoded, err := json.Marshal(&meta)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(metaPath, encoded)
+}
+
+// get downloads the file at url.
+func (f *Fetcher) get(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return readBody(resp.Body)
+}
+
+// readBody reads a response body of up to maxRemoteSize bytes.
+func readBody(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxRemoteSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxRemoteSize {
+		return nil, fmt.Errorf("the response is larger than %d bytes", maxRemoteSize)
+	}
+	return data, nil
+}
+
+// paths returns the path of the cached copy of url and of its metadata.
+func (f *Fetcher) paths(url string) (string, string) {
+	sum := sha256.Sum256([]byte(url))
+	name := hex.EncodeToString(sum[:8])
+	return filepath.Join(f.CacheDir, name+".yaml"), filepath.Join(f.CacheDir, name+".json")
+}
+
+// verify checks data against the pinned checksum and its signature sig against the public key.
+func (f *Fetcher) verify(data, sig []byte) error {
+	if f.SHA256 != "" {
+		sum := sha256.Sum256(data)
+		if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, f.SHA256) {
+			return fmt.Errorf("%w: got sha256 %s, want %s", ErrChecksum, got, f.SHA256)
+		}
+	}
+	if f.PublicKey != nil {
+		return f.PublicKey.Verify(data, sig)
+	}
+	return nil
+}
+
+// verifyFile checks the file at path and its signature like verify.
+func (f *Fetcher) verifyFile(path string) error {
+	if f.SHA256 == "" && f.PublicKey == nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var sig []byte
+	if f.PublicKey != nil {
+		if sig, err = os.ReadFile(path + SignatureExt); err != nil {
+			return fmt.Errorf("%w: %w", ErrSignature, err)
+		}
+	}
+	return f.verify(data, sig)
+}
+
+// readMeta returns the metadata at path, empty when it can not be read.
+func readMeta(path string) cacheMeta {
+	var meta cacheMeta
+	if data, err := os.ReadFile(path); err == nil {
+		_ = json.Unmarshal(data, &meta)
+	}
+	return meta
+}
+
+// writeAtomic replaces the file at path with data so a reader never sees a partial file.
+func writeAtomic(path string, data []byte) error {
+	tmp, err := writeTemp(path, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Rename(tmp, path)
+}
+
+// writeTemp writes data to a new temporary file next to path and returns its name.
+func writeTemp(path string, data []byte) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
@@ -0,0 +1,184 @@
+package confighandlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const remoteConfig = "query_type:\n  hosts:\n    - google.com\n"
+
+// remoteServer serves body with an ETag and records the conditional requests it receives.
+//
+//nolint:govet // fieldalignment is not required here.
+type remoteServer struct {
+	mu          sync.Mutex
+	body        string
+	status      int
+	conditional int
+}
+
+func (s *remoteServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+	sum := sha256.Sum256([]byte(s.body))
+	etag := `"` + hex.EncodeToString(sum[:4]) + `"`
+	if r.Header.Get("If-None-Match") != "" {
+		s.conditional++
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("ETag", etag)
+	_, _ = w.Write([]byte(s.body))
+}
+
+func (s *remoteServer) set(body string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.body, s.status = body, status
+}
+
+func TestFetcherFetch(t *testing.T) {
+	srv := &remoteServer{body: remoteConfig}
+	ts := httptest.NewTLSServer(srv)
+	defer ts.Close()
+	f := &Fetcher{CacheDir: t.TempDir(), Client: ts.Client()}
+	url := ts.URL + "/dns-preload.yaml"
+
+	result, err := f.Fetch(context.Background(), url)
+	if err != nil || result.NotModified || result.Fallback != nil {
+		t.Fatalf("Fetch() = %+v, %v, expected a download", result, err)
+	}
+	cfg, err := LoadConfigFromFiles(result.Path, "")
+	if err != nil || len(cfg.QueryType.Hosts) != 1 {
+		t.Fatalf("LoadConfigFromFiles() of the fetched copy = %+v, %v", cfg, err)
+	}
+
+	result, err = f.Fetch(context.Background(), url)
+	if err != nil || !result.NotModified || srv.conditional != 1 {
+		t.Fatalf("Fetch() = %+v, %v, expected a conditional request answered with not modified", result, err)
+	}
+
+	srv.set(remoteConfig+"    - example.com\n", 0)
+	if result, err = f.Fetch(context.Background(), url); err != nil || result.NotModified {
+		t.Fatalf("Fetch() = %+v, %v, expected the changed configuration to be downloaded", result, err)
+	}
+	if data, _ := os.ReadFile(result.Path); string(data) != srv.body {
+		t.Errorf("the cached copy = %q, want %q", data, srv.body)
+	}
+
+	good := srv.body
+	srv.set("query_type:\n  hosts: [\n", 0)
+	if result, err = f.Fetch(context.Background(), url); err != nil || result.Fallback == nil {
+		t.Fatalf("Fetch() = %+v, %v, expected the last good copy for an invalid configuration", result, err)
+	}
+	if cfg, err = LoadConfigFromFiles(result.Path, ""); err != nil || len(cfg.QueryType.Hosts) != 2 {
+		t.Errorf("LoadConfigFromFiles() of the last good copy = %+v, %v", cfg, err)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(f.CacheDir, "*.tmp")); len(tmp) != 0 {
+		t.Errorf("expected the invalid configuration to be removed, got %q", tmp)
+	}
+	// the metadata of the last good copy is kept, the invalid configuration is fetched again.
+	if result, err = f.Fetch(context.Background(), url); err != nil || result.NotModified || result.Fallback == nil {
+		t.Fatalf("Fetch() = %+v, %v, expected the invalid configuration to be fetched again", result, err)
+	}
+
+	srv.set(good, http.StatusInternalServerError)
+	if result, err = f.Fetch(context.Background(), url); err != nil || result.Fallback == nil {
+		t.Fatalf("Fetch() = %+v, %v, expected the last good copy on a server error", result, err)
+	}
+	ts.Close()
+	if result, err = f.Fetch(context.Background(), url); err != nil || result.Fallback == nil {
+		t.Fatalf("Fetch() = %+v, %v, expected the last good copy when the server is down", result, err)
+	}
+	if _, err = f.Fetch(context.Background(), ts.URL+"/other.yaml"); err == nil {
+		t.Errorf("Fetch() expected an error without a cached copy")
+	}
+}
+
+func TestFetcherFetchChecksum(t *testing.T) {
+	srv := &remoteServer{body: remoteConfig}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	sum := sha256.Sum256([]byte(remoteConfig))
+	f := &Fetcher{CacheDir: t.TempDir(), SHA256: hex.EncodeToString(sum[:])}
+
+	result, err := f.Fetch(context.Background(), ts.URL)
+	if err != nil || result.Fallback != nil {
+		t.Fatalf("Fetch() = %+v, %v, expected the configuration with the pinned checksum", result, err)
+	}
+	srv.set("query_type:\n  hosts:\n    - attacker.example\n", 0)
+	result, err = f.Fetch(context.Background(), ts.URL)
+	if err != nil || !errors.Is(result.Fallback, ErrChecksum) {
+		t.Fatalf("Fetch() = %+v, %v, expected the last good copy on a checksum mismatch", result, err)
+	}
+	if data, _ := os.ReadFile(result.Path); string(data) != remoteConfig {
+		t.Errorf("the cached copy = %q, want %q", data, remoteConfig)
+	}
+
+	f = &Fetcher{CacheDir: t.TempDir(), SHA256: f.SHA256}
+	if _, err = f.Fetch(context.Background(), ts.URL); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Fetch() error = %v, want %v without a cached copy", err, ErrChecksum)
+	}
+}
+
+func TestIsRemote(t *testing.T) {
+	for file, want := range map[string]bool{
+		"https://example.com/dns.yaml": true, "http://10.0.0.1/dns.yaml": true,
+		"dns.yaml": false, "/etc/https/dns.yaml": false,
+	} {
+		if got := IsRemote(file); got != want {
+			t.Errorf("IsRemote(%q) = %v, want %v", file, got, want)
+		}
+	}
+}
+
+func TestLoadRemoteConfig(t *testing.T) {
+	dir := t.TempDir()
+	included := filepath.Join(dir, "mail.yaml")
+	if err := os.WriteFile(included, []byte("query_type:\n  mx: [foo.bar]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		include string
+		wantErr string
+	}{
+		{name: "absolute include", include: included},
+		{name: "relative include", include: "mail.yaml",
+			wantErr: "include mail.yaml is relative to the remote configuration https://config.example.com/dns-preload.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, "cached.yaml")
+			data := "query_type:\n  hosts: [foo.bar]\ninclude: [" + tt.include + "]\n"
+			if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := LoadRemoteConfig("https://config.example.com/dns-preload.yaml", file, "", nil)
+			if tt.wantErr == "" {
+				if err != nil || len(cfg.QueryType.MX) != 1 {
+					t.Errorf("LoadRemoteConfig() = %+v, %v, want the included names", cfg.QueryType, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadRemoteConfig() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package confighandlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigFromFileRoutes(t *testing.T) {
+	cfg, err := LoadConfigFromFile(ptr("test_data/routes_config_sample.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	if len(cfg.Routes) != 2 {
+		t.Fatalf("LoadConfigFromFile() = %d routes, want 2", len(cfg.Routes))
+	}
+	vpn, lab := cfg.Routes[0], cfg.Routes[1]
+	if vpn.Name != "vpn" || len(vpn.Suffixes) != 2 || vpn.Server != "10.8.0.1" || vpn.Transport != "tcp" {
+		t.Errorf("first route = %+v", vpn)
+	}
+	if lab.Regex != `^lab-[0-9]+\.example\.com$` || lab.Port != 5353 || lab.Transport != "" {
+		t.Errorf("second route = %+v", lab)
+	}
+	parts, err := cfg.Split(Selector{})
+	if err != nil || len(parts) != 1 || len(parts[0].Config.Routes) != 2 {
+		t.Errorf("Split() = %+v, %v, want one part with the routes", parts, err)
+	}
+	data, err := Migrate(cfg)
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if !strings.Contains(string(data), "routes:\n    - name: vpn\n") {
+		t.Errorf("Migrate() dropped the routes:\n%s", data)
+	}
+}
+
+func TestValidateRoutes(t *testing.T) {
+	file := "test_data/invalid_routes_config_sample.yaml"
+	got, err := ValidateFile(file)
+	if err != nil {
+		t.Fatalf("ValidateFile() error = %v", err)
+	}
+	want := []Diagnostic{
+		{Line: 7, Column: 34, Severity: SeverityError, Path: "routes[0].suffixes[1]", Message: `"bad suffix" is not a valid domain suffix`},
+		{Line: 9, Column: 16, Severity: SeverityError, Path: "routes[0].transport", Message: "transport must be one of udp, tcp, tls, got quic"},
+		{Line: 10, Column: 5, Severity: SeverityError, Path: "routes[1].server", Message: "server is required"},
+		{Line: 10, Column: 12, Severity: SeverityError, Path: "routes[1].regex", Message: "invalid regex: error parsing regexp: missing closing ]: `[0-9+\\.example\\.com$`"},
+		{Line: 11, Column: 5, Severity: SeverityError, Path: "routes[2]", Message: "route needs suffixes or a regex to match names"},
+		{Line: 12, Column: 5, Severity: SeverityError, Path: "routes[2].sufixes", Message: "unknown key sufixes, did you mean suffixes?"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ValidateFile() = %d diagnostics, want %d:\n%v", len(got), len(want), got)
+	}
+	for i := range got {
+		want[i].File = file
+		if got[i] != want[i] {
+			t.Errorf("diagnostic %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
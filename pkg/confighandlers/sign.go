@@ -0,0 +1,224 @@
+package confighandlers
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// SignatureExt is appended to the path of a configuration file for the path of its detached signature.
+const SignatureExt = ".sig"
+
+// The keys and signatures use the minisign formats, a key made with minisign -G -W signs
+// configurations for dns-preload and minisign -V verifies the signatures of config sign.
+const (
+	untrustedPrefix = "untrusted comment: "
+	trustedPrefix   = "trusted comment: "
+	keyIDSize       = 8
+	// the public key is the algorithm, the key id and the key.
+	publicKeySize = 2 + keyIDSize + ed25519.PublicKeySize
+	// the secret key is the signature, kdf and checksum algorithms, the kdf salt, opslimit and
+	// memlimit followed by the key id, the key and its checksum.
+	secretKeySize = 2 + 2 + 2 + 32 + 8 + 8 + keyIDSize + ed25519.PrivateKeySize + blake2b.Size256
+	// the signature is the algorithm, the key id and the signature.
+	signatureSize = 2 + keyIDSize + ed25519.SignatureSize
+)
+
+var (
+	// algEd signs the file itself, algEdHashed signs its BLAKE2b-512 hash.
+	algEd       = []byte("Ed")
+	algEdHashed = []byte("ED")
+	kdfNone     = []byte{0, 0}
+	chkBlake2b  = []byte("B2")
+)
+
+// ErrSignature is returned when a configuration does not have a valid signature of the public key.
+var ErrSignature = errors.New("invalid signature")
+
+// PublicKey verifies the signatures of configuration files.
+type PublicKey struct {
+	ID  [keyIDSize]byte
+	Key ed25519.PublicKey
+}
+
+// SecretKey signs configuration files.
+type SecretKey struct {
+	ID  [keyIDSize]byte
+	Key ed25519.PrivateKey
+}
+
+// GenerateKey creates a key pair with a random key id.
+func GenerateKey() (*PublicKey, *SecretKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	sk := &SecretKey{Key: priv}
+	if _, err = io.ReadFull(rand.Reader, sk.ID[:]); err != nil {
+		return nil, nil, err
+	}
+	return &PublicKey{ID: sk.ID, Key: pub}, sk, nil
+}
+
+// Public returns the public key of the secret key.
+func (k *SecretKey) Public() *PublicKey {
+	pub, _ := k.Key.Public().(ed25519.PublicKey)
+	return &PublicKey{ID: k.ID, Key: pub}
+}
+
+// keyID formats a key id the way minisign prints it.
+func keyID(id [keyIDSize]byte) string {
+	var b strings.Builder
+	for i := len(id) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%02X", id[i])
+	}
+	return b.String()
+}
+
+// String returns the base64 line of the public key, the value of a pinned key.
+func (k *PublicKey) String() string {
+	data := make([]byte, 0, publicKeySize)
+	data = append(append(append(data, algEd...), k.ID[:]...), k.Key...)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// Marshal returns the public key file.
+func (k *PublicKey) Marshal() []byte {
+	return fmt.Appendf(nil, "%sminisign public key %s\n%s\n", untrustedPrefix, keyID(k.ID), k)
+}
+
+// ParsePublicKey parses the base64 line of a public key or a public key file.
+func ParsePublicKey(text string) (*PublicKey, error) {
+	data, err := decodeLine(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if len(data) != publicKeySize || !bytes.Equal(data[:2], algEd) {
+		return nil, errors.New("invalid public key: not an ed25519 minisign key")
+	}
+	k := &PublicKey{Key: ed25519.PublicKey(data[2+keyIDSize:])}
+	copy(k.ID[:], data[2:])
+	return k, nil
+}
+
+// Marshal returns the unencrypted secret key file.
+func (k *SecretKey) Marshal() []byte {
+	data := make([]byte, 0, secretKeySize)
+	data = append(append(append(data, algEd...), kdfNone...), chkBlake2b...)
+	// the kdf salt, opslimit and memlimit are not used without a kdf.
+	data = append(data, make([]byte, 32+8+8)...)
+	data = append(append(data, k.ID[:]...), k.Key...)
+	data = append(data, k.checksum()...)
+	return fmt.Appendf(nil, "%sminisign secret key %s\n%s\n", untrustedPrefix, keyID(k.ID), base64.StdEncoding.EncodeToString(data))
+}
+
+// ParseSecretKey parses an unencrypted secret key file.
+func ParseSecretKey(text string) (*SecretKey, error) {
+	data, err := decodeLine(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret key: %w", err)
+	}
+	if len(data) != secretKeySize || !bytes.Equal(data[:2], algEd) || !bytes.Equal(data[4:6], chkBlake2b) {
+		return nil, errors.New("invalid secret key: not an ed25519 minisign key")
+	}
+	if !bytes.Equal(data[2:4], kdfNone) {
+		return nil, errors.New("the secret key is encrypted, create it without a password with minisign -G -W or config keygen")
+	}
+	data = data[2+2+2+32+8+8:]
+	k := &SecretKey{Key: ed25519.PrivateKey(data[keyIDSize : keyIDSize+ed25519.PrivateKeySize])}
+	copy(k.ID[:], data)
+	if !bytes.Equal(k.checksum(), data[keyIDSize+ed25519.PrivateKeySize:]) {
+		return nil, errors.New("invalid secret key: checksum mismatch")
+	}
+	return k, nil
+}
+
+// checksum is the BLAKE2b-256 hash of the algorithm, key id and key of the secret key file.
+func (k *SecretKey) checksum() []byte {
+	h, _ := blake2b.New256(nil)
+	h.Write(algEd)
+	h.Write(k.ID[:])
+	h.Write(k.Key)
+	return h.Sum(nil)
+}
+
+// Sign returns the detached signature of data for the file name, name is recorded in the trusted
+// comment with the time of the signature.
+func (k *SecretKey) Sign(data []byte, name string) []byte {
+	hash := blake2b.Sum512(data)
+	sig := ed25519.Sign(k.Key, hash[:])
+	comment := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), filepath.Base(name))
+	global := ed25519.Sign(k.Key, append(bytes.Clone(sig), comment...))
+	line := make([]byte, 0, signatureSize)
+	line = append(append(append(line, algEdHashed...), k.ID[:]...), sig...)
+	return fmt.Appendf(nil, "%ssignature from minisign secret key\n%s\n%s%s\n%s\n", untrustedPrefix,
+		base64.StdEncoding.EncodeToString(line), trustedPrefix, comment, base64.StdEncoding.EncodeToString(global))
+}
+
+// Verify checks the detached signature sig of data, a signature of another key is not valid.
+func (k *PublicKey) Verify(data, sig []byte) error {
+	lines := strings.Split(strings.TrimRight(string(sig), "\r\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], untrustedPrefix) || !strings.HasPrefix(lines[2], trustedPrefix) {
+		return fmt.Errorf("%w: not a minisign signature", ErrSignature)
+	}
+	line, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(line) != signatureSize {
+		return fmt.Errorf("%w: not a minisign signature", ErrSignature)
+	}
+	if !bytes.Equal(line[2:2+keyIDSize], k.ID[:]) {
+		var id [keyIDSize]byte
+		copy(id[:], line[2:])
+		return fmt.Errorf("%w: signed with key %s, want key %s", ErrSignature, keyID(id), keyID(k.ID))
+	}
+	signed := data
+	switch {
+	case bytes.Equal(line[:2], algEdHashed):
+		hash := blake2b.Sum512(data)
+		signed = hash[:]
+	case !bytes.Equal(line[:2], algEd):
+		return fmt.Errorf("%w: unknown algorithm %q", ErrSignature, line[:2])
+	}
+	sig = line[2+keyIDSize:]
+	if !ed25519.Verify(k.Key, signed, sig) {
+		return fmt.Errorf("%w: the file does not match its signature", ErrSignature)
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	comment := strings.TrimSuffix(strings.TrimPrefix(lines[2], trustedPrefix), "\r")
+	if err != nil || !ed25519.Verify(k.Key, append(bytes.Clone(sig), comment...), global) {
+		return fmt.Errorf("%w: the trusted comment does not match its signature", ErrSignature)
+	}
+	return nil
+}
+
+// VerifyFile checks the file at path against its detached signature at path with SignatureExt.
+func (k *PublicKey) VerifyFile(path string, data []byte) error {
+	sig, err := os.ReadFile(path + SignatureExt)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSignature, err)
+	}
+	return k.Verify(data, sig)
+}
+
+// decodeLine decodes the key of a key file, the last line that is not a comment, or of a single line.
+func decodeLine(text string) ([]byte, error) {
+	var line string
+	for l := range strings.SplitSeq(text, "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, untrustedPrefix) {
+			line = l
+		}
+	}
+	if line == "" {
+		return nil, errors.New("no key found")
+	}
+	return base64.StdEncoding.DecodeString(line)
+}
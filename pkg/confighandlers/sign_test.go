@@ -0,0 +1,165 @@
+package confighandlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretKeySign(t *testing.T) {
+	pub, secret, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseSecretKey(string(secret.Marshal()))
+	if err != nil || !bytes.Equal(parsed.Key, secret.Key) || parsed.ID != secret.ID {
+		t.Fatalf("ParseSecretKey() = %+v, %v, want the generated key", parsed, err)
+	}
+	if pub, err = ParsePublicKey(string(pub.Marshal())); err != nil {
+		t.Fatalf("ParsePublicKey() error = %v", err)
+	}
+	if line, err := ParsePublicKey(pub.String()); err != nil || !bytes.Equal(line.Key, pub.Key) {
+		t.Fatalf("ParsePublicKey() of the key line = %+v, %v", line, err)
+	}
+	other, _, _ := GenerateKey()
+	data := []byte(remoteConfig)
+	sig := parsed.Sign(data, "/etc/dns-preload.yaml")
+	if !bytes.Contains(sig, []byte("\tfile:dns-preload.yaml\thashed\n")) {
+		t.Errorf("Sign() = %s, expected the file in the trusted comment", sig)
+	}
+	tests := []struct {
+		name string
+		key  *PublicKey
+		data []byte
+		sig  []byte
+		want string
+	}{
+		{name: "valid", key: pub, data: data, sig: sig},
+		{name: "tampered file", key: pub, data: append([]byte(remoteConfig), "    - attacker.example\n"...), sig: sig,
+			want: "the file does not match its signature"},
+		{name: "another key", key: other, data: data, sig: sig, want: "signed with key"},
+		{name: "tampered trusted comment", key: pub, data: data, sig: bytes.Replace(sig, []byte("hashed"), []byte("hashex"), 1),
+			want: "the trusted comment does not match its signature"},
+		{name: "not a signature", key: pub, data: data, sig: []byte("foo"), want: "not a minisign signature"},
+		{name: "legacy signature", key: pub, data: data, sig: legacySignature(secret, data)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.key.Verify(tt.data, tt.sig)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Verify() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrSignature) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Verify() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// legacySignature signs data itself rather than its hash like minisign -l.
+func legacySignature(k *SecretKey, data []byte) []byte {
+	sig := ed25519.Sign(k.Key, data)
+	comment := "timestamp:0\tfile:dns-preload.yaml"
+	global := ed25519.Sign(k.Key, append(bytes.Clone(sig), comment...))
+	line := append(append([]byte("Ed"), k.ID[:]...), sig...)
+	return fmt.Appendf(nil, "untrusted comment: legacy\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(line), comment, base64.StdEncoding.EncodeToString(global))
+}
+
+func TestParseSecretKeyEncrypted(t *testing.T) {
+	_, secret, _ := GenerateKey()
+	data, _ := decodeLine(string(secret.Marshal()))
+	copy(data[2:4], "Sc")
+	if _, err := ParseSecretKey(base64.StdEncoding.EncodeToString(data)); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Errorf("ParseSecretKey() of an encrypted key error = %v", err)
+	}
+	data[len(data)-1] ^= 1
+	copy(data[2:4], []byte{0, 0})
+	if _, err := ParseSecretKey(base64.StdEncoding.EncodeToString(data)); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("ParseSecretKey() of a corrupted key error = %v", err)
+	}
+}
+
+func TestLoadSignedConfigFromFiles(t *testing.T) {
+	pub, secret, _ := GenerateKey()
+	dir := writeFiles(t, map[string]string{
+		"main.yaml":    "query_type:\n  hosts: [google.com]\ninclude: [extra.yaml]\n",
+		"extra.yaml":   "query_type:\n  hosts: [a.example.com]\n",
+		"conf.d/c.yml": "query_type:\n  hosts: [c.example.com]\n",
+	})
+	sign := func(name string) {
+		path := filepath.Join(dir, name)
+		data, _ := os.ReadFile(path)
+		if err := os.WriteFile(path+SignatureExt, secret.Sign(data, path), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sign("main.yaml")
+	sign("extra.yaml")
+	main, confDir := filepath.Join(dir, "main.yaml"), filepath.Join(dir, "conf.d")
+
+	if cfg, err := LoadSignedConfigFromFiles(main, "", pub); err != nil || len(cfg.QueryType.Hosts) != 2 {
+		t.Fatalf("LoadSignedConfigFromFiles() = %+v, %v, want the names of both signed files", cfg, err)
+	}
+	_, err := LoadSignedConfigFromFiles(main, confDir, pub)
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Diagnostics) != 1 || verr.Diagnostics[0].File != filepath.Join(confDir, "c.yml") {
+		t.Fatalf("LoadSignedConfigFromFiles() error = %v, want the unsigned file of the conf.d directory", err)
+	}
+	sign("conf.d/c.yml")
+	if _, err = LoadSignedConfigFromFiles(main, confDir, pub); err != nil {
+		t.Fatalf("LoadSignedConfigFromFiles() error = %v", err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "extra.yaml"), []byte("query_type:\n  hosts: [attacker.example]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadSignedConfigFromFiles(main, "", pub); err == nil || !strings.Contains(err.Error(), "does not match its signature") {
+		t.Errorf("LoadSignedConfigFromFiles() error = %v, want the tampered include to be rejected", err)
+	}
+	if _, err = LoadConfigFromFiles(main, ""); err != nil {
+		t.Errorf("LoadConfigFromFiles() without a key error = %v", err)
+	}
+}
+
+func TestFetcherFetchSignature(t *testing.T) {
+	pub, secret, _ := GenerateKey()
+	srv := &remoteServer{body: remoteConfig}
+	sig := secret.Sign([]byte(remoteConfig), "dns-preload.yaml")
+	mux := http.NewServeMux()
+	mux.Handle("/dns-preload.yaml", srv)
+	mux.HandleFunc("/dns-preload.yaml"+SignatureExt, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(sig)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	f := &Fetcher{CacheDir: t.TempDir(), PublicKey: pub}
+	url := ts.URL + "/dns-preload.yaml"
+
+	result, err := f.Fetch(context.Background(), url)
+	if err != nil || result.Fallback != nil {
+		t.Fatalf("Fetch() = %+v, %v, expected the signed configuration", result, err)
+	}
+	if _, err = LoadSignedConfigFromFiles(result.Path, "", pub); err != nil {
+		t.Fatalf("LoadSignedConfigFromFiles() of the fetched copy error = %v", err)
+	}
+	srv.set("query_type:\n  hosts:\n    - attacker.example\n", 0)
+	result, err = f.Fetch(context.Background(), url)
+	if err != nil || !errors.Is(result.Fallback, ErrSignature) {
+		t.Fatalf("Fetch() = %+v, %v, expected the last good copy for a file that does not match its signature", result, err)
+	}
+	if data, _ := os.ReadFile(result.Path); string(data) != remoteConfig {
+		t.Errorf("the cached copy = %q, want %q", data, remoteConfig)
+	}
+}
@@ -0,0 +1,772 @@
+package confighandlers
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	validator "github.com/go-playground/validator/v10"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	// maxNames is the number of names a section can hold, see Uint16.
+	maxNames int = 65535
+	// the keys of the configuration that hold other keys.
+	keyVersion   string = "version"
+	keyQueryType string = "query_type"
+	keyNames     string = "names"
+	keyRetry     string = "retry"
+	keyRateLimit string = "rate_limit"
+	keyServers   string = "servers"
+	keyGroups    string = "groups"
+	keyTags      string = "tags"
+	keyPriority  string = "priority"
+	keyRoutes    string = "routes"
+	keySuffixes  string = "suffixes"
+	keyRegex     string = "regex"
+	keySettings  string = "settings"
+	keyInclude   string = "include"
+)
+
+// Severity of a Diagnostic, errors make the configuration invalid.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a problem found in a configuration file, Line and Column are the position of the
+// YAML node it refers to starting at 1.
+//
+//nolint:govet // fieldalignment is not required here, field order matches the json output.
+type Diagnostic struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Severity Severity `json:"severity"`
+	// Path is the location of the node in the configuration, e.g. query_type.hosts[2].
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+// String formats the diagnostic as file:line:column: severity: message like a compiler.
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Severity, d.Message)
+}
+
+// ValidationError is returned when a configuration file has errors, it lists every one of them.
+type ValidationError struct {
+	Diagnostics []Diagnostic
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Diagnostics))
+	for _, d := range e.Diagnostics {
+		msgs = append(msgs, d.String())
+	}
+	return fmt.Sprintf("invalid configuration:\n  %s", strings.Join(msgs, "\n  "))
+}
+
+// Errors returns the diagnostics with the error severity.
+func Errors(diags []Diagnostic) []Diagnostic {
+	errs := make([]Diagnostic, 0, len(diags))
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			errs = append(errs, d)
+		}
+	}
+	return errs
+}
+
+// ValidateFile reads the configuration file and the files it includes and returns every problem
+// found in them, the error is only set when the file can not be read.
+func ValidateFile(file string) ([]Diagnostic, error) {
+	return ValidateFiles(file, "")
+}
+
+// Validate checks the configuration in data and returns every problem found in it sorted by
+// position, file is the name used in the diagnostics. Unknown and duplicate keys, names that are
+// not valid for their query type and a configuration without names are errors, empty sections and
+// names listed twice in a section are warnings. The included files are not read.
+func Validate(file string, data []byte) []Diagnostic {
+	v := newValidation(file, false)
+	v.run(data)
+	return v.diags
+}
+
+// newValidation returns the validation of file, the names of a fragment may be in other files.
+func newValidation(file string, fragment bool) *validation {
+	return &validation{file: file, validate: newValidator(), fragment: fragment, budget: MaxExpansions}
+}
+
+// run checks the configuration in data and sorts the diagnostics by position.
+func (v *validation) run(data []byte) {
+	defer v.sort()
+	root := &yaml.Node{}
+	if err := yaml.Unmarshal(data, root); err != nil {
+		v.yamlError(err, nil)
+		return
+	}
+	if len(root.Content) == 0 {
+		if !v.fragment {
+			v.diags = append(v.diags, Diagnostic{File: v.file, Line: 1, Column: 1, Severity: SeverityError, Message: "empty configuration"})
+		}
+		return
+	}
+	v.root(root.Content[0])
+}
+
+// sort orders the diagnostics by position.
+func (v *validation) sort() {
+	slices.SortStableFunc(v.diags, func(a, b Diagnostic) int {
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		return a.Column - b.Column
+	})
+}
+
+// serviceLabel matches the service and protocol labels of an SRV name such as _xmpp-server or
+// _tcp, a service name has at most 15 letters, digits and hyphens and does not end with a hyphen.
+var serviceLabel = regexp.MustCompile(`^_[A-Za-z0-9](?:[A-Za-z0-9-]{0,13}[A-Za-z0-9])?$`)
+
+// newValidator returns a validator that names the fields by their yaml key, srv_name validates
+// the _service._proto.hostname names of SRV lookups.
+func newValidator() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		return name
+	})
+	_ = validate.RegisterValidation("srv_name", func(fl validator.FieldLevel) bool {
+		labels := strings.SplitN(fl.Field().String(), ".", 3)
+		return len(labels) == 3 && serviceLabel.MatchString(labels[0]) && serviceLabel.MatchString(labels[1]) &&
+			validate.Var(labels[2], "fqdn") == nil
+	})
+	return validate
+}
+
+// validation collects the diagnostics of a configuration.
+type validation struct {
+	file     string
+	validate *validator.Validate
+	diags    []Diagnostic
+	// fragment is set for the files that are included, their names are checked with the names of
+	// every file. total is the number of names in the file and includes are the include patterns.
+	fragment bool
+	total    int
+	includes []*yaml.Node
+	// budget is the number of names the templates of the file can still expand to.
+	budget int
+}
+
+func (v *validation) add(node *yaml.Node, severity Severity, path, format string, args ...any) {
+	v.diags = append(v.diags, Diagnostic{
+		File:     v.file,
+		Line:     node.Line,
+		Column:   node.Column,
+		Severity: severity,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// root checks the top level mapping of the configuration.
+func (v *validation) root(node *yaml.Node) {
+	keys := v.mapping(node, "", yamlKeys(reflect.TypeFor[Configuration]()))
+	if keys == nil {
+		return
+	}
+	version := v.version(keys)
+	total, found := v.lists(keys, version, "")
+	groups, hasGroups := keys[keyGroups]
+	if hasGroups {
+		total += v.groups(groups, version)
+	}
+	v.total = total
+	if include, ok := keys[keyInclude]; ok {
+		v.include(include)
+	}
+	list := keyQueryType
+	if version == Version2 {
+		list = keyNames
+	}
+	switch kv, ok := keys[list]; {
+	case v.fragment || len(v.includes) > 0:
+		// the names may be in the other files, see loader.
+	case !found && !hasGroups:
+		v.add(node, SeverityError, "", "missing key %s", list)
+	case total > 0:
+	case ok:
+		v.add(kv[0], SeverityError, list, "empty configuration, %s has no names", list)
+	default:
+		v.add(groups[0], SeverityError, keyGroups, "empty configuration, %s have no names", keyGroups)
+	}
+	if retry, ok := keys[keyRetry]; ok {
+		v.section(retry, keyRetry, &Retry{})
+	}
+	if rateLimit, ok := keys[keyRateLimit]; ok {
+		v.rateLimit(rateLimit)
+	}
+	if routes, ok := keys[keyRoutes]; ok {
+		v.routes(routes)
+	}
+	if settings, ok := keys[keySettings]; ok {
+		v.section(settings, keySettings, &Settings{})
+	}
+}
+
+// lists checks the names under keys in the layout of version, path is the location of keys. It
+// returns the number of names and whether the key of the layout is set.
+func (v *validation) lists(keys map[string][2]*yaml.Node, version int, path string) (int, bool) {
+	if version == Version2 {
+		if queryType, ok := keys[keyQueryType]; ok {
+			v.add(queryType[0], SeverityError, keyPath(path, keyQueryType), "%s is the version 1 layout, version 2 lists the names under %s", keyQueryType, keyNames)
+		}
+		names, ok := keys[keyNames]
+		if !ok {
+			return 0, false
+		}
+		return v.namesV2(names, keyPath(path, keyNames)), true
+	}
+	if names, ok := keys[keyNames]; ok {
+		v.add(names[0], SeverityError, keyPath(path, keyNames), "%s needs %s: %d", keyNames, keyVersion, Version2)
+	}
+	queryType, ok := keys[keyQueryType]
+	if !ok {
+		return 0, false
+	}
+	return v.queryType(queryType, keyPath(path, keyQueryType)), true
+}
+
+// groups checks every group and returns the number of names in them, the group names are tags.
+func (v *validation) groups(kv [2]*yaml.Node, version int) int {
+	node := kv[1]
+	if node.Tag == "!!null" {
+		return 0
+	}
+	if node.Kind != yaml.MappingNode {
+		v.add(node, SeverityError, keyGroups, "%s must map each group name to its names", keyGroups)
+		return 0
+	}
+	total := 0
+	seen := make(map[string]int, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		group := [2]*yaml.Node{node.Content[i], node.Content[i+1]}
+		path := keyPath(keyGroups, group[0].Value)
+		if line, ok := seen[group[0].Value]; ok {
+			v.add(group[0], SeverityError, path, "duplicate group %s, first defined on line %d", group[0].Value, line)
+			continue
+		}
+		seen[group[0].Value] = group[0].Line
+		v.tag(group[0], path)
+		keys := v.section(group, path, &Group{})
+		if keys == nil {
+			continue
+		}
+		if tags, ok := keys[keyTags]; ok && tags[1].Kind == yaml.SequenceNode {
+			v.tags(tags, keyPath(path, keyTags))
+		}
+		count, _ := v.lists(keys, version, path)
+		if count == 0 {
+			v.add(group[0], SeverityWarning, path, "group %s has no names", group[0].Value)
+		}
+		total += count
+	}
+	return total
+}
+
+// tags checks a list of tags.
+func (v *validation) tags(kv [2]*yaml.Node, path string) {
+	node := kv[1]
+	if node.Kind != yaml.SequenceNode {
+		v.add(node, SeverityError, path, "%s must be a list of tags", kv[0].Value)
+		return
+	}
+	for i, tag := range node.Content {
+		v.tag(tag, fmt.Sprintf("%s[%d]", path, i))
+	}
+}
+
+// tag checks that node is a tag, the --tag flags are comma separated so a tag is a single word.
+func (v *validation) tag(node *yaml.Node, path string) {
+	if node.Kind != yaml.ScalarNode || node.Value == "" || strings.ContainsAny(node.Value, ", \t") {
+		v.add(node, SeverityError, path, "%q is not a valid tag, a tag can not be empty or contain commas or spaces", node.Value)
+	}
+}
+
+// version returns the version of the file format, Version1 when it is not set or not supported.
+func (v *validation) version(keys map[string][2]*yaml.Node) int {
+	kv, ok := keys[keyVersion]
+	if !ok {
+		return Version1
+	}
+	version, err := strconv.Atoi(kv[1].Value)
+	if kv[1].Kind != yaml.ScalarNode || err != nil || (version != Version1 && version != Version2) {
+		v.add(kv[1], SeverityError, keyVersion, "unsupported version %s, expected %d or %d", kv[1].Value, Version1, Version2)
+		return Version1
+	}
+	return version
+}
+
+// queryType checks the lists of names of every query type and returns the number of names.
+func (v *validation) queryType(kv [2]*yaml.Node, path string) int {
+	node := kv[1]
+	tags := listTags(reflect.TypeFor[QueryType]())
+	keys := v.mapping(node, path, tags)
+	if keys == nil {
+		return 0
+	}
+	total := 0
+	for _, name := range slices.Sorted(maps.Keys(tags)) {
+		tag := tags[name]
+		section, ok := keys[name]
+		if ok {
+			total += v.names(section, path+"."+name, tag)
+		}
+	}
+	return total
+}
+
+// names checks the list of names of a query type and returns the number of names in it, a
+// template counts the names it expands to.
+func (v *validation) names(kv [2]*yaml.Node, path, tag string) int {
+	key, node := kv[0], kv[1]
+	switch {
+	case node.Tag == "!!null", node.Kind == yaml.SequenceNode && len(node.Content) == 0:
+		v.add(key, SeverityWarning, path, "section %s is empty", key.Value)
+		return 0
+	case node.Kind != yaml.SequenceNode:
+		v.add(node, SeverityError, path, "section %s must be a list of names", key.Value)
+		return 0
+	}
+	total := 0
+	seen := make(map[string]int, len(node.Content))
+	for i, entry := range node.Content {
+		entryPath := fmt.Sprintf("%s[%d]", path, i)
+		names := v.expand(entry, entryPath)
+		total += max(len(names), 1)
+		if names == nil || !v.valid(names, entry, entryPath, tag) {
+			continue
+		}
+		for _, name := range names {
+			canonical := strings.ToLower(strings.TrimSuffix(name, "."))
+			if line, ok := seen[canonical]; ok {
+				v.add(entry, SeverityWarning, entryPath, "duplicate name %q in section %s, first listed on line %d", name, key.Value, line)
+				continue
+			}
+			seen[canonical] = entry.Line
+		}
+	}
+	if total > maxNames {
+		v.add(key, SeverityError, path, "section %s has %d names, the limit is %d", key.Value, total, maxNames)
+	}
+	return total
+}
+
+// expand checks that entry is a name or a template and returns its names, nil when it is not valid.
+func (v *validation) expand(entry *yaml.Node, path string) []string {
+	if entry.Kind != yaml.ScalarNode || entry.Tag == "!!null" || entry.Value == "" {
+		v.add(entry, SeverityError, path, "expected a name")
+		return nil
+	}
+	names, err := expandName(entry.Value, &v.budget)
+	if err != nil {
+		v.add(entry, SeverityError, path, "%s", err)
+		return nil
+	}
+	return names
+}
+
+// valid checks that the names of entry are valid for the validation tag and returns true when
+// they are, only the first invalid name of a template is reported.
+func (v *validation) valid(names []string, entry *yaml.Node, path, tag string) bool {
+	for _, name := range names {
+		if err := v.validate.Var(name, tag); err != nil {
+			v.fieldErrors(err, entry, path, name)
+			return false
+		}
+	}
+	return true
+}
+
+// namesV2 checks the names of a version 2 configuration, each is a mapping with the name, its
+// query types and tags. It returns the number of names, a template counts the names it expands to.
+func (v *validation) namesV2(kv [2]*yaml.Node, path string) int {
+	key, node := kv[0], kv[1]
+	if node.Tag == "!!null" {
+		return 0
+	}
+	if node.Kind != yaml.SequenceNode {
+		v.add(node, SeverityError, path, "%s must list the names with their query types", key.Value)
+		return 0
+	}
+	tags := listTags(reflect.TypeFor[QueryType]())
+	// seen holds the line each name was first listed on for every query type.
+	seen := make(map[string]int)
+	counts := make(map[string]int)
+	total := len(node.Content)
+	for i, entry := range node.Content {
+		path := fmt.Sprintf("%s[%d]", path, i)
+		keys := v.mapping(entry, path, yamlKeys(reflect.TypeFor[Name]()))
+		if keys == nil {
+			continue
+		}
+		if tags, ok := keys[keyTags]; ok {
+			v.tags(tags, path+"."+keyTags)
+		}
+		if priority, ok := keys[keyPriority]; ok {
+			if _, err := strconv.Atoi(priority[1].Value); priority[1].Kind != yaml.ScalarNode || err != nil {
+				v.add(priority[1], SeverityError, path+"."+keyPriority, "%s must be a whole number, got %s", keyPriority, priority[1].Value)
+			}
+		}
+		name, ok := keys["name"]
+		if !ok {
+			v.add(entry, SeverityError, path, "missing key name")
+			continue
+		}
+		types, ok := keys["types"]
+		if !ok || types[1].Kind != yaml.SequenceNode || len(types[1].Content) == 0 {
+			v.add(entry, SeverityError, path+".types", "%s needs a list of query types", name[1].Value)
+			continue
+		}
+		names := v.expand(name[1], path+".name")
+		if names == nil {
+			continue
+		}
+		checked := make(map[string]bool)
+		for j, typ := range types[1].Content {
+			typePath := fmt.Sprintf("%s.types[%d]", path, j)
+			tag, known := tags[typ.Value]
+			switch {
+			case !known:
+				v.add(typ, SeverityError, typePath, "unknown query type %s, expected %s", typ.Value, strings.Join(QueryTypes, ", "))
+				continue
+			case counts[typ.Value] <= maxNames && counts[typ.Value]+len(names) > maxNames:
+				v.add(typ, SeverityError, typePath, "query type %s has more than %d names", typ.Value, maxNames)
+			}
+			counts[typ.Value] += len(names)
+			if _, done := checked[tag]; !done {
+				checked[tag] = v.valid(names, name[1], path+".name", tag)
+			}
+			if !checked[tag] {
+				continue
+			}
+			for _, n := range names {
+				dup := typ.Value + " " + strings.ToLower(strings.TrimSuffix(n, "."))
+				if line, ok := seen[dup]; ok {
+					v.add(typ, SeverityWarning, typePath, "duplicate name %q for query type %s, first listed on line %d", n, typ.Value, line)
+					continue
+				}
+				seen[dup] = name[1].Line
+			}
+		}
+		total += len(names) - 1
+	}
+	return total
+}
+
+// rateLimit checks the rate limit and the limit of each server.
+func (v *validation) rateLimit(kv [2]*yaml.Node) {
+	limit := &RateLimit{}
+	keys := v.section(kv, keyRateLimit, limit)
+	servers, ok := keys[keyServers]
+	if !ok || servers[1].Tag == "!!null" {
+		return
+	}
+	path := keyRateLimit + "." + keyServers
+	if servers[1].Kind != yaml.MappingNode {
+		v.add(servers[1], SeverityError, path, "%s must map each server to its rate limit", keyServers)
+		return
+	}
+	for i := 0; i+1 < len(servers[1].Content); i += 2 {
+		server := [2]*yaml.Node{servers[1].Content[i], servers[1].Content[i+1]}
+		v.section(server, path+"."+server[0].Value, &ServerRateLimit{})
+	}
+}
+
+// routes checks every route, a route needs suffixes or a regex to match the names it routes.
+func (v *validation) routes(kv [2]*yaml.Node) {
+	node := kv[1]
+	if node.Tag == "!!null" {
+		return
+	}
+	if node.Kind != yaml.SequenceNode {
+		v.add(node, SeverityError, keyRoutes, "%s must be a list of routes", keyRoutes)
+		return
+	}
+	for i, entry := range node.Content {
+		path := fmt.Sprintf("%s[%d]", keyRoutes, i)
+		keys := v.section([2]*yaml.Node{nil, entry}, path, &Route{})
+		if keys == nil {
+			continue
+		}
+		suffixes, hasSuffixes := keys[keySuffixes]
+		regex, hasRegex := keys[keyRegex]
+		if !hasSuffixes && !hasRegex {
+			v.add(entry, SeverityError, path, "route needs %s or a %s to match names", keySuffixes, keyRegex)
+		}
+		if hasSuffixes {
+			v.suffixes(suffixes, keyPath(path, keySuffixes))
+		}
+		if hasRegex {
+			if _, err := regexp.Compile(regex[1].Value); err != nil {
+				v.add(regex[1], SeverityError, keyPath(path, keyRegex), "invalid %s: %s", keyRegex, err)
+			}
+		}
+	}
+}
+
+// include checks the include patterns and keeps them for the loader, a pattern is a path or a glob
+// relative to the directory of the file.
+func (v *validation) include(kv [2]*yaml.Node) {
+	node := kv[1]
+	if node.Tag == "!!null" {
+		return
+	}
+	if node.Kind != yaml.SequenceNode {
+		v.add(node, SeverityError, keyInclude, "%s must be a list of files or globs", keyInclude)
+		return
+	}
+	for i, pattern := range node.Content {
+		path := fmt.Sprintf("%s[%d]", keyInclude, i)
+		if pattern.Kind != yaml.ScalarNode || pattern.Value == "" {
+			v.add(pattern, SeverityError, path, "expected a file or glob")
+			continue
+		}
+		if _, err := filepath.Match(pattern.Value, ""); err != nil {
+			v.add(pattern, SeverityError, path, "invalid glob %q: %s", pattern.Value, err)
+			continue
+		}
+		v.includes = append(v.includes, pattern)
+	}
+}
+
+// suffixes checks the domain suffixes of a route.
+func (v *validation) suffixes(kv [2]*yaml.Node, path string) {
+	node := kv[1]
+	if node.Kind != yaml.SequenceNode || len(node.Content) == 0 {
+		v.add(node, SeverityError, path, "%s must be a list of domain suffixes", kv[0].Value)
+		return
+	}
+	for i, suffix := range node.Content {
+		err := v.validate.Var(strings.TrimSuffix(suffix.Value, "."), "hostname_rfc1123")
+		if suffix.Kind != yaml.ScalarNode || suffix.Value == "" || err != nil {
+			v.add(suffix, SeverityError, fmt.Sprintf("%s[%d]", path, i), "%q is not a valid domain suffix", suffix.Value)
+		}
+	}
+}
+
+// section decodes the mapping of kv into out and checks its keys and values, it returns the keys
+// of the mapping.
+func (v *validation) section(kv [2]*yaml.Node, path string, out any) map[string][2]*yaml.Node {
+	node := kv[1]
+	keys := v.mapping(node, path, yamlKeys(reflect.TypeOf(out).Elem()))
+	if keys == nil {
+		return nil
+	}
+	if err := node.Decode(out); err != nil {
+		v.yamlError(err, node)
+		return keys
+	}
+	// the limits of each server are checked on their own so their errors point at the server.
+	if limit, ok := out.(*RateLimit); ok {
+		limit.Servers = nil
+	}
+	if err := v.validate.Struct(out); err != nil {
+		var fieldErrs validator.ValidationErrors
+		if !errors.As(err, &fieldErrs) {
+			v.add(node, SeverityError, path, "%s", err)
+			return keys
+		}
+		for _, fe := range fieldErrs {
+			at := node
+			if field, ok := keys[fe.Field()]; ok {
+				at = field[1]
+			}
+			v.add(at, SeverityError, path+"."+fe.Field(), "%s", message(fe, at.Value))
+		}
+	}
+	return keys
+}
+
+// mapping checks that node is a mapping without duplicate or unknown keys and returns the key and
+// value nodes by key, or nil when node is not a mapping.
+func (v *validation) mapping(node *yaml.Node, path string, known map[string]string) map[string][2]*yaml.Node {
+	if node.Tag == "!!null" {
+		return map[string][2]*yaml.Node{}
+	}
+	if node.Kind != yaml.MappingNode {
+		v.add(node, SeverityError, path, "expected a mapping of keys")
+		return nil
+	}
+	keys := make(map[string][2]*yaml.Node, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		fieldPath := keyPath(path, key.Value)
+		if first, ok := keys[key.Value]; ok {
+			v.add(key, SeverityError, fieldPath, "duplicate key %s, first defined on line %d", key.Value, first[0].Line)
+			continue
+		}
+		keys[key.Value] = [2]*yaml.Node{key, value}
+		if _, ok := known[key.Value]; !ok {
+			v.add(key, SeverityError, fieldPath, "unknown key %s%s", key.Value, suggest(key.Value, known))
+		}
+	}
+	return keys
+}
+
+// keyPath returns the path of key in the mapping at path.
+func keyPath(path, key string) string {
+	return strings.TrimPrefix(path+"."+key, ".")
+}
+
+// fieldErrors adds a diagnostic at node for every failed validation in err.
+func (v *validation) fieldErrors(err error, node *yaml.Node, path, value string) {
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		v.add(node, SeverityError, path, "%s", err)
+		return
+	}
+	for _, fe := range fieldErrs {
+		v.add(node, SeverityError, path, "%s", message(fe, value))
+	}
+}
+
+// message describes a failed validation of value.
+func message(fe validator.FieldError, value string) string {
+	name := fe.Field()
+	switch fe.Tag() {
+	case "fqdn":
+		return fmt.Sprintf("%q is not a valid fully qualified domain name", value)
+	case "ip_addr":
+		return fmt.Sprintf("%q is not a valid IP address", value)
+	case "srv_name":
+		return fmt.Sprintf("%q is not a valid SRV name, expected _service._proto.hostname", value)
+	case "required":
+		if name == "" {
+			return "the name must not be empty"
+		}
+		return fmt.Sprintf("%s is required", name)
+	case "gte":
+		return fmt.Sprintf("%s must be at least %s, got %s", name, fe.Param(), value)
+	case "gt":
+		return fmt.Sprintf("%s must be greater than %s, got %s", name, fe.Param(), value)
+	case "hostname_rfc1123|ip_addr":
+		return fmt.Sprintf("%s %q is not a valid host name or IP address", name, value)
+	case "lte":
+		return fmt.Sprintf("%s must be at most %s, got %s", name, fe.Param(), value)
+	case "number|eq=auto":
+		return fmt.Sprintf("%s must be a number or auto, got %s", name, value)
+	case "oneof":
+		return fmt.Sprintf("%s must be one of %s, got %s", name, strings.ReplaceAll(fe.Param(), " ", ", "), value)
+	}
+	return fmt.Sprintf("%s fails the %s check", strings.TrimSpace(name+" "+value), fe.Tag())
+}
+
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlError adds the errors of the YAML parser or decoder, the column is taken from the node of
+// the same line below node when there is one.
+func (v *validation) yamlError(err error, node *yaml.Node) {
+	msgs := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		msgs = typeErr.Errors
+	}
+	for _, msg := range msgs {
+		d := Diagnostic{File: v.file, Line: 1, Column: 1, Severity: SeverityError, Message: strings.TrimPrefix(msg, "yaml: ")}
+		if m := yamlLine.FindStringSubmatch(msg); m != nil {
+			d.Line, _ = strconv.Atoi(m[1])
+			d.Message = m[2]
+			if at := nodeAtLine(node, d.Line); at != nil {
+				d.Column = at.Column
+			}
+		}
+		v.diags = append(v.diags, d)
+	}
+}
+
+// nodeAtLine returns the last scalar node on line below node, the value of a key on that line.
+func nodeAtLine(node *yaml.Node, line int) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+	var found *yaml.Node
+	if node.Kind == yaml.ScalarNode && node.Line == line {
+		found = node
+	}
+	for _, child := range node.Content {
+		if at := nodeAtLine(child, line); at != nil {
+			found = at
+		}
+	}
+	return found
+}
+
+// yamlKeys returns the yaml keys of the fields of the struct t, the values are unused.
+func yamlKeys(t reflect.Type) map[string]string {
+	keys := make(map[string]string, t.NumField())
+	for i := range t.NumField() {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ","); name != "" {
+			keys[name] = ""
+		}
+	}
+	return keys
+}
+
+// listTags returns the validation of the entries of each list of names in t by yaml key.
+func listTags(t reflect.Type) map[string]string {
+	tags := make(map[string]string, t.NumField())
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name != "" && f.Type.Kind() == reflect.Slice {
+			tags[name] = strings.TrimPrefix(f.Tag.Get("validate"), "dive,")
+		}
+	}
+	return tags
+}
+
+// suggest returns a hint with the known key closest to key, e.g. hosts for host, or an empty string.
+func suggest(key string, known map[string]string) string {
+	best, bestDistance := "", 3
+	for candidate := range known {
+		if d := distance(key, candidate); d < bestDistance || (d == bestDistance && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+	if best == "" || bestDistance > 2 {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %s?", best)
+}
+
+// distance returns the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
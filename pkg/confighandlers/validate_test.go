@@ -0,0 +1,99 @@
+package confighandlers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateFile(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want []Diagnostic
+	}{
+		{name: "valid configuration", file: "test_data/complete_config_sample.yaml"},
+		{
+			name: "every problem at once",
+			file: "test_data/invalid_config_sample.yaml",
+			want: []Diagnostic{
+				{Line: 3, Column: 3, Severity: SeverityError, Path: "query_type.host", Message: "unknown key host, did you mean hosts?"},
+				{Line: 7, Column: 7, Severity: SeverityWarning, Path: "query_type.hosts[1]", Message: `duplicate name "Google.com." in section hosts, first listed on line 6`},
+				{Line: 8, Column: 7, Severity: SeverityError, Path: "query_type.hosts[2]", Message: `"not a domain" is not a valid fully qualified domain name`},
+				{Line: 9, Column: 3, Severity: SeverityWarning, Path: "query_type.mx", Message: "section mx is empty"},
+				{Line: 12, Column: 7, Severity: SeverityError, Path: "query_type.ptr[1]", Message: `"300.1.1.1" is not a valid IP address`},
+				{Line: 13, Column: 8, Severity: SeverityError, Path: "query_type.txt", Message: "section txt must be a list of names"},
+				{Line: 14, Column: 3, Severity: SeverityError, Path: "query_type.hosts", Message: "duplicate key hosts, first defined on line 5"},
+				{Line: 18, Column: 12, Severity: SeverityError, Message: "cannot unmarshal !!str `soon` into time.Duration"},
+				{Line: 20, Column: 8, Severity: SeverityError, Path: "rate_limit.qps", Message: "qps must be at least 0, got -1"},
+				{Line: 23, Column: 12, Severity: SeverityError, Path: "rate_limit.servers.10.0.0.53.qps", Message: "qps must be greater than 0, got 0"},
+				{Line: 24, Column: 7, Severity: SeverityError, Path: "rate_limit.servers.10.0.0.53.brust", Message: "unknown key brust, did you mean burst?"},
+			},
+		},
+		{
+			name: "settings",
+			file: "test_data/invalid_settings_config_sample.yaml",
+			want: []Diagnostic{
+				{Line: 7, Column: 9, Severity: SeverityError, Path: "settings.port", Message: "port must be at most 65535, got 70000"},
+				{Line: 8, Column: 12, Severity: SeverityError, Path: "settings.workers", Message: "workers must be a number or auto, got many"},
+				{Line: 9, Column: 11, Severity: SeverityError, Path: "settings.output", Message: "output must be one of text, json, ndjson, got xml"},
+				{Line: 11, Column: 3, Severity: SeverityError, Path: "settings.max_dept", Message: "unknown key max_dept, did you mean max_depth?"},
+			},
+		},
+		{
+			name: "srv names",
+			file: "test_data/invalid_srv_config_sample.yaml",
+			want: []Diagnostic{
+				{Line: 6, Column: 7, Severity: SeverityError, Path: "query_type.srv[2]", Message: `"sip.tcp.foo.bar" is not a valid SRV name, expected _service._proto.hostname`},
+				{Line: 7, Column: 7, Severity: SeverityError, Path: "query_type.srv[3]", Message: `"_sip._tcp" is not a valid SRV name, expected _service._proto.hostname`},
+				{Line: 8, Column: 7, Severity: SeverityError, Path: "query_type.srv[4]", Message: `"_sip._tcp..foo.bar" is not a valid SRV name, expected _service._proto.hostname`},
+				{Line: 9, Column: 7, Severity: SeverityError, Path: "query_type.srv[5]", Message: `"_a-very-long-service-name._tcp.foo.bar" is not a valid SRV name, expected _service._proto.hostname`},
+			},
+		},
+		{
+			name: "empty file",
+			file: "test_data/bad_configuration_sample.yaml",
+			want: []Diagnostic{{Line: 1, Column: 1, Severity: SeverityError, Message: "empty configuration"}},
+		},
+		{
+			name: "no names",
+			file: "test_data/missing_config_keys.yaml",
+			want: []Diagnostic{{Line: 2, Column: 1, Severity: SeverityError, Path: "query_type", Message: "empty configuration, query_type has no names"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateFile(tt.file)
+			if err != nil {
+				t.Fatalf("ValidateFile() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ValidateFile() = %d diagnostics, want %d:\n%v", len(got), len(tt.want), got)
+			}
+			for i := range got {
+				tt.want[i].File = tt.file
+				if got[i] != tt.want[i] {
+					t.Errorf("diagnostic %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSyntaxError(t *testing.T) {
+	got := Validate("config.yaml", []byte("query_type:\n  hosts:\n  - foo.bar\n bad: [\n"))
+	if len(got) != 1 || got[0].Line == 1 || got[0].Severity != SeverityError {
+		t.Errorf("expected a syntax error with its line, got %v", got)
+	}
+}
+
+func TestLoadConfigFromFileValidationError(t *testing.T) {
+	_, err := LoadConfigFromFile(ptr("test_data/invalid_config_sample.yaml"))
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("LoadConfigFromFile() error = %v, want a ValidationError", err)
+	}
+	// the warnings do not stop the configuration from loading.
+	if len(validationErr.Diagnostics) != 9 {
+		t.Errorf("expected the 9 errors, got %v", validationErr.Diagnostics)
+	}
+}
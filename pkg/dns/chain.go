@@ -0,0 +1,44 @@
+package dns
+
+import "strings"
+
+// CNAMEChain returns the CNAME records of records that lead from name to its canonical name, one
+// record per hop in order, and the records of the canonical name. The chain stops at a name that
+// is already in it so a CNAME loop returns each hop once.
+func CNAMEChain(name string, records []Record) (chain, terminal []Record) {
+	current := canonical(name)
+	visited := map[string]bool{current: true}
+	for {
+		i := cnameIndex(records, current)
+		if i < 0 {
+			break
+		}
+		chain = append(chain, records[i])
+		current = canonical(records[i].Target)
+		if visited[current] {
+			return chain, nil
+		}
+		visited[current] = true
+	}
+	for _, rr := range records {
+		if rr.Type != TypeCNAME && canonical(rr.Name) == current {
+			terminal = append(terminal, rr)
+		}
+	}
+	return chain, terminal
+}
+
+// cnameIndex returns the index of the CNAME record of name in records, or -1.
+func cnameIndex(records []Record, name string) int {
+	for i, rr := range records {
+		if rr.Type == TypeCNAME && canonical(rr.Name) == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// canonical returns name in lower case without the trailing dot.
+func canonical(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,33 @@
+package dns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCNAMEChain(t *testing.T) {
+	www := Record{Name: "www.foo.bar.", Type: TypeCNAME, TTL: 300, Target: "edge.foo.bar.", Data: "edge.foo.bar."}
+	edge := Record{Name: "edge.foo.bar.", Type: TypeCNAME, TTL: 60, Target: "cdn.foo.bar.", Data: "cdn.foo.bar."}
+	a := Record{Name: "cdn.foo.bar.", Type: TypeA, TTL: 20, Data: googlePubDNS1}
+	loop := Record{Name: "cdn.foo.bar.", Type: TypeCNAME, TTL: 60, Target: "WWW.foo.bar", Data: "WWW.foo.bar"}
+	tests := []struct {
+		name         string
+		host         string
+		records      []Record
+		wantChain    []Record
+		wantTerminal []Record
+	}{
+		{name: "chain out of order", host: "www.foo.bar", records: []Record{a, edge, www}, wantChain: []Record{www, edge}, wantTerminal: []Record{a}},
+		{name: "partial chain", host: "www.foo.bar", records: []Record{www}, wantChain: []Record{www}},
+		{name: "no cname", host: "cdn.foo.bar.", records: []Record{a}, wantTerminal: []Record{a}},
+		{name: "loop", host: "www.foo.bar", records: []Record{www, edge, loop}, wantChain: []Record{www, edge, loop}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, terminal := CNAMEChain(tt.host, tt.records)
+			if !reflect.DeepEqual(chain, tt.wantChain) || !reflect.DeepEqual(terminal, tt.wantTerminal) {
+				t.Errorf("CNAMEChain() = %v, %v, want %v, %v", chain, terminal, tt.wantChain, tt.wantTerminal)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// ednsUDPSize is the UDP payload size advertised with EDNS0, the size recommended by DNS flag day 2020.
+	ednsUDPSize uint16 = 1232
+	// maxMessageSize is the largest DNS message over TCP.
+	maxMessageSize int = 65535
+)
+
+// Type is a DNS record type.
+type Type uint16
+
+// record types supported by LookupRecords.
+const (
+	TypeA     Type = Type(dnsmessage.TypeA)
+	TypeNS    Type = Type(dnsmessage.TypeNS)
+	TypeCNAME Type = Type(dnsmessage.TypeCNAME)
+	TypePTR   Type = Type(dnsmessage.TypePTR)
+	TypeMX    Type = Type(dnsmessage.TypeMX)
+	TypeTXT   Type = Type(dnsmessage.TypeTXT)
+	TypeAAAA  Type = Type(dnsmessage.TypeAAAA)
+	TypeSRV   Type = Type(dnsmessage.TypeSRV)
+	TypeHTTPS Type = Type(dnsmessage.TypeHTTPS)
+)
+
+var typeNames = map[Type]string{
+	TypeA:     "A",
+	TypeNS:    "NS",
+	TypeCNAME: "CNAME",
+	TypePTR:   "PTR",
+	TypeMX:    "MX",
+	TypeTXT:   "TXT",
+	TypeAAAA:  "AAAA",
+	TypeSRV:   "SRV",
+	TypeHTTPS: "HTTPS",
+}
+
+func (t Type) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", uint16(t))
+}
+
+// Record is a resource record from the answer section of a response.
+//
+//nolint:govet // fieldalignment is not required here.
+type Record struct {
+	// Name is the owner of the record as a fully qualified name.
+	Name string
+	Type Type
+	TTL  uint32
+	// Target is the name the record points to for CNAME, NS, PTR, MX, SRV and HTTPS records, the
+	// HTTPS target "." is the owner name itself.
+	Target string
+	// Data is the presentation format of the record data.
+	Data string
+}
+
+// Client sends single queries to a nameserver, unlike net.Resolver it returns every record of the
+// answer with its TTL and does not follow CNAME records itself.
+type Client struct {
+	nameserver string
+	transport  Transport
+	timeout    time.Duration
+}
+
+// NewClient returns a Client for the nameserver at host:port, timeout bounds the connection.
+func NewClient(nameserver string, timeout time.Duration) *Client {
+	return NewTransportClient(nameserver, TransportUDP, timeout)
+}
+
+// NewTransportClient returns a Client that sends its queries to the nameserver over transport, UDP
+// when transport is empty.
+func NewTransportClient(nameserver string, transport Transport, timeout time.Duration) *Client {
+	if transport == "" {
+		transport = TransportUDP
+	}
+	return &Client{nameserver: nameserver, transport: transport, timeout: timeout}
+}
+
+// Query sends a recursive query for name and qtype over the transport of the client, over UDP it is
+// repeated over TCP when the response is truncated. Error responses and answers without records are returned as a ResponseError.
+func (c *Client) Query(ctx context.Context, name string, qtype Type) ([]Record, error) {
+	fqdn := name
+	if !strings.HasSuffix(fqdn, ".") {
+		fqdn += "."
+	}
+	q, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return nil, fmt.Errorf("lookup %s on %s: %w", name, c.nameserver, err)
+	}
+	id := uint16(rand.Uint32()) //nolint:gosec // the query id does not need a secure source.
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: q, Type: dnsmessage.Type(qtype), Class: dnsmessage.ClassINET}},
+	}
+	opt := dnsmessage.Resource{}
+	if err = opt.Header.SetEDNS0(int(ednsUDPSize), dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	opt.Body = &dnsmessage.OPTResource{}
+	msg.Additionals = append(msg.Additionals, opt)
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("lookup %s on %s: %w", name, c.nameserver, err)
+	}
+
+	network := "tcp"
+	if c.transport == TransportUDP {
+		network = "udp"
+	}
+	resp, err := c.exchange(ctx, network, packed, id)
+	if err == nil && resp.Truncated && network == "udp" {
+		resp, err = c.exchange(ctx, "tcp", packed, id)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &net.DNSError{Err: err.Error(), Name: name, Server: c.nameserver, IsTimeout: isTimeout(err)}
+	}
+	return c.records(name, resp)
+}
+
+// exchange sends a packed query over network and returns the response with the matching id.
+func (c *Client) exchange(ctx context.Context, network string, query []byte, id uint16) (*dnsmessage.Message, error) {
+	conn, err := dial(ctx, network, c.nameserver, c.transport, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	// unblock the reads and writes once ctx is cancelled.
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if network == "tcp" {
+		return exchangeTCP(conn, query, id)
+	}
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		resp := &dnsmessage.Message{}
+		if err = resp.Unpack(buf[:n]); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal DNS message: %w", err)
+		}
+		// responses to other queries are ignored, the answer to ours may still arrive.
+		if resp.ID == id && resp.Response {
+			return resp, nil
+		}
+	}
+}
+
+// exchangeTCP sends a query over a stream connection with the two byte length prefix.
+func exchangeTCP(conn net.Conn, query []byte, id uint16) (*dnsmessage.Message, error) {
+	framed := binary.BigEndian.AppendUint16(make([]byte, 0, len(query)+2), uint16(len(query))) //nolint:gosec // the query is smaller than a message.
+	if _, err := conn.Write(append(framed, query...)); err != nil {
+		return nil, err
+	}
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	resp := &dnsmessage.Message{}
+	if err := resp.Unpack(buf); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal DNS message: %w", err)
+	}
+	if resp.ID != id {
+		return nil, fmt.Errorf("invalid DNS response: id %d does not match the query", resp.ID)
+	}
+	return resp, nil
+}
+
+// records converts the answer section of resp, the CNAME records of the chain to the answer are included.
+func (c *Client) records(name string, resp *dnsmessage.Message) ([]Record, error) {
+	respErr := &ResponseError{Name: name, Server: c.nameserver, Rcode: uint16(resp.RCode), Truncated: resp.Truncated}
+	if resp.RCode != dnsmessage.RCodeSuccess || resp.Truncated {
+		return nil, respErr
+	}
+	records := make([]Record, 0, len(resp.Answers))
+	for _, rr := range resp.Answers {
+		r := Record{Name: rr.Header.Name.String(), Type: Type(rr.Header.Type), TTL: rr.Header.TTL}
+		switch b := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			r.Data = netip.AddrFrom4(b.A).String()
+		case *dnsmessage.AAAAResource:
+			r.Data = netip.AddrFrom16(b.AAAA).String()
+		case *dnsmessage.CNAMEResource:
+			r.Target, r.Data = b.CNAME.String(), b.CNAME.String()
+		case *dnsmessage.NSResource:
+			r.Target, r.Data = b.NS.String(), b.NS.String()
+		case *dnsmessage.PTRResource:
+			r.Target, r.Data = b.PTR.String(), b.PTR.String()
+		case *dnsmessage.MXResource:
+			r.Target, r.Data = b.MX.String(), fmt.Sprintf("%d %s", b.Pref, b.MX)
+		case *dnsmessage.SRVResource:
+			r.Target, r.Data = b.Target.String(), fmt.Sprintf("%d %d %d %s", b.Priority, b.Weight, b.Port, b.Target)
+		case *dnsmessage.HTTPSResource:
+			r.Target, r.Data = b.Target.String(), fmt.Sprintf("%d %s", b.Priority, b.Target)
+		case *dnsmessage.TXTResource:
+			r.Data = strings.Join(b.TXT, "")
+		default:
+			continue
+		}
+		records = append(records, r)
+	}
+	if len(records) == 0 {
+		respErr.NoData = true
+		return nil, respErr
+	}
+	return records, nil
+}
+
+// isTimeout returns true when err is a network timeout.
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
@@ -0,0 +1,178 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testServer answers queries over UDP and TCP on the same port, answer builds the response for
+// each question and tcp reports whether it arrived over TCP.
+type testServer struct {
+	udp    net.PacketConn
+	tcp    net.Listener
+	answer func(q dnsmessage.Question, tcp bool) dnsmessage.Message
+}
+
+func newTestServer(t *testing.T, answer func(q dnsmessage.Question, tcp bool) dnsmessage.Message) *testServer {
+	t.Helper()
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		t.Skipf("the tcp port of %s is not free: %v", udp.LocalAddr(), err)
+	}
+	s := &testServer{udp: udp, tcp: tcp, answer: answer}
+	t.Cleanup(func() {
+		udp.Close()
+		tcp.Close()
+	})
+	go s.serveUDP()
+	go s.serveTCP()
+	return s
+}
+
+func (s *testServer) addr() string {
+	return s.udp.LocalAddr().String()
+}
+
+// respond builds the packed response to a packed query.
+func (s *testServer) respond(query []byte, tcp bool) []byte {
+	msg := dnsmessage.Message{}
+	if err := msg.Unpack(query); err != nil {
+		return nil
+	}
+	resp := s.answer(msg.Questions[0], tcp)
+	resp.ID = msg.ID
+	resp.Response = true
+	resp.Questions = msg.Questions
+	packed, err := resp.Pack()
+	if err != nil {
+		return nil
+	}
+	return packed
+}
+
+func (s *testServer) serveUDP() {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := s.respond(buf[:n], false); resp != nil {
+			_, _ = s.udp.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *testServer) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+		var size [2]byte
+		if _, err = io.ReadFull(conn, size[:]); err == nil {
+			query := make([]byte, binary.BigEndian.Uint16(size[:]))
+			if _, err = io.ReadFull(conn, query); err == nil {
+				resp := s.respond(query, true)
+				_, _ = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...))
+			}
+		}
+		conn.Close()
+	}
+}
+
+func rrHeader(name string, qtype dnsmessage.Type, ttl uint32) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET, TTL: ttl}
+}
+
+func TestClientQuery(t *testing.T) {
+	srv := newTestServer(t, func(q dnsmessage.Question, tcp bool) dnsmessage.Message {
+		switch q.Name.String() {
+		case "www.foo.bar.":
+			return dnsmessage.Message{Header: dnsmessage.Header{RecursionAvailable: true}, Answers: []dnsmessage.Resource{
+				{Header: rrHeader("www.foo.bar.", dnsmessage.TypeCNAME, 300), Body: &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("foo.bar.")}},
+				{Header: rrHeader("foo.bar.", dnsmessage.TypeA, 60), Body: &dnsmessage.AResource{A: [4]byte{8, 8, 4, 4}}},
+			}}
+		case "big.foo.bar.":
+			if !tcp {
+				return dnsmessage.Message{Header: dnsmessage.Header{Truncated: true}}
+			}
+			return dnsmessage.Message{Answers: []dnsmessage.Resource{
+				{Header: rrHeader("big.foo.bar.", dnsmessage.TypeMX, 30), Body: &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mx0.foo.bar.")}},
+			}}
+		case "empty.foo.bar.":
+			return dnsmessage.Message{}
+		case "fail.foo.bar.":
+			return dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeServerFailure}}
+		}
+		return dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeNameError}}
+	})
+	tests := []struct {
+		name      string
+		qtype     Type
+		want      []Record
+		wantClass Class
+	}{
+		{name: "www.foo.bar", qtype: TypeA, want: []Record{
+			{Name: "www.foo.bar.", Type: TypeCNAME, TTL: 300, Target: "foo.bar.", Data: "foo.bar."},
+			{Name: "foo.bar.", Type: TypeA, TTL: 60, Data: googlePubDNS1},
+		}},
+		{name: "big.foo.bar", qtype: TypeMX, want: []Record{
+			{Name: "big.foo.bar.", Type: TypeMX, TTL: 30, Target: "mx0.foo.bar.", Data: "10 mx0.foo.bar."},
+		}},
+		{name: "empty.foo.bar", qtype: TypeCNAME, wantClass: ClassNoData},
+		{name: "fail.foo.bar", qtype: TypeA, wantClass: ClassServFail},
+		{name: testDomainWithErr, qtype: TypeA, wantClass: ClassNXDomain},
+	}
+	c := NewClient(srv.addr(), time.Second)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			got, err := c.Query(ctx, tt.name, tt.qtype)
+			if class := Classify(err); class != tt.wantClass {
+				t.Fatalf("Query() error = %v, want class %q", err, tt.wantClass)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Query() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Query() record %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestClientQueryCancelled(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	defer udp.Close()
+	// the server never answers so only the cancellation ends the query.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = NewClient(udp.LocalAddr().String(), time.Second).Query(ctx, testDomainNoErr, TypeA)
+	if err != context.Canceled {
+		t.Errorf("Query() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestTypeString(t *testing.T) {
+	if TypeHTTPS.String() != "HTTPS" || Type(99).String() != "TYPE99" {
+		t.Errorf("unexpected type names %s and %s", TypeHTTPS, Type(99))
+	}
+}
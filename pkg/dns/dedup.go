@@ -0,0 +1,154 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DedupResolver is a CustomResolver decorator that shares the answer of a lookup with every other
+// lookup of the same name and record type, the lookups that arrive while it is in flight wait for it
+// and the later ones are answered from a memo that is kept until Reset. Names are compared case
+// insensitively and without the trailing dot.
+//
+//nolint:govet // fieldalignment is not required here.
+type DedupResolver struct {
+	next    CustomResolver
+	mu      sync.Mutex
+	calls   map[string]*call
+	avoided atomic.Int64
+}
+
+// call is a lookup in flight or its memoized answer once done is closed.
+type call struct {
+	done  chan struct{}
+	value any
+	err   error
+	// dropped is set when the lookup was cancelled, its answer is not shared.
+	dropped bool
+}
+
+type scopeKey struct{}
+
+// WithScope returns a context whose lookups are only shared with the lookups of the same scope, such
+// as the lookups sent to the same server.
+func WithScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+// NewDedupResolver wraps next with the deduplication and an empty memo.
+func NewDedupResolver(next CustomResolver) *DedupResolver {
+	return &DedupResolver{next: next, calls: make(map[string]*call)}
+}
+
+// Avoided returns the number of lookups that were answered without a query of their own.
+func (r *DedupResolver) Avoided() int64 {
+	return r.avoided.Load()
+}
+
+// Reset forgets the memoized answers, the lookups in flight are still shared.
+func (r *DedupResolver) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, c := range r.calls {
+		select {
+		case <-c.done:
+			delete(r.calls, key)
+		default:
+		}
+	}
+}
+
+// LookupCNAME shares the LookupCNAME of the wrapped resolver.
+func (r *DedupResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	return dedup(ctx, r, "CNAME", host, func(ctx context.Context) (string, error) {
+		return r.next.LookupCNAME(ctx, host)
+	})
+}
+
+// LookupIPAddr shares the LookupIPAddr of the wrapped resolver.
+func (r *DedupResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return dedup(ctx, r, "IP", host, func(ctx context.Context) ([]net.IPAddr, error) {
+		return r.next.LookupIPAddr(ctx, host)
+	})
+}
+
+// LookupAddr shares the LookupAddr of the wrapped resolver.
+func (r *DedupResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return dedup(ctx, r, "PTR", addr, func(ctx context.Context) ([]string, error) {
+		return r.next.LookupAddr(ctx, addr)
+	})
+}
+
+// LookupNS shares the LookupNS of the wrapped resolver.
+func (r *DedupResolver) LookupNS(ctx context.Context, host string) ([]*net.NS, error) {
+	return dedup(ctx, r, "NS", host, func(ctx context.Context) ([]*net.NS, error) {
+		return r.next.LookupNS(ctx, host)
+	})
+}
+
+// LookupTXT shares the LookupTXT of the wrapped resolver.
+func (r *DedupResolver) LookupTXT(ctx context.Context, host string) ([]string, error) {
+	return dedup(ctx, r, "TXT", host, func(ctx context.Context) ([]string, error) {
+		return r.next.LookupTXT(ctx, host)
+	})
+}
+
+// LookupMX shares the LookupMX of the wrapped resolver.
+func (r *DedupResolver) LookupMX(ctx context.Context, host string) ([]*net.MX, error) {
+	return dedup(ctx, r, "MX", host, func(ctx context.Context) ([]*net.MX, error) {
+		return r.next.LookupMX(ctx, host)
+	})
+}
+
+// LookupRecords shares the LookupRecords of the wrapped resolver.
+func (r *DedupResolver) LookupRecords(ctx context.Context, name string, qtype Type) ([]Record, error) {
+	return dedup(ctx, r, "RECORDS "+qtype.String(), name, func(ctx context.Context) ([]Record, error) {
+		return r.next.LookupRecords(ctx, name, qtype)
+	})
+}
+
+// dedup returns the answer of the lookup of host for qtype that is in flight or memoized, or runs
+// lookup and shares its answer. The answer of a lookup that was cancelled is not shared, the
+// lookups waiting for it run their own.
+func dedup[T any](ctx context.Context, r *DedupResolver, qtype, host string, lookup func(ctx context.Context) (T, error)) (T, error) {
+	scope, _ := ctx.Value(scopeKey{}).(string)
+	key := scope + " " + qtype + " " + strings.ToLower(strings.TrimSuffix(host, "."))
+	for {
+		r.mu.Lock()
+		c, ok := r.calls[key]
+		if !ok {
+			c = &call{done: make(chan struct{})}
+			r.calls[key] = c
+			r.mu.Unlock()
+			return run(ctx, r, key, c, lookup)
+		}
+		r.mu.Unlock()
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+		if !c.dropped {
+			r.avoided.Add(1)
+			return c.value.(T), c.err
+		}
+	}
+}
+
+// run makes the lookup for the call c of key.
+func run[T any](ctx context.Context, r *DedupResolver, key string, c *call, lookup func(ctx context.Context) (T, error)) (T, error) {
+	value, err := lookup(ctx)
+	c.value, c.err = value, err
+	if ctx.Err() != nil {
+		c.dropped = true
+		r.mu.Lock()
+		delete(r.calls, key)
+		r.mu.Unlock()
+	}
+	close(c.done)
+	return value, err
+}
@@ -0,0 +1,100 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// countingResolver counts the address lookups that reach the Mockresolver, they wait for release
+// when it is not nil.
+type countingResolver struct {
+	*Mockresolver
+	lookups atomic.Int32
+	release chan struct{}
+}
+
+func (c *countingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	c.lookups.Add(1)
+	if c.release != nil {
+		select {
+		case <-c.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return c.Mockresolver.LookupIPAddr(ctx, host)
+}
+
+func TestDedupResolverMemo(t *testing.T) {
+	next := &countingResolver{Mockresolver: NewMockResolver()}
+	r := NewDedupResolver(next)
+	for _, host := range []string{testDomainNoErr, "FOO.bar.", testDomainNoErr, testDomainWithErr, testDomainWithErr} {
+		_, err := r.LookupIPAddr(context.Background(), host)
+		if (err != nil) != (host == testDomainWithErr) {
+			t.Errorf("LookupIPAddr(%s) error = %v", host, err)
+		}
+	}
+	if next.lookups.Load() != 2 || r.Avoided() != 3 {
+		t.Errorf("expected 2 queries and 3 avoided, got %d and %d", next.lookups.Load(), r.Avoided())
+	}
+	// other record types of the same name are not shared.
+	if _, err := r.LookupMX(context.Background(), testDomainNoErr); err != nil {
+		t.Fatalf("LookupMX() error = %v", err)
+	}
+	// nor the lookups of another scope.
+	if _, err := r.LookupIPAddr(WithScope(context.Background(), "192.0.2.1:53"), testDomainNoErr); err != nil {
+		t.Fatalf("LookupIPAddr() of another scope error = %v", err)
+	}
+	r.Reset()
+	if _, err := r.LookupIPAddr(context.Background(), testDomainNoErr); err != nil {
+		t.Fatalf("LookupIPAddr() after Reset error = %v", err)
+	}
+	if next.lookups.Load() != 4 || r.Avoided() != 3 {
+		t.Errorf("expected Reset to forget the memo, got %d queries and %d avoided", next.lookups.Load(), r.Avoided())
+	}
+}
+
+func TestDedupResolverInFlight(t *testing.T) {
+	next := &countingResolver{Mockresolver: NewMockResolver(), release: make(chan struct{})}
+	r := NewDedupResolver(next)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.LookupIPAddr(context.Background(), testDomainNoErr); err != nil {
+				t.Errorf("LookupIPAddr() error = %v", err)
+			}
+		}()
+	}
+	for next.lookups.Load() == 0 {
+		runtime.Gosched()
+	}
+	close(next.release)
+	wg.Wait()
+	if next.lookups.Load() != 1 || r.Avoided() != 3 {
+		t.Errorf("expected 1 query and 3 avoided, got %d and %d", next.lookups.Load(), r.Avoided())
+	}
+}
+
+func TestDedupResolverCancelled(t *testing.T) {
+	next := &countingResolver{Mockresolver: NewMockResolver(), release: make(chan struct{})}
+	r := NewDedupResolver(next)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.LookupIPAddr(ctx, testDomainNoErr); err == nil {
+		t.Fatalf("LookupIPAddr() with a cancelled context expected an error")
+	}
+	close(next.release)
+	// the cancelled answer is not memoized.
+	if _, err := r.LookupIPAddr(context.Background(), testDomainNoErr); err != nil {
+		t.Errorf("LookupIPAddr() after a cancelled lookup error = %v", err)
+	}
+	if next.lookups.Load() != 2 || r.Avoided() != 0 {
+		t.Errorf("expected 2 queries and none avoided, got %d and %d", next.lookups.Load(), r.Avoided())
+	}
+}
@@ -0,0 +1,168 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Class is the type of failure of a DNS lookup.
+type Class string
+
+const (
+	// ClassNone is the class of a successful lookup.
+	ClassNone Class = ""
+	// ClassNXDomain the name does not exist.
+	ClassNXDomain Class = "nxdomain"
+	// ClassNoData the name exists but has no records of the requested type.
+	ClassNoData Class = "nodata"
+	// ClassServFail the server failed to complete the lookup.
+	ClassServFail Class = "servfail"
+	// ClassRefused the server refused the lookup or answered with another error rcode.
+	ClassRefused Class = "refused"
+	// ClassTimeout the lookup did not complete before the deadline.
+	ClassTimeout Class = "timeout"
+	// ClassUnreachable the server could not be reached.
+	ClassUnreachable Class = "unreachable"
+	// ClassTruncated the response was truncated and could not be retried over TCP.
+	ClassTruncated Class = "truncated"
+	// ClassMalformed the response could not be parsed.
+	ClassMalformed Class = "malformed"
+	// ClassUnknown any other failure.
+	ClassUnknown Class = "unknown"
+)
+
+const (
+	// DNS response codes from RFC 1035 section 4.1.1.
+	RcodeSuccess        uint16 = 0
+	RcodeFormatError    uint16 = 1
+	RcodeServerFailure  uint16 = 2
+	RcodeNameError      uint16 = 3
+	RcodeNotImplemented uint16 = 4
+	RcodeRefused        uint16 = 5
+	// error strings used by the net package, it does not export typed errors for these.
+	errServerMisbehaving = "server misbehaving"
+)
+
+var (
+	// Classes lists every failure class in order of severity, the most severe first.
+	Classes = []Class{ClassUnreachable, ClassTimeout, ClassServFail, ClassRefused, ClassTruncated, ClassMalformed, ClassNoData, ClassNXDomain, ClassUnknown}
+	// malformedMessages are the net package errors for responses that could not be parsed.
+	malformedMessages = []string{"cannot unmarshal DNS message", "invalid DNS response", "lame referral", "no answer from DNS server"}
+	// unreachableMessages are the socket errors returned when the server can not be reached.
+	unreachableMessages = []string{"network is unreachable", "no route to host", "connection refused", "host is down"}
+)
+
+// Retryable returns true when the same lookup could succeed if it was sent again.
+func (c Class) Retryable() bool {
+	switch c {
+	case ClassTimeout, ClassServFail, ClassUnreachable, ClassTruncated:
+		return true
+	}
+	return false
+}
+
+// ParseClass returns the Class for its name.
+func ParseClass(s string) (Class, error) {
+	for _, c := range Classes {
+		if string(c) == strings.ToLower(s) {
+			return c, nil
+		}
+	}
+	return ClassNone, fmt.Errorf("unknown error class %s", s)
+}
+
+// ResponseError is returned when a server answers with an error, it carries the response code so
+// the failure can be classified more precisely than the net package allows.
+type ResponseError struct {
+	Name      string
+	Server    string
+	Rcode     uint16
+	NoData    bool
+	Truncated bool
+}
+
+func (e *ResponseError) Error() string {
+	switch {
+	case e.Truncated:
+		return fmt.Sprintf("lookup %s on %s: truncated response", e.Name, e.Server)
+	case e.NoData:
+		return fmt.Sprintf("lookup %s on %s: no records of the requested type", e.Name, e.Server)
+	}
+	return fmt.Sprintf("lookup %s on %s: rcode %d", e.Name, e.Server, e.Rcode)
+}
+
+// Class returns the failure class for the response code.
+func (e *ResponseError) Class() Class {
+	switch {
+	case e.Truncated:
+		return ClassTruncated
+	case e.NoData:
+		return ClassNoData
+	}
+	switch e.Rcode {
+	case RcodeNameError:
+		return ClassNXDomain
+	case RcodeServerFailure:
+		return ClassServFail
+	case RcodeFormatError:
+		return ClassMalformed
+	}
+	return ClassRefused
+}
+
+// Classify returns the failure class of a lookup error.
+//
+// The net package reports NXDOMAIN and NODATA identically, errors from it with IsNotFound set are
+// classified as ClassNXDomain. ResponseError is used wherever the response code is available.
+func Classify(err error) Class {
+	if err == nil {
+		return ClassNone
+	}
+	var respErr *ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.Class()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ClassTimeout
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		switch {
+		case dnsErr.IsNotFound:
+			return ClassNXDomain
+		case dnsErr.IsTimeout:
+			return ClassTimeout
+		case dnsErr.Err == errServerMisbehaving && dnsErr.IsTemporary:
+			return ClassServFail
+		case dnsErr.Err == errServerMisbehaving:
+			return ClassRefused
+		}
+		return classifyMessage(dnsErr.Err)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ClassTimeout
+	}
+	return classifyMessage(err.Error())
+}
+
+// classifyMessage classifies the errors that are only available as a string.
+func classifyMessage(msg string) Class {
+	for _, m := range unreachableMessages {
+		if strings.Contains(msg, m) {
+			return ClassUnreachable
+		}
+	}
+	for _, m := range malformedMessages {
+		if strings.Contains(msg, m) {
+			return ClassMalformed
+		}
+	}
+	if strings.Contains(msg, "i/o timeout") {
+		return ClassTimeout
+	}
+	return ClassUnknown
+}
@@ -0,0 +1,105 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Class
+	}{
+		{
+			name: "no error",
+			err:  nil,
+			want: ClassNone,
+		},
+		{
+			name: "nxdomain from the net package",
+			err:  &net.DNSError{Err: "no such host", Name: testDomainWithErr, IsNotFound: true},
+			want: ClassNXDomain,
+		},
+		{
+			name: "servfail from the net package",
+			err:  &net.DNSError{Err: errServerMisbehaving, Name: testDomainNoErr, IsTemporary: true},
+			want: ClassServFail,
+		},
+		{
+			name: "refused from the net package",
+			err:  &net.DNSError{Err: errServerMisbehaving, Name: testDomainNoErr},
+			want: ClassRefused,
+		},
+		{
+			name: "timeout from the net package",
+			err:  &net.DNSError{Err: "i/o timeout", Name: testDomainNoErr, IsTimeout: true},
+			want: ClassTimeout,
+		},
+		{
+			name: "unreachable server",
+			err:  &net.DNSError{Err: "read udp 127.0.0.1:39170->127.0.0.1:53: read: connection refused", IsTemporary: true},
+			want: ClassUnreachable,
+		},
+		{
+			name: "malformed response",
+			err:  &net.DNSError{Err: "cannot unmarshal DNS message"},
+			want: ClassMalformed,
+		},
+		{
+			name: "wrapped context deadline",
+			err:  fmt.Errorf("lookup failed: %w", context.DeadlineExceeded),
+			want: ClassTimeout,
+		},
+		{
+			name: "nodata response",
+			err:  &ResponseError{Name: testDomainNoErr, NoData: true},
+			want: ClassNoData,
+		},
+		{
+			name: "truncated response",
+			err:  &ResponseError{Name: testDomainNoErr, Truncated: true},
+			want: ClassTruncated,
+		},
+		{
+			name: "refused rcode",
+			err:  fmt.Errorf("wrapped: %w", &ResponseError{Name: testDomainNoErr, Rcode: RcodeRefused}),
+			want: ClassRefused,
+		},
+		{
+			name: "unknown error",
+			err:  fmt.Errorf(nxDomainErr, testDomainWithErr),
+			want: ClassUnknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseClass(t *testing.T) {
+	for _, class := range Classes {
+		got, err := ParseClass(string(class))
+		if err != nil || got != class {
+			t.Errorf("ParseClass(%s) = %s, %v", class, got, err)
+		}
+	}
+	if _, err := ParseClass("bogus"); err == nil {
+		t.Errorf("ParseClass() expected an error for an unknown class")
+	}
+}
+
+func TestClassRetryable(t *testing.T) {
+	if !ClassTimeout.Retryable() || !ClassServFail.Retryable() {
+		t.Errorf("expected timeout and servfail to be retryable")
+	}
+	if ClassNXDomain.Retryable() || ClassRefused.Retryable() {
+		t.Errorf("expected nxdomain and refused not to be retryable")
+	}
+}
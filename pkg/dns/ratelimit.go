@@ -0,0 +1,216 @@
+package dns
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// TokenBucket limits the rate of queries, it allows up to burst queries at once and refills at qps
+// tokens per second. It is safe for concurrent use, a nil TokenBucket does not limit.
+//
+//nolint:govet // fieldalignment is not required here.
+type TokenBucket struct {
+	mu     sync.Mutex
+	qps    float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewTokenBucket returns a bucket for qps queries per second, it returns nil when qps is not
+// positive. When burst is not positive it defaults to qps rounded up.
+func NewTokenBucket(qps float64, burst int) *TokenBucket {
+	if qps <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(qps))
+	}
+	return &TokenBucket{
+		qps:    qps,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// Wait blocks until a token is available or ctx is done. Tokens are reserved in the order Wait is
+// called so a steady stream of callers can not starve one that is already waiting.
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	now := b.now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.qps)
+	b.last = now
+	b.tokens--
+	deficit := -b.tokens
+	b.mu.Unlock()
+	if deficit <= 0 {
+		return nil
+	}
+	if sleep(ctx, time.Duration(deficit/b.qps*float64(time.Second))) {
+		return nil
+	}
+	// give the reserved token back, the query is not going to be sent.
+	b.mu.Lock()
+	b.tokens++
+	b.mu.Unlock()
+	return ctx.Err()
+}
+
+type timeoutKey struct{}
+
+// timeout is the time a lookup may spend querying, it is shared by the attempts of the lookup.
+type timeout struct {
+	mu      sync.Mutex
+	left    time.Duration
+	elapsed time.Duration
+	queries int
+}
+
+// WithTimeout returns a context whose lookup may spend up to d querying over all of its attempts. The
+// RateLimitResolver starts the timeout once the tokens are taken, the time waiting for the rate
+// limits is not counted.
+func WithTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, &timeout{left: d})
+}
+
+// Elapsed returns the time the lookup of ctx spent querying after the tokens were taken, ok is
+// false without WithTimeout or when the lookup sent no query, such as a lookup answered by a
+// DedupResolver.
+func Elapsed(ctx context.Context) (elapsed time.Duration, ok bool) {
+	if t, found := ctx.Value(timeoutKey{}).(*timeout); found {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return t.elapsed, t.queries > 0
+	}
+	return 0, false
+}
+
+// expired returns true when the lookup of ctx has spent the time of WithTimeout.
+func expired(ctx context.Context) bool {
+	if t, ok := ctx.Value(timeoutKey{}).(*timeout); ok {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return t.left <= 0
+	}
+	return false
+}
+
+// RateLimitResolver is a CustomResolver decorator that waits for a token from every bucket before
+// each query, a bucket can be shared by several resolvers to limit them together. The timeout of
+// WithTimeout bounds the query once the tokens are taken.
+type RateLimitResolver struct {
+	next    CustomResolver
+	buckets []*TokenBucket
+}
+
+// NewRateLimitResolver wraps next with the buckets, nil buckets are ignored.
+func NewRateLimitResolver(next CustomResolver, buckets ...*TokenBucket) *RateLimitResolver {
+	r := &RateLimitResolver{next: next}
+	for _, b := range buckets {
+		if b != nil {
+			r.buckets = append(r.buckets, b)
+		}
+	}
+	return r
+}
+
+// wait takes n tokens from every bucket.
+func (r *RateLimitResolver) wait(ctx context.Context, n int) error {
+	for _, b := range r.buckets {
+		for range n {
+			if err := b.Wait(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// limit runs lookup once n tokens are taken from every bucket of r, bounded by the time that is
+// left of the timeout of ctx.
+func limit[T any](ctx context.Context, r *RateLimitResolver, n int, lookup func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+	if expired(ctx) {
+		return zero, context.DeadlineExceeded
+	}
+	if err := r.wait(ctx, n); err != nil {
+		return zero, err
+	}
+	t, ok := ctx.Value(timeoutKey{}).(*timeout)
+	if !ok {
+		return lookup(ctx)
+	}
+	t.mu.Lock()
+	left := t.left
+	t.mu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, left)
+	defer cancel()
+	start := time.Now()
+	result, err := lookup(ctx)
+	spent := time.Since(start)
+	t.mu.Lock()
+	t.left -= spent
+	t.elapsed += spent
+	t.queries++
+	t.mu.Unlock()
+	return result, err
+}
+
+// LookupCNAME waits for the rate limit before the LookupCNAME of the wrapped resolver.
+func (r *RateLimitResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	return limit(ctx, r, 1, func(ctx context.Context) (string, error) {
+		return r.next.LookupCNAME(ctx, host)
+	})
+}
+
+// LookupIPAddr waits for the rate limit before the LookupIPAddr of the wrapped resolver, it takes
+// a token for each of the A and AAAA queries it sends.
+func (r *RateLimitResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return limit(ctx, r, 2, func(ctx context.Context) ([]net.IPAddr, error) {
+		return r.next.LookupIPAddr(ctx, host)
+	})
+}
+
+// LookupAddr waits for the rate limit before the LookupAddr of the wrapped resolver.
+func (r *RateLimitResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return limit(ctx, r, 1, func(ctx context.Context) ([]string, error) {
+		return r.next.LookupAddr(ctx, addr)
+	})
+}
+
+// LookupNS waits for the rate limit before the LookupNS of the wrapped resolver.
+func (r *RateLimitResolver) LookupNS(ctx context.Context, host string) ([]*net.NS, error) {
+	return limit(ctx, r, 1, func(ctx context.Context) ([]*net.NS, error) {
+		return r.next.LookupNS(ctx, host)
+	})
+}
+
+// LookupTXT waits for the rate limit before the LookupTXT of the wrapped resolver.
+func (r *RateLimitResolver) LookupTXT(ctx context.Context, host string) ([]string, error) {
+	return limit(ctx, r, 1, func(ctx context.Context) ([]string, error) {
+		return r.next.LookupTXT(ctx, host)
+	})
+}
+
+// LookupMX waits for the rate limit before the LookupMX of the wrapped resolver.
+func (r *RateLimitResolver) LookupMX(ctx context.Context, host string) ([]*net.MX, error) {
+	return limit(ctx, r, 1, func(ctx context.Context) ([]*net.MX, error) {
+		return r.next.LookupMX(ctx, host)
+	})
+}
+
+// LookupRecords waits for the rate limit before the LookupRecords of the wrapped resolver.
+func (r *RateLimitResolver) LookupRecords(ctx context.Context, name string, qtype Type) ([]Record, error) {
+	return limit(ctx, r, 1, func(ctx context.Context) ([]Record, error) {
+		return r.next.LookupRecords(ctx, name, qtype)
+	})
+}
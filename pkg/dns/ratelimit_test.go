@@ -0,0 +1,108 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketWait(t *testing.T) {
+	now := time.Now()
+	b := NewTokenBucket(10, 2)
+	b.last = now
+	b.now = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		if err := b.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait() within the burst error = %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Wait(ctx); err == nil {
+		t.Fatalf("Wait() beyond the burst expected the context to expire first")
+	}
+	// the cancelled wait returns its token so a tenth of a second refills the next one.
+	now = now.Add(100 * time.Millisecond)
+	s := time.Now()
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() after the refill error = %v", err)
+	}
+	if time.Since(s) > 50*time.Millisecond {
+		t.Errorf("Wait() after the refill blocked for %s", time.Since(s))
+	}
+}
+
+func TestNewTokenBucket(t *testing.T) {
+	if b := NewTokenBucket(0, 5); b != nil {
+		t.Errorf("NewTokenBucket(0, 5) expected nil for an unlimited rate")
+	}
+	if err := (*TokenBucket)(nil).Wait(context.Background()); err != nil {
+		t.Errorf("nil TokenBucket Wait() error = %v", err)
+	}
+	if b := NewTokenBucket(2.5, 0); b.burst != 3 {
+		t.Errorf("NewTokenBucket(2.5, 0) burst = %v, want 3", b.burst)
+	}
+}
+
+func TestRateLimitResolverSharedBucket(t *testing.T) {
+	bucket := NewTokenBucket(100, 1)
+	first := NewRateLimitResolver(&Mockresolver{}, bucket, nil)
+	second := NewRateLimitResolver(&Mockresolver{}, bucket)
+	s := time.Now()
+	for i := 0; i < 2; i++ {
+		if _, err := first.LookupIPAddr(context.Background(), testDomainNoErr); err != nil {
+			t.Fatalf("LookupIPAddr() error = %v", err)
+		}
+		if _, err := second.LookupMX(context.Background(), testDomainNoErr); err != nil {
+			t.Fatalf("LookupMX() error = %v", err)
+		}
+	}
+	// the A and AAAA queries of LookupIPAddr take a token each, six queries with a burst of one
+	// wait for five tokens at 100 per second.
+	if elapsed := time.Since(s); elapsed < 45*time.Millisecond {
+		t.Errorf("six rate limited queries completed in %s, expected at least 50ms", elapsed)
+	}
+}
+
+// slowResolver answers the MX lookups after delay.
+type slowResolver struct {
+	Mockresolver
+	delay time.Duration
+}
+
+func (s *slowResolver) LookupMX(ctx context.Context, host string) ([]*net.MX, error) {
+	if !sleep(ctx, s.delay) {
+		return nil, ctx.Err()
+	}
+	return s.Mockresolver.LookupMX(ctx, host)
+}
+
+func TestRateLimitResolverTimeout(t *testing.T) {
+	bucket := NewTokenBucket(20, 1)
+	r := NewRateLimitResolver(&slowResolver{delay: 10 * time.Millisecond}, bucket)
+	if _, err := r.LookupMX(context.Background(), testDomainNoErr); err != nil {
+		t.Fatalf("LookupMX() error = %v", err)
+	}
+	// the wait of 50ms for the next token does not count against the timeout of 30ms.
+	ctx := WithTimeout(context.Background(), 30*time.Millisecond)
+	if _, err := r.LookupMX(ctx, testDomainNoErr); err != nil {
+		t.Fatalf("LookupMX() after the wait for a token error = %v", err)
+	}
+	if elapsed, ok := Elapsed(ctx); !ok || elapsed < 10*time.Millisecond || elapsed > 40*time.Millisecond {
+		t.Errorf("Elapsed() = %s, %v, expected the time of the query only", elapsed, ok)
+	}
+	if _, ok := Elapsed(WithTimeout(context.Background(), time.Second)); ok {
+		t.Errorf("Elapsed() of a lookup without a query expected ok to be false")
+	}
+	ctx = WithTimeout(context.Background(), 5*time.Millisecond)
+	if _, err := r.LookupMX(ctx, testDomainNoErr); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("LookupMX() slower than the timeout error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// the timeout is spent, another attempt of the lookup fails without a query.
+	s := time.Now()
+	if _, err := r.LookupMX(ctx, testDomainNoErr); !errors.Is(err, context.DeadlineExceeded) || time.Since(s) > 5*time.Millisecond {
+		t.Errorf("LookupMX() after the timeout = %v in %s, expected to fail at once", err, time.Since(s))
+	}
+}
@@ -14,24 +14,32 @@ type CustomResolver interface {
 	LookupNS(ctx context.Context, host string) ([]*net.NS, error)
 	LookupTXT(ctx context.Context, host string) ([]string, error)
 	LookupMX(ctx context.Context, host string) ([]*net.MX, error)
+	// LookupRecords queries a single record type without following CNAME records, the records
+	// of the answer are returned with their TTL.
+	LookupRecords(ctx context.Context, name string, qtype Type) ([]Record, error)
 }
 
 type Resolver struct {
 	client *net.Resolver
+	wire   *Client
 }
 
 // NewResolver creates a custom resolver where the DNS servers are pinned.
 func NewResolver(nameserver string, timeout time.Duration) *Resolver {
+	return NewTransportResolver(nameserver, TransportUDP, timeout)
+}
+
+// NewTransportResolver creates a custom resolver pinned to the nameserver that sends its queries
+// over transport.
+func NewTransportResolver(nameserver string, transport Transport, timeout time.Duration) *Resolver {
 	//nolint:revive // address is a returned function, it gets set by the caller.
 	return &Resolver{
+		wire: NewTransportClient(nameserver, transport, timeout),
 		client: &net.Resolver{
 			PreferGo:     true,
 			StrictErrors: true,
 			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				d := net.Dialer{
-					Timeout: timeout,
-				}
-				return d.DialContext(ctx, network, nameserver)
+				return dial(ctx, network, nameserver, transport, timeout)
 			},
 		},
 	}
@@ -66,3 +74,8 @@ func (r *Resolver) LookupTXT(ctx context.Context, host string) ([]string, error)
 func (r *Resolver) LookupNS(ctx context.Context, host string) ([]*net.NS, error) {
 	return r.client.LookupNS(ctx, host)
 }
+
+// LookupRecords returns the records of the answer from the Client for the nameserver.
+func (r *Resolver) LookupRecords(ctx context.Context, name string, qtype Type) ([]Record, error) {
+	return r.wire.Query(ctx, name, qtype)
+}
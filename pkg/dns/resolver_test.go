@@ -131,6 +131,17 @@ func (m *Mockresolver) LookupNS(ctx context.Context, host string) ([]*net.NS, er
 	return []*net.NS{}, fmt.Errorf(nxDomainErr, host)
 }
 
+func (m *Mockresolver) LookupRecords(ctx context.Context, name string, qtype Type) ([]Record, error) {
+	defer ctx.Done()
+	switch {
+	case qtype == TypeCNAME && name == "www.foo.bar":
+		return []Record{{Name: "www.foo.bar.", Type: TypeCNAME, TTL: 300, Target: testDomainNoErr + ".", Data: testDomainNoErr + "."}}, nil
+	case name == testDomainWithErr:
+		return nil, &ResponseError{Name: name, Rcode: RcodeNameError}
+	}
+	return nil, &ResponseError{Name: name, NoData: true}
+}
+
 func TestResolverLookupAll(t *testing.T) {
 	type args struct {
 		ctx  context.Context
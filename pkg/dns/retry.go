@@ -0,0 +1,213 @@
+package dns
+
+import (
+	"context"
+	"math/rand/v2"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultRetryBackoff is the delay before the first retry, it doubles for each retry after that.
+	DefaultRetryBackoff time.Duration = 200 * time.Millisecond
+	// DefaultRetryMaxBackoff caps the delay between two attempts.
+	DefaultRetryMaxBackoff time.Duration = 5 * time.Second
+)
+
+type attemptsKey struct{}
+
+// WithAttempts returns a context that counts the attempts made by a RetryResolver for a single lookup.
+func WithAttempts(ctx context.Context) context.Context {
+	return context.WithValue(ctx, attemptsKey{}, new(atomic.Int32))
+}
+
+// Attempts returns the number of attempts counted in ctx, a lookup that was not retried made one
+// attempt. The retries of every query of a lookup that sends several of them are counted.
+func Attempts(ctx context.Context) int {
+	if n, ok := ctx.Value(attemptsKey{}).(*atomic.Int32); ok {
+		return 1 + int(n.Load())
+	}
+	return 1
+}
+
+// RetryPolicy configures a RetryResolver.
+type RetryPolicy struct {
+	// Retryable decides which classes of failure are retried, when nil Class.Retryable is used.
+	Retryable func(Class) bool
+	// MaxAttempts is the number of attempts for a lookup including the first.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, it doubles for every retry up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// AttemptTimeout bounds each attempt, zero leaves only the deadline of the caller.
+	AttemptTimeout time.Duration
+	// Budget is the number of retries allowed for the whole run, zero is unlimited.
+	Budget int
+}
+
+// RetryResolver is a CustomResolver decorator that retries failed lookups with an exponential
+// backoff and full jitter.
+type RetryResolver struct {
+	next      CustomResolver
+	retryable func(Class) bool
+	policy    RetryPolicy
+	retries   atomic.Int64
+	exhausted atomic.Int64
+}
+
+// NewRetryResolver wraps next with the retry policy.
+func NewRetryResolver(next CustomResolver, policy RetryPolicy) *RetryResolver {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.MaxBackoff < policy.Backoff {
+		policy.MaxBackoff = policy.Backoff
+	}
+	r := &RetryResolver{
+		next:      next,
+		retryable: policy.Retryable,
+		policy:    policy,
+	}
+	if r.retryable == nil {
+		r.retryable = Class.Retryable
+	}
+	return r
+}
+
+// Retries returns the number of retries made so far in the run.
+func (r *RetryResolver) Retries() int64 {
+	return r.retries.Load()
+}
+
+// BudgetExhausted returns the number of retries that were not made because the run budget was spent.
+func (r *RetryResolver) BudgetExhausted() int64 {
+	return r.exhausted.Load()
+}
+
+// LookupCNAME retries the LookupCNAME of the wrapped resolver.
+func (r *RetryResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	return retry(ctx, r, func(ctx context.Context) (string, error) {
+		return r.next.LookupCNAME(ctx, host)
+	})
+}
+
+// LookupIPAddr retries the LookupIPAddr of the wrapped resolver.
+func (r *RetryResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return retry(ctx, r, func(ctx context.Context) ([]net.IPAddr, error) {
+		return r.next.LookupIPAddr(ctx, host)
+	})
+}
+
+// LookupAddr retries the LookupAddr of the wrapped resolver.
+func (r *RetryResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return retry(ctx, r, func(ctx context.Context) ([]string, error) {
+		return r.next.LookupAddr(ctx, addr)
+	})
+}
+
+// LookupNS retries the LookupNS of the wrapped resolver.
+func (r *RetryResolver) LookupNS(ctx context.Context, host string) ([]*net.NS, error) {
+	return retry(ctx, r, func(ctx context.Context) ([]*net.NS, error) {
+		return r.next.LookupNS(ctx, host)
+	})
+}
+
+// LookupTXT retries the LookupTXT of the wrapped resolver.
+func (r *RetryResolver) LookupTXT(ctx context.Context, host string) ([]string, error) {
+	return retry(ctx, r, func(ctx context.Context) ([]string, error) {
+		return r.next.LookupTXT(ctx, host)
+	})
+}
+
+// LookupMX retries the LookupMX of the wrapped resolver.
+func (r *RetryResolver) LookupMX(ctx context.Context, host string) ([]*net.MX, error) {
+	return retry(ctx, r, func(ctx context.Context) ([]*net.MX, error) {
+		return r.next.LookupMX(ctx, host)
+	})
+}
+
+// LookupRecords retries the LookupRecords of the wrapped resolver.
+func (r *RetryResolver) LookupRecords(ctx context.Context, name string, qtype Type) ([]Record, error) {
+	return retry(ctx, r, func(ctx context.Context) ([]Record, error) {
+		return r.next.LookupRecords(ctx, name, qtype)
+	})
+}
+
+// retry runs lookup until it succeeds, fails with a class that is not retryable, runs out of
+// attempts or budget, or ctx is done.
+func retry[T any](ctx context.Context, r *RetryResolver, lookup func(ctx context.Context) (T, error)) (T, error) {
+	counter, _ := ctx.Value(attemptsKey{}).(*atomic.Int32)
+	var (
+		result T
+		err    error
+	)
+	for n := 1; ; n++ {
+		if counter != nil && n > 1 {
+			counter.Add(1)
+		}
+		result, err = attempt(ctx, r.policy.AttemptTimeout, lookup)
+		if err == nil || n >= r.policy.MaxAttempts || !r.retryable(Classify(err)) {
+			return result, err
+		}
+		// a lookup that is cancelled, or has spent its timeout, does not take a retry it will never
+		// make from the budget.
+		if ctx.Err() != nil || expired(ctx) || !r.spend() {
+			return result, err
+		}
+		if !sleep(ctx, r.backoff(n)) {
+			r.retries.Add(-1)
+			return result, err
+		}
+	}
+}
+
+// attempt runs a single lookup bounded by timeout.
+func attempt[T any](ctx context.Context, timeout time.Duration, lookup func(ctx context.Context) (T, error)) (T, error) {
+	if timeout <= 0 {
+		return lookup(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return lookup(ctx)
+}
+
+// spend takes a retry from the run budget, it returns false when the budget is exhausted.
+func (r *RetryResolver) spend() bool {
+	n := r.retries.Add(1)
+	if r.policy.Budget > 0 && n > int64(r.policy.Budget) {
+		r.retries.Add(-1)
+		r.exhausted.Add(1)
+		return false
+	}
+	return true
+}
+
+// backoff returns the delay before the retry that follows attempt n, the exponential delay is
+// randomised between zero and its full value to spread out retries from concurrent lookups.
+func (r *RetryResolver) backoff(n int) time.Duration {
+	if r.policy.Backoff <= 0 {
+		return 0
+	}
+	delay := r.policy.Backoff
+	for i := 1; i < n && delay < r.policy.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, r.policy.MaxBackoff)
+	return rand.N(delay + 1) //nolint:gosec // jitter does not need a cryptographic random source.
+}
+
+// sleep waits for d, it returns false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
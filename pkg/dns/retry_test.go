@@ -0,0 +1,122 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// flakyResolver fails the first failures lookups of every method with err.
+type flakyResolver struct {
+	Mockresolver
+	err      error
+	failures int
+	calls    int
+}
+
+func (f *flakyResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, f.err
+	}
+	return f.Mockresolver.LookupIPAddr(ctx, host)
+}
+
+func TestRetryResolverLookupIPAddr(t *testing.T) {
+	timeout := &net.DNSError{Err: "i/o timeout", Name: testDomainNoErr, IsTimeout: true}
+	nxdomain := &net.DNSError{Err: "no such host", Name: testDomainNoErr, IsNotFound: true}
+	tests := []struct {
+		name         string
+		err          error
+		failures     int
+		policy       RetryPolicy
+		wantErr      bool
+		wantAttempts int
+	}{
+		{
+			name:         "succeeds after retrying timeouts",
+			err:          timeout,
+			failures:     2,
+			policy:       RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+			wantAttempts: 3,
+		},
+		{
+			name:         "gives up after max attempts",
+			err:          timeout,
+			failures:     5,
+			policy:       RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond},
+			wantErr:      true,
+			wantAttempts: 2,
+		},
+		{
+			name:         "does not retry nxdomain",
+			err:          nxdomain,
+			failures:     1,
+			policy:       RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+			wantErr:      true,
+			wantAttempts: 1,
+		},
+		{
+			name:         "budget limits the retries",
+			err:          timeout,
+			failures:     5,
+			policy:       RetryPolicy{MaxAttempts: 5, Budget: 1},
+			wantErr:      true,
+			wantAttempts: 2,
+		},
+		{
+			name:         "custom retryable classes",
+			err:          nxdomain,
+			failures:     1,
+			policy:       RetryPolicy{MaxAttempts: 2, Retryable: func(c Class) bool { return c == ClassNXDomain }},
+			wantAttempts: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRetryResolver(&flakyResolver{err: tt.err, failures: tt.failures}, tt.policy)
+			ctx := WithAttempts(context.Background())
+			_, err := r.LookupIPAddr(ctx, testDomainNoErr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RetryResolver.LookupIPAddr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := Attempts(ctx); got != tt.wantAttempts {
+				t.Errorf("expected %d attempts got %d", tt.wantAttempts, got)
+			}
+		})
+	}
+}
+
+func TestRetryResolverBackoff(t *testing.T) {
+	r := NewRetryResolver(&Mockresolver{}, RetryPolicy{MaxAttempts: 10, Backoff: 100 * time.Millisecond, MaxBackoff: time.Second})
+	for n := 1; n < 10; n++ {
+		d := r.backoff(n)
+		if d < 0 || d > time.Second {
+			t.Errorf("backoff(%d) = %s is outside of 0 to the max backoff", n, d)
+		}
+	}
+	if Attempts(context.Background()) != 1 {
+		t.Errorf("expected a context without a counter to report a single attempt")
+	}
+}
+
+func TestRetryResolverCancelled(t *testing.T) {
+	r := NewRetryResolver(&flakyResolver{err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}, failures: 5},
+		RetryPolicy{MaxAttempts: 5, Backoff: time.Hour, Budget: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := r.LookupIPAddr(ctx, testDomainNoErr); err == nil {
+		t.Errorf("expected an error when the context is done during the backoff")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("expected the backoff to stop when the context is done")
+	}
+	if _, err := r.LookupIPAddr(ctx, testDomainNoErr); err == nil {
+		t.Errorf("expected an error for a lookup with a context that is done")
+	}
+	if r.Retries() != 0 || r.BudgetExhausted() != 0 {
+		t.Errorf("expected the cancelled lookups to leave the budget, got %d retries and %d exhausted", r.Retries(), r.BudgetExhausted())
+	}
+}
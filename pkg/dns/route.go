@@ -0,0 +1,158 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DefaultRoute is the name of the route of the names that no route matches.
+const DefaultRoute string = "default"
+
+// Route sends the lookups of the names it matches to its own resolver.
+type Route struct {
+	// Name identifies the route in the output.
+	Name string
+	// Match returns true for the names of the route, the names are in lower case without the
+	// trailing dot and the addresses of reverse lookups are matched by their name under in-addr.arpa
+	// or ip6.arpa.
+	Match func(name string) bool
+	// Server is the host:port the Resolver sends the lookups to.
+	Server   string
+	Resolver CustomResolver
+	// RateLimits are the token buckets the queries of the route wait for.
+	RateLimits []*TokenBucket
+}
+
+// RouteResolver sends each lookup to the resolver of the first route that matches its name, the
+// lookups of the other names go to the default resolver. Every lookup is routed on its own so the
+// lookups of the names an answer depends on can take a different route than the answer.
+type RouteResolver struct {
+	next   CustomResolver
+	routes []Route
+}
+
+// NewRouteResolver returns a RouteResolver that sends the names no route matches to next, the
+// queries of each route wait for its rate limits.
+func NewRouteResolver(next CustomResolver, routes ...Route) *RouteResolver {
+	r := &RouteResolver{next: next, routes: make([]Route, 0, len(routes))}
+	for _, route := range routes {
+		route.Resolver = NewRateLimitResolver(route.Resolver, route.RateLimits...)
+		r.routes = append(r.routes, route)
+	}
+	return r
+}
+
+// Route returns the name of the route of a lookup of name, name is an address for reverse lookups.
+// It is DefaultRoute when no route matches.
+func (r *RouteResolver) Route(name string) string {
+	if route := r.match(name); route != nil {
+		return route.Name
+	}
+	return DefaultRoute
+}
+
+// Server returns the server of the route of a lookup of name, empty when no route matches or the
+// route has no server.
+func (r *RouteResolver) Server(name string) string {
+	if route := r.match(name); route != nil {
+		return route.Server
+	}
+	return ""
+}
+
+// match returns the first route that matches name, or nil.
+func (r *RouteResolver) match(name string) *Route {
+	if ip := net.ParseIP(name); ip != nil {
+		name = ReverseName(ip)
+	}
+	name = canonical(name)
+	for i := range r.routes {
+		if r.routes[i].Match(name) {
+			return &r.routes[i]
+		}
+	}
+	return nil
+}
+
+// resolver returns the resolver of the route of name.
+func (r *RouteResolver) resolver(name string) CustomResolver {
+	if route := r.match(name); route != nil {
+		return route.Resolver
+	}
+	return r.next
+}
+
+// LookupCNAME sends the lookup to the resolver of the route of host.
+func (r *RouteResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	return r.resolver(host).LookupCNAME(ctx, host)
+}
+
+// LookupIPAddr sends the lookup to the resolver of the route of host.
+func (r *RouteResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return r.resolver(host).LookupIPAddr(ctx, host)
+}
+
+// LookupAddr sends the lookup to the resolver of the route of the reverse name of addr.
+func (r *RouteResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return r.resolver(addr).LookupAddr(ctx, addr)
+}
+
+// LookupNS sends the lookup to the resolver of the route of host.
+func (r *RouteResolver) LookupNS(ctx context.Context, host string) ([]*net.NS, error) {
+	return r.resolver(host).LookupNS(ctx, host)
+}
+
+// LookupTXT sends the lookup to the resolver of the route of host.
+func (r *RouteResolver) LookupTXT(ctx context.Context, host string) ([]string, error) {
+	return r.resolver(host).LookupTXT(ctx, host)
+}
+
+// LookupMX sends the lookup to the resolver of the route of host.
+func (r *RouteResolver) LookupMX(ctx context.Context, host string) ([]*net.MX, error) {
+	return r.resolver(host).LookupMX(ctx, host)
+}
+
+// LookupRecords sends the lookup to the resolver of the route of name.
+func (r *RouteResolver) LookupRecords(ctx context.Context, name string, qtype Type) ([]Record, error) {
+	return r.resolver(name).LookupRecords(ctx, name, qtype)
+}
+
+// ReverseName returns the name of the PTR record of ip under in-addr.arpa or ip6.arpa.
+func ReverseName(ip net.IP) string {
+	var b strings.Builder
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			b.WriteString(strconv.Itoa(int(ip4[i])))
+			b.WriteByte('.')
+		}
+		b.WriteString("in-addr.arpa")
+		return b.String()
+	}
+	const hex = "0123456789abcdef"
+	for i := len(ip) - 1; i >= 0; i-- {
+		b.WriteByte(hex[ip[i]&0x0f])
+		b.WriteByte('.')
+		b.WriteByte(hex[ip[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa")
+	return b.String()
+}
+
+// MatchSuffix returns a Route.Match function for the names equal to or below one of suffixes.
+func MatchSuffix(suffixes ...string) func(name string) bool {
+	canon := make([]string, 0, len(suffixes))
+	for _, s := range suffixes {
+		canon = append(canon, canonical(s))
+	}
+	return func(name string) bool {
+		for _, s := range canon {
+			if name == s || strings.HasSuffix(name, "."+s) {
+				return true
+			}
+		}
+		return false
+	}
+}
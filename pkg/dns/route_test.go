@@ -0,0 +1,57 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"testing"
+)
+
+func TestRouteResolver(t *testing.T) {
+	corp, lab, fallback := &countingResolver{Mockresolver: &Mockresolver{}}, &countingResolver{Mockresolver: &Mockresolver{}}, &countingResolver{Mockresolver: &Mockresolver{}}
+	r := NewRouteResolver(fallback,
+		Route{Name: "corp", Match: MatchSuffix("corp.example.", "10.in-addr.arpa"), Resolver: corp},
+		Route{Name: "lab", Match: regexp.MustCompile(`^lab-[0-9]+\.`).MatchString, Resolver: lab},
+	)
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "vpn.corp.example", want: "corp"},
+		{name: "CORP.example.", want: "corp"},
+		{name: "notcorp.example", want: DefaultRoute},
+		{name: "lab-1.corp.example", want: "corp"},
+		{name: "LAB-2.foo.bar", want: "lab"},
+		{name: "10.1.2.3", want: "corp"},
+		{name: "192.0.2.1", want: DefaultRoute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Route(tt.name); got != tt.want {
+				t.Errorf("Route(%s) = %s, want %s", tt.name, got, tt.want)
+			}
+		})
+	}
+	for _, host := range []string{"vpn.corp.example", "lab-3.foo.bar", "lab.foo.bar", testDomainNoErr} {
+		_, _ = r.LookupIPAddr(context.Background(), host)
+	}
+	if corp.lookups.Load() != 1 || lab.lookups.Load() != 1 || fallback.lookups.Load() != 2 {
+		t.Errorf("LookupIPAddr() sent %d to corp, %d to lab and %d to the default, want 1, 1 and 2",
+			corp.lookups.Load(), lab.lookups.Load(), fallback.lookups.Load())
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{ip: "10.1.2.3", want: "3.2.1.10.in-addr.arpa"},
+		{ip: "2001:db8::1", want: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"},
+	}
+	for _, tt := range tests {
+		if got := ReverseName(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("ReverseName(%s) = %s, want %s", tt.ip, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Transport is the protocol the queries are sent to a nameserver over.
+type Transport string
+
+const (
+	// TransportUDP sends the queries over UDP and repeats them over TCP when the response is truncated.
+	TransportUDP Transport = "udp"
+	// TransportTCP sends every query over TCP.
+	TransportTCP Transport = "tcp"
+	// TransportTLS sends every query over TLS, DNS over TLS as in RFC 7858.
+	TransportTLS Transport = "tls"
+)
+
+// Transports are the valid transports.
+var Transports = []Transport{TransportUDP, TransportTCP, TransportTLS}
+
+// ParseTransport returns the transport named s, UDP when s is empty.
+func ParseTransport(s string) (Transport, error) {
+	if s == "" {
+		return TransportUDP, nil
+	}
+	for _, t := range Transports {
+		if strings.EqualFold(s, string(t)) {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown transport %s, valid transports are udp, tcp and tls", s)
+}
+
+// DefaultPort returns the port of the nameservers of the transport, 853 for TLS and 53 otherwise.
+func (t Transport) DefaultPort() int {
+	if t == TransportTLS {
+		return 853
+	}
+	return 53
+}
+
+// dial connects to the nameserver over transport, network is only used over UDP so the queries of
+// the other transports always use a stream connection.
+func dial(ctx context.Context, network, nameserver string, transport Transport, timeout time.Duration) (net.Conn, error) {
+	d := &net.Dialer{Timeout: timeout}
+	switch transport {
+	case TransportTCP:
+		return d.DialContext(ctx, "tcp", nameserver)
+	case TransportTLS:
+		host, _, err := net.SplitHostPort(nameserver)
+		if err != nil {
+			return nil, err
+		}
+		td := &tls.Dialer{NetDialer: d, Config: &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}}
+		return td.DialContext(ctx, "tcp", nameserver)
+	}
+	return d.DialContext(ctx, network, nameserver)
+}
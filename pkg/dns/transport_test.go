@@ -0,0 +1,63 @@
+package dns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestTransportQuery(t *testing.T) {
+	srv := newTestServer(t, func(q dnsmessage.Question, tcp bool) dnsmessage.Message {
+		ip := [4]byte{8, 8, 4, 4}
+		if tcp {
+			ip = [4]byte{8, 8, 8, 8}
+		}
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{
+			{Header: rrHeader(q.Name.String(), dnsmessage.TypeA, 60), Body: &dnsmessage.AResource{A: ip}},
+		}}
+	})
+	tests := []struct {
+		transport Transport
+		want      string
+	}{
+		{transport: "", want: googlePubDNS1},
+		{transport: TransportUDP, want: googlePubDNS1},
+		{transport: TransportTCP, want: "8.8.8.8"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.transport), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			r := NewTransportResolver(srv.addr(), tt.transport, time.Second)
+			records, err := r.LookupRecords(ctx, testDomainNoErr, TypeA)
+			if err != nil || len(records) != 1 || records[0].Data != tt.want {
+				t.Fatalf("LookupRecords() = %+v, %v, want %s", records, err, tt.want)
+			}
+			addrs, err := r.LookupIPAddr(ctx, testDomainNoErr)
+			if err != nil || len(addrs) == 0 || addrs[0].IP.String() != tt.want {
+				t.Errorf("LookupIPAddr() = %v, %v, want %s", addrs, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTransport(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Transport
+		wantErr bool
+	}{
+		{in: "", want: TransportUDP},
+		{in: "TCP", want: TransportTCP},
+		{in: "tls", want: TransportTLS},
+		{in: "https", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTransport(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseTransport(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
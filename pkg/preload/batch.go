@@ -0,0 +1,429 @@
+package preload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// modes supported by ParseErrorPolicy.
+	onErrorAbort     string = "abort"
+	onErrorContinue  string = "continue"
+	onErrorThreshold string = "threshold:"
+	maxPercent       int    = 100
+	// ExitCodeFailed is used when a failure can not be classified.
+	ExitCodeFailed int = 1
+	// ExitCodeMaxRuntime is used when Options.MaxRuntime stopped the run, like timeout(1).
+	ExitCodeMaxRuntime int = 124
+	// ExitCodeCancelled is used when the run was cancelled, the code shells use for an interrupt.
+	ExitCodeCancelled int = 130
+)
+
+// ErrMaxRuntime is the cause of a run that was stopped by Options.MaxRuntime.
+var ErrMaxRuntime = errors.New("maximum runtime reached")
+
+// Action is what happens to a lookup that failed with a class of error.
+type Action string
+
+const (
+	// ActionFail fails the lookup.
+	ActionFail Action = "fail"
+	// ActionSkip records the lookup as skipped and ignores the failure.
+	ActionSkip Action = "skip"
+	// ActionRetry retries the lookup with the retry policy.
+	ActionRetry Action = "retry"
+)
+
+// ExitCodes are the process exit codes for each class of failure, when a run has failures of
+// several classes the exit code of the most severe class in dns.Classes is used.
+var ExitCodes = map[dns.Class]int{
+	dns.ClassNXDomain:    10,
+	dns.ClassNoData:      11,
+	dns.ClassServFail:    12,
+	dns.ClassRefused:     13,
+	dns.ClassTimeout:     14,
+	dns.ClassUnreachable: 15,
+	dns.ClassTruncated:   16,
+	dns.ClassMalformed:   17,
+	dns.ClassUnknown:     ExitCodeFailed,
+}
+
+// ErrorActions holds the action for each class of failure, classes that are not listed fail the lookup.
+type ErrorActions map[dns.Class]Action
+
+// ParseErrorActions parses a comma separated list of class=action pairs.
+func ParseErrorActions(s string) (ErrorActions, error) {
+	actions := make(ErrorActions)
+	if s == "" {
+		return actions, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		name, action, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid error action %s, expected class=action", pair)
+		}
+		class, err := dns.ParseClass(name)
+		if err != nil {
+			return nil, err
+		}
+		switch Action(action) {
+		case ActionFail, ActionSkip, ActionRetry:
+			actions[class] = Action(action)
+		default:
+			return nil, fmt.Errorf("unknown error action %s for class %s, expected retry, fail or skip", action, class)
+		}
+	}
+	return actions, nil
+}
+
+// Skip returns true when failures of class are skipped instead of failing the lookup.
+func (ea ErrorActions) Skip(class dns.Class) bool {
+	return ea[class] == ActionSkip
+}
+
+// Retryable returns true when failures of class are retried, classes without an action use dns.Class.Retryable.
+func (ea ErrorActions) Retryable(class dns.Class) bool {
+	if action, ok := ea[class]; ok {
+		return action == ActionRetry
+	}
+	return class.Retryable()
+}
+
+// ErrorPolicy decides when a batch of lookups stops after a failure, the zero value aborts on the
+// first failure.
+type ErrorPolicy struct {
+	mode string
+	// percent of the names in a batch that may fail before the batch is aborted in threshold mode.
+	percent int
+}
+
+// ParseErrorPolicy parses continue, abort or threshold:N%, an empty string is the default abort policy.
+func ParseErrorPolicy(s string) (ErrorPolicy, error) {
+	switch {
+	case s == "", s == onErrorAbort:
+		return ErrorPolicy{mode: onErrorAbort}, nil
+	case s == onErrorContinue:
+		return ErrorPolicy{mode: onErrorContinue}, nil
+	case strings.HasPrefix(s, onErrorThreshold):
+		pct, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(s, onErrorThreshold), "%"))
+		if err != nil || pct < 0 || pct > maxPercent {
+			return ErrorPolicy{}, fmt.Errorf("invalid on-error threshold %s, expected threshold:N%% with N between 0 and 100", s)
+		}
+		return ErrorPolicy{mode: onErrorThreshold, percent: pct}, nil
+	}
+	return ErrorPolicy{}, fmt.Errorf("unknown on-error mode %s, expected continue, abort or threshold:N%%", s)
+}
+
+// exceeded returns true when failed out of total lookups should abort the batch.
+func (ep ErrorPolicy) exceeded(failed, total int) bool {
+	switch ep.mode {
+	case onErrorContinue:
+		return false
+	case onErrorThreshold:
+		return failed*maxPercent > ep.percent*total
+	}
+	return failed > 0
+}
+
+// LookupError is the failure of a single lookup.
+type LookupError struct {
+	Err   error
+	Name  string
+	QType string
+	Class dns.Class
+}
+
+func (e *LookupError) Error() string {
+	return fmt.Sprintf("%s type %s failed with %s: %s", e.Name, e.QType, e.Class, e.Err)
+}
+
+func (e *LookupError) Unwrap() error {
+	return e.Err
+}
+
+// AggregateError lists every failed lookup, Aborted is set when the error policy stopped the run early.
+type AggregateError struct {
+	Errors  []*LookupError
+	Aborted bool
+}
+
+func (e *AggregateError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d lookups failed:\n  %s", len(e.Errors), strings.Join(msgs, "\n  "))
+}
+
+// ExitCode returns the exit code of the most severe class of failure, it implements kong.ExitCoder.
+func (e *AggregateError) ExitCode() int {
+	for _, class := range dns.Classes {
+		for _, err := range e.Errors {
+			if err.Class == class {
+				return ExitCodes[class]
+			}
+		}
+	}
+	return ExitCodeFailed
+}
+
+func (e *AggregateError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// add appends the failure of name, nested aggregate errors from follow up lookups are flattened.
+func (e *AggregateError) add(name, qtype string, err error) {
+	var agg *AggregateError
+	if errors.As(err, &agg) {
+		e.Errors = append(e.Errors, agg.Errors...)
+		return
+	}
+	e.Errors = append(e.Errors, &LookupError{Err: err, Name: name, QType: qtype, Class: dns.Classify(err)})
+}
+
+// CancelledError is returned when the context of a run is done before every lookup completed, the
+// lookups that had not completed are skipped. Err holds the failures of the lookups that did complete.
+type CancelledError struct {
+	Cause error
+	Err   error
+}
+
+func (e *CancelledError) Error() string {
+	msg := fmt.Sprintf("preload stopped before it completed: %s", e.Cause)
+	if e.Err != nil {
+		msg += "\n" + e.Err.Error()
+	}
+	return msg
+}
+
+// ExitCode returns ExitCodeMaxRuntime or ExitCodeCancelled, it implements kong.ExitCoder.
+func (e *CancelledError) ExitCode() int {
+	if errors.Is(e.Cause, ErrMaxRuntime) {
+		return ExitCodeMaxRuntime
+	}
+	return ExitCodeCancelled
+}
+
+func (e *CancelledError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Cause}
+	}
+	return []error{e.Cause, e.Err}
+}
+
+// JoinErrors merges the errors of several batches or runs into a single error, an error that is
+// not an AggregateError is returned as is.
+func JoinErrors(errs ...error) error {
+	joined := &AggregateError{}
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		var agg *AggregateError
+		if !errors.As(err, &agg) {
+			return err
+		}
+		joined.add("", "", agg)
+		joined.Aborted = joined.Aborted || agg.Aborted
+	}
+	if len(joined.Errors) == 0 {
+		return nil
+	}
+	return joined
+}
+
+// StopRun returns true when err from a query type or run must stop the ones that follow it.
+func StopRun(err error) bool {
+	var agg *AggregateError
+	if errors.As(err, &agg) {
+		return agg.Aborted
+	}
+	return true
+}
+
+// batch runs the lookups for a single query type and applies the error policy to the failures.
+//
+//nolint:govet // fieldalignment is not required here.
+type batch struct {
+	mu     sync.Mutex
+	p      *Preloader
+	ctx    context.Context
+	cancel context.CancelFunc
+	g      *errgroup.Group
+	pool   pool
+	start  time.Time
+	qtype  string
+	total  int
+	names  int
+	failed *AggregateError
+	// priority and group of the names, skipped counts the names that did not complete and last is
+	// the time the last lookup completed.
+	priority int
+	group    string
+	skipped  int
+	last     time.Time
+}
+
+// pool bounds the number of lookups in flight across every batch of a run.
+type pool interface {
+	Acquire(ctx context.Context) error
+	Release()
+}
+
+// semaphore is a pool with a fixed number of workers.
+type semaphore chan struct{}
+
+// newPool returns the shared pool of workers, the adaptive controller with Options.AutoWorkers.
+func (p *Preloader) newPool() pool {
+	if p.workers != nil {
+		return p.workers
+	}
+	return make(semaphore, p.opts.Workers)
+}
+
+// Acquire blocks until a worker is free or ctx is done.
+func (s semaphore) Acquire(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees the worker taken by Acquire.
+func (s semaphore) Release() {
+	<-s
+}
+
+// newBatch creates a batch for size names of qtype that shares the pool of workers of the run.
+func (p *Preloader) newBatch(ctx context.Context, qtype string, size int) *batch {
+	var workers pool
+	var limit int
+	switch group := p.group(ctx); {
+	case isFollowUp(ctx) && group.Workers > 0:
+		limit = group.Workers
+	case isFollowUp(ctx) && p.workers != nil:
+		// follow up lookups run while their parent holds a worker, waiting for the pool could
+		// deadlock so they use the current limit of the controller as a fixed number of workers.
+		limit = p.workers.Limit()
+	case isFollowUp(ctx):
+		limit = p.opts.Workers
+	default:
+		// the pool bounds the concurrency, it never has more than the largest number of workers.
+		workers, limit = p.groupPool(ctx), MaxWorkers
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &batch{
+		p:      p,
+		ctx:    ctx,
+		cancel: cancel,
+		g:      createErrGroup(limit),
+		pool:   workers,
+		start:  time.Now(),
+		qtype:  qtype,
+		total:  size,
+		failed: &AggregateError{},
+	}
+}
+
+// Go runs the lookup of host, once the batch is aborted the remaining names are skipped. It blocks
+// until the shared pool has a free worker.
+func (b *batch) Go(host string, lookup func(ctx context.Context) error) {
+	if b.pool != nil {
+		if err := b.pool.Acquire(b.ctx); err != nil {
+			b.g.Go(func() error {
+				return b.skip(host)
+			})
+			return
+		}
+	}
+	b.g.Go(func() error {
+		if b.pool != nil {
+			defer b.pool.Release()
+		}
+		if b.ctx.Err() != nil {
+			return b.skip(host)
+		}
+		err := lookup(b.ctx)
+		b.mu.Lock()
+		b.last = time.Now()
+		b.mu.Unlock()
+		if err != nil {
+			b.fail(host, err)
+		}
+		return nil
+	})
+}
+
+// skip sends the skip event for host that did not complete.
+func (b *batch) skip(host string) error {
+	b.mu.Lock()
+	b.skipped++
+	b.mu.Unlock()
+	return b.p.skip(b.ctx, host, b.qtype, nil)
+}
+
+// outcome returns the number of names that failed, whether every name completed and the time the
+// last of them completed.
+func (b *batch) outcome() (int, bool, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.names, b.skipped == 0, b.last
+}
+
+// fail records the failure of host and aborts the batch when the error policy is exceeded.
+func (b *batch) fail(host string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failed.add(host, b.qtype, err)
+	b.names++
+	if b.p.opts.OnError.exceeded(b.names, b.total) {
+		b.failed.Aborted = true
+		b.cancel()
+	}
+}
+
+// aborted returns true once the error policy stopped the batch.
+func (b *batch) aborted() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failed.Aborted
+}
+
+// Wait blocks until every lookup has completed and returns the aggregated failures.
+func (b *batch) Wait() error {
+	defer b.cancel()
+	if err := b.g.Wait(); err != nil {
+		return err
+	}
+	if len(b.failed.Errors) == 0 {
+		return nil
+	}
+	return b.failed
+}
+
+// createErrGroup handles the logic to setup an errgroup for concurrency.
+func createErrGroup(limit int) *errgroup.Group {
+	g := new(errgroup.Group)
+	// if limit is not set, i.e. in testing set it.
+	if limit < 1 {
+		g.SetLimit(1)
+		return g
+	}
+	g.SetLimit(limit)
+
+	return g
+}
@@ -0,0 +1,197 @@
+package preload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+)
+
+func TestParseErrorPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		flag    string
+		want    ErrorPolicy
+		wantErr bool
+	}{
+		{
+			name: "default",
+			flag: "",
+			want: ErrorPolicy{mode: onErrorAbort},
+		},
+		{
+			name: "continue",
+			flag: "continue",
+			want: ErrorPolicy{mode: onErrorContinue},
+		},
+		{
+			name: "threshold",
+			flag: "threshold:25%",
+			want: ErrorPolicy{mode: onErrorThreshold, percent: 25},
+		},
+		{
+			name:    "threshold out of range",
+			flag:    "threshold:125%",
+			wantErr: true,
+		},
+		{
+			name:    "unknown mode",
+			flag:    "ignore",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseErrorPolicy(tt.flag)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseErrorPolicy() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseErrorPolicy() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorPolicyExceeded(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ErrorPolicy
+		failed int
+		total  int
+		want   bool
+	}{
+		{name: "abort on first failure", policy: ErrorPolicy{mode: onErrorAbort}, failed: 1, total: 100, want: true},
+		{name: "continue never aborts", policy: ErrorPolicy{mode: onErrorContinue}, failed: 100, total: 100, want: false},
+		{name: "threshold not reached", policy: ErrorPolicy{mode: onErrorThreshold, percent: 10}, failed: 10, total: 100, want: false},
+		{name: "threshold exceeded", policy: ErrorPolicy{mode: onErrorThreshold, percent: 10}, failed: 11, total: 100, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.exceeded(tt.failed, tt.total); got != tt.want {
+				t.Errorf("ErrorPolicy.exceeded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreloaderOnError(t *testing.T) {
+	hosts := []string{testDomainWithErr, testDomainNoErr, "baz.foo", testDomainNS1}
+	tests := []struct {
+		name        string
+		policy      ErrorPolicy
+		wantFailed  int
+		wantSkipped int
+		wantAborted bool
+	}{
+		{
+			name:       "continue collects every failure",
+			policy:     ErrorPolicy{mode: onErrorContinue},
+			wantFailed: 2,
+		},
+		{
+			name:        "abort skips the remaining names",
+			policy:      ErrorPolicy{mode: onErrorAbort},
+			wantFailed:  1,
+			wantSkipped: 3,
+			wantAborted: true,
+		},
+		{
+			name:       "threshold allows half of the names to fail",
+			policy:     ErrorPolicy{mode: onErrorThreshold, percent: 50},
+			wantFailed: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(Options{Workers: 1, Resolver: NewMockResolver(), OnError: tt.policy})
+			err := p.preload(context.Background(), recordTypes[confighandlers.Hosts], hosts)
+			var agg *AggregateError
+			if !errors.As(err, &agg) {
+				t.Fatalf("expected an AggregateError got %v", err)
+			}
+			if len(agg.Errors) != tt.wantFailed || agg.Aborted != tt.wantAborted {
+				t.Errorf("expected %d failures aborted %v got %d aborted %v", tt.wantFailed, tt.wantAborted, len(agg.Errors), agg.Aborted)
+			}
+			if !strings.Contains(err.Error(), testDomainWithErr) {
+				t.Errorf("expected the error to list %s got %s", testDomainWithErr, err)
+			}
+			if skipped := p.record.Summary(0, 0).Total.Skipped; skipped != tt.wantSkipped {
+				t.Errorf("expected %d skipped got %d", tt.wantSkipped, skipped)
+			}
+		})
+	}
+}
+
+func TestJoinErrors(t *testing.T) {
+	first := &AggregateError{Errors: []*LookupError{{Name: testDomainWithErr, QType: QTypeA, Err: fmt.Errorf(nxDomainErr, testDomainWithErr)}}}
+	second := &AggregateError{Errors: []*LookupError{{Name: testDomainWithErr, QType: QTypeMX, Err: fmt.Errorf(nxDomainErr, testDomainWithErr)}}, Aborted: true}
+	err := JoinErrors(nil, first, second)
+	var agg *AggregateError
+	if !errors.As(err, &agg) || len(agg.Errors) != 2 || !agg.Aborted {
+		t.Fatalf("expected 2 aborted failures got %v", err)
+	}
+	if JoinErrors(nil, nil) != nil {
+		t.Errorf("expected nil when there are no errors")
+	}
+	plain := &net.DNSError{Err: "server misbehaving"}
+	if !errors.Is(JoinErrors(first, plain), plain) {
+		t.Errorf("expected errors that are not lookup failures to be returned as is")
+	}
+}
+
+func TestParseErrorActions(t *testing.T) {
+	actions, err := ParseErrorActions("nxdomain=skip, nodata=skip,timeout=fail")
+	if err != nil {
+		t.Fatalf("ParseErrorActions() error = %v", err)
+	}
+	if !actions.Skip(dns.ClassNXDomain) || !actions.Skip(dns.ClassNoData) || actions.Skip(dns.ClassTimeout) {
+		t.Errorf("unexpected actions %+v", actions)
+	}
+	for _, bad := range []string{"nxdomain", "bogus=skip", "nxdomain=ignore"} {
+		if _, err := ParseErrorActions(bad); err == nil {
+			t.Errorf("ParseErrorActions(%s) expected an error", bad)
+		}
+	}
+}
+
+func TestAggregateErrorExitCode(t *testing.T) {
+	agg := &AggregateError{}
+	agg.add(testDomainWithErr, QTypeA, &net.DNSError{Err: "no such host", IsNotFound: true})
+	if agg.ExitCode() != ExitCodes[dns.ClassNXDomain] {
+		t.Errorf("expected the nxdomain exit code got %d", agg.ExitCode())
+	}
+	agg.add(testDomainNoErr, QTypeA, &net.DNSError{Err: "i/o timeout", IsTimeout: true})
+	if agg.ExitCode() != ExitCodes[dns.ClassTimeout] {
+		t.Errorf("expected the more severe timeout exit code got %d", agg.ExitCode())
+	}
+	if !strings.Contains(agg.Error(), "failed with timeout") {
+		t.Errorf("expected the error to report the class got %s", agg.Error())
+	}
+}
+
+func TestPreloaderSkipClass(t *testing.T) {
+	p := New(Options{Workers: 1, Resolver: NewMockResolver(), Actions: ErrorActions{dns.ClassUnknown: ActionSkip}})
+	if err := p.preload(context.Background(), recordTypes[confighandlers.Hosts], []string{testDomainWithErr, testDomainNoErr}); err != nil {
+		t.Errorf("expected the skipped failure to be ignored got %v", err)
+	}
+	if skipped := p.record.Summary(0, 0).Total.Skipped; skipped != 1 {
+		t.Errorf("expected 1 skipped got %d", skipped)
+	}
+}
+
+func TestErrorActionsRetryable(t *testing.T) {
+	actions, err := ParseErrorActions("timeout=fail,nxdomain=retry")
+	if err != nil {
+		t.Fatalf("ParseErrorActions() error = %v", err)
+	}
+	if actions.Retryable(dns.ClassTimeout) || !actions.Retryable(dns.ClassNXDomain) || !actions.Retryable(dns.ClassServFail) {
+		t.Errorf("unexpected retryable classes for %+v", actions)
+	}
+}
@@ -0,0 +1,248 @@
+package preload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+)
+
+const (
+	// DefaultMaxDepth is the number of levels of dependencies followed when Options.MaxDepth is not set.
+	DefaultMaxDepth int = 8
+	// messages for the dependencies that are not queried.
+	cycleMessage string = "not following %s %s from %s, it is already in the chain"
+	depthMessage string = "not following %s %s from %s, it is deeper than the maximum depth of %d"
+)
+
+// dependency is a name the answers of a lookup depend on and the record type it is preloaded as,
+// typ is the key of the record type in recordTypes.
+type dependency struct {
+	typ  string
+	name string
+}
+
+// hostsDeps follows the CNAME chain the addresses of name were found through one hop per level, it
+// preloads the addresses of the next hop. A name that is not a CNAME has no dependencies.
+func hostsDeps(name string, _ []string, records []dns.Record, _ *Options) []dependency {
+	chain, _ := dns.CNAMEChain(name, records)
+	if len(chain) == 0 {
+		return nil
+	}
+	return []dependency{{typ: confighandlers.Hosts, name: chain[0].Target}}
+}
+
+// cnameDeps preloads the addresses of name, the lookup of the addresses follows the chain.
+func cnameDeps(name string, _ []string, _ []dns.Record, _ *Options) []dependency {
+	return []dependency{{typ: confighandlers.Hosts, name: name}}
+}
+
+// targetDeps preloads the addresses of the targets of MX, NS and SRV records, the SRV target "."
+// means the service is not available and is left out.
+func targetDeps(_ string, answers []string, _ []dns.Record, _ *Options) []dependency {
+	deps := make([]dependency, 0, len(answers))
+	for _, target := range answers {
+		if target != "." {
+			deps = append(deps, dependency{typ: confighandlers.Hosts, name: target})
+		}
+	}
+	return deps
+}
+
+// httpsDeps preloads the addresses of the targets of HTTPS records, the target "." is the owner name.
+func httpsDeps(name string, answers []string, _ []dns.Record, _ *Options) []dependency {
+	deps := make([]dependency, 0, len(answers))
+	for _, target := range answers {
+		if target == "." {
+			target = name
+		}
+		deps = append(deps, dependency{typ: confighandlers.Hosts, name: target})
+	}
+	return deps
+}
+
+// ptrDeps preloads the addresses of the names a PTR lookup returned with Options.ForwardPTR.
+func ptrDeps(name string, answers []string, records []dns.Record, opts *Options) []dependency {
+	if !opts.ForwardPTR {
+		return nil
+	}
+	return targetDeps(name, answers, records, opts)
+}
+
+// Node is a lookup in the dependency tree of a run with Options.Full, its children are the lookups
+// of the names its answers depend on.
+//
+//nolint:govet // fieldalignment is not required here.
+type Node struct {
+	Name    string    `json:"name"`
+	QType   string    `json:"qtype"`
+	Answers []string  `json:"answers,omitempty"`
+	Class   dns.Class `json:"class,omitempty"`
+	// Cycle is set when the name is already in the chain above the node, it is not queried again.
+	Cycle bool `json:"cycle,omitempty"`
+	// DepthLimit is set when the node is deeper than Options.MaxDepth, it is not queried.
+	DepthLimit bool    `json:"depth_limit,omitempty"`
+	Children   []*Node `json:"children,omitempty"`
+	parent     *Node
+	depth      int
+}
+
+// Depth returns the number of lookups above the node, zero for a nil node.
+func (n *Node) Depth() int {
+	if n == nil {
+		return 0
+	}
+	return n.depth
+}
+
+type parentKey struct{}
+
+// withParent marks ctx as the context of the lookups that node depends on.
+func withParent(ctx context.Context, node *Node) context.Context {
+	return context.WithValue(ctx, parentKey{}, node)
+}
+
+// parentNode returns the node set by withParent, or nil for the names of the configuration.
+func parentNode(ctx context.Context) *Node {
+	node, _ := ctx.Value(parentKey{}).(*Node)
+	return node
+}
+
+// isFollowUp returns true when ctx is the context of the dependencies of another lookup.
+func isFollowUp(ctx context.Context) bool {
+	return parentNode(ctx) != nil
+}
+
+// newNode adds the node of a lookup of name to the tree, under the parent of ctx. It returns nil
+// without Options.Full.
+func (p *Preloader) newNode(ctx context.Context, rt *recordType, name string) *Node {
+	if !p.opts.Full {
+		return nil
+	}
+	node := &Node{Name: name, QType: rt.qtype, parent: parentNode(ctx)}
+	p.addNode(node)
+	return node
+}
+
+// addNode appends node to the children of its parent or to the roots of the tree.
+func (p *Preloader) addNode(node *Node) {
+	p.treeMu.Lock()
+	defer p.treeMu.Unlock()
+	if node.parent == nil {
+		p.tree = append(p.tree, node)
+		return
+	}
+	node.depth = node.parent.depth + 1
+	node.parent.Children = append(node.parent.Children, node)
+}
+
+// complete sets the answers or the class of the failure of node.
+func (p *Preloader) complete(node *Node, answers []string, err error) {
+	if node == nil {
+		return
+	}
+	p.treeMu.Lock()
+	defer p.treeMu.Unlock()
+	node.Answers = answers
+	node.Class = dns.Classify(err)
+}
+
+// inChain returns true when name was already looked up as the record type qtype by node or one of
+// the nodes above it.
+func inChain(node *Node, qtype, name string) bool {
+	name = normalize(name)
+	for n := node; n != nil; n = n.parent {
+		if n.QType == qtype && normalize(n.Name) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// resolveDeps preloads the dependencies of the lookup of node, one batch per record type in the
+// order they are listed. The dependencies that would close a cycle or go deeper than
+// Options.MaxDepth are added to the tree without a query.
+func (p *Preloader) resolveDeps(ctx context.Context, node *Node, deps []dependency) error {
+	order := make([]string, 0, len(deps))
+	names := make(map[string][]string)
+	for _, dep := range deps {
+		rt := recordTypes[dep.typ]
+		switch {
+		case inChain(node, rt.qtype, dep.name):
+			p.addNode(&Node{Name: dep.name, QType: rt.qtype, Cycle: true, parent: node})
+			p.debugf(cycleMessage, rt.qtype, dep.name, node.Name)
+		case node.depth >= p.opts.MaxDepth:
+			p.addNode(&Node{Name: dep.name, QType: rt.qtype, DepthLimit: true, parent: node})
+			p.debugf(depthMessage, rt.qtype, dep.name, node.Name, p.opts.MaxDepth)
+		case slices.Contains(names[dep.typ], dep.name):
+		default:
+			if _, ok := names[dep.typ]; !ok {
+				order = append(order, dep.typ)
+			}
+			names[dep.typ] = append(names[dep.typ], dep.name)
+		}
+	}
+	errs := make([]error, 0, len(order))
+	for _, typ := range order {
+		errs = append(errs, p.preload(withParent(ctx, node), recordTypes[typ], names[typ]))
+	}
+	return JoinErrors(errs...)
+}
+
+// dependencyTree returns the roots of the dependency tree that pulled in other lookups.
+func (p *Preloader) dependencyTree() []*Node {
+	p.treeMu.Lock()
+	defer p.treeMu.Unlock()
+	roots := make([]*Node, 0, len(p.tree))
+	for _, node := range p.tree {
+		if len(node.Children) > 0 {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// WriteTree prints the dependency tree of a run, one lookup per line below the lookup that pulled it in.
+func WriteTree(w io.Writer, roots []*Node) error {
+	for _, root := range roots {
+		if err := writeNode(w, root, "", ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeNode(w io.Writer, node *Node, prefix, childPrefix string) error {
+	line := fmt.Sprintf("%s%s %s", prefix, node.QType, node.Name)
+	switch {
+	case node.Cycle:
+		line += " (cycle, not queried)"
+	case node.DepthLimit:
+		line += " (maximum depth, not queried)"
+	case node.Class != dns.ClassNone:
+		line += fmt.Sprintf(" failed with %s", node.Class)
+	case len(node.Answers) > 0:
+		line += " -> " + strings.Join(node.Answers, ", ")
+	}
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return err
+	}
+	for i, child := range node.Children {
+		branch, next := "├── ", "│   "
+		if i == len(node.Children)-1 {
+			branch, next = "└── ", "    "
+		}
+		if err := writeNode(w, child, childPrefix+branch, childPrefix+next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
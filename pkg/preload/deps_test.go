@@ -0,0 +1,157 @@
+package preload
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+)
+
+func TestDependencies(t *testing.T) {
+	tests := []struct {
+		name       string
+		qtype      string
+		host       string
+		answers    []string
+		records    []dns.Record
+		forwardPTR bool
+		want       []dependency
+	}{
+		{name: "cname addresses", qtype: confighandlers.Cname, host: testDomainChain, answers: []string{"www.foo.bar", testDomainNoErr},
+			want: []dependency{{typ: confighandlers.Hosts, name: testDomainChain}}},
+		{name: "address next hop", qtype: confighandlers.Hosts, host: testDomainChain, answers: []string{googlePubDNS1},
+			records: []dns.Record{
+				{Name: testDomainChain, Type: dns.TypeCNAME, Target: "www.foo.bar"},
+				{Name: "www.foo.bar", Type: dns.TypeCNAME, Target: testDomainNoErr},
+				{Name: testDomainNoErr, Type: dns.TypeA, Data: googlePubDNS1},
+			},
+			want: []dependency{{typ: confighandlers.Hosts, name: "www.foo.bar"}}},
+		{name: "address without a chain", qtype: confighandlers.Hosts, host: testDomainNoErr, answers: []string{googlePubDNS1},
+			records: []dns.Record{{Name: testDomainNoErr, Type: dns.TypeA, Data: googlePubDNS1}}},
+		{name: "mx targets", qtype: confighandlers.Mx, host: testDomainNoErr, answers: []string{testDomainMX0},
+			want: []dependency{{typ: confighandlers.Hosts, name: testDomainMX0}}},
+		{name: "srv without a service", qtype: confighandlers.Srv, host: testDomainSRV, answers: []string{"."}, want: []dependency{}},
+		{name: "https on the owner", qtype: confighandlers.HTTPS, host: testDomainNoErr, answers: []string{"."},
+			want: []dependency{{typ: confighandlers.Hosts, name: testDomainNoErr}}},
+		{name: "ptr not forward", qtype: confighandlers.Ptr, host: googleIpv6, answers: []string{"ipv6.google.com"}},
+		{name: "ptr forward", qtype: confighandlers.Ptr, host: googleIpv6, answers: []string{"ipv6.google.com"}, forwardPTR: true,
+			want: []dependency{{typ: confighandlers.Hosts, name: "ipv6.google.com"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := recordTypes[tt.qtype].deps(tt.host, tt.answers, tt.records, &Options{ForwardPTR: tt.forwardPTR})
+			if len(got) != len(tt.want) {
+				t.Fatalf("deps() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("deps() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPreloaderRunTree(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      confighandlers.QueryType
+		maxDepth int
+		want     string
+	}{
+		{
+			name: "cname chain",
+			cfg:  confighandlers.QueryType{Cname: []string{testDomainChain}},
+			want: "CNAME chain.foo.bar -> www.foo.bar, foo.bar\n" +
+				"└── A, AAAA chain.foo.bar\n" +
+				"    └── A, AAAA www.foo.bar -> 8.8.4.4\n" +
+				"        └── A, AAAA foo.bar -> 8.8.4.4\n",
+		},
+		{
+			name: "cname loop",
+			cfg:  confighandlers.QueryType{Cname: []string{testDomainLoop}},
+			want: "CNAME loop.foo.bar -> loop2.foo.bar, loop.foo.bar\n" +
+				"└── A, AAAA loop.foo.bar\n" +
+				"    └── A, AAAA loop2.foo.bar\n" +
+				"        └── A, AAAA loop.foo.bar (cycle, not queried)\n",
+		},
+		{
+			name:     "cname chain deeper than the maximum depth",
+			cfg:      confighandlers.QueryType{Cname: []string{testDomainChain}},
+			maxDepth: 1,
+			want: "CNAME chain.foo.bar -> www.foo.bar, foo.bar\n" +
+				"└── A, AAAA chain.foo.bar\n" +
+				"    └── A, AAAA www.foo.bar (maximum depth, not queried)\n",
+		},
+		{
+			name: "srv target and names without dependencies",
+			cfg:  confighandlers.QueryType{SRV: []string{testDomainSRV}, Hosts: []string{testDomainNoErr}, TXT: []string{testDomainNoErr}},
+			want: "SRV _sip._tcp.foo.bar -> mx0.foo.bar\n" +
+				"└── A, AAAA mx0.foo.bar -> 8.8.4.4\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(Options{
+				Resolver: NewMockResolver(),
+				Workers:  1,
+				Full:     true,
+				MaxDepth: tt.maxDepth,
+				OnError:  ErrorPolicy{mode: onErrorContinue},
+				Actions:  ErrorActions{dns.ClassUnknown: ActionSkip},
+			})
+			result, err := p.Run(context.Background(), &confighandlers.Configuration{QueryType: tt.cfg})
+			if err != nil {
+				t.Fatalf("Preloader.Run() error = %v", err)
+			}
+			buf := &bytes.Buffer{}
+			if err = WriteTree(buf, result.Tree); err != nil {
+				t.Fatalf("WriteTree() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("WriteTree() =\n%s\nwant\n%s", buf, tt.want)
+			}
+			for _, ev := range result.Lookups {
+				if ev.Name == testDomainMX0 && ev.QType == QTypeA && ev.Depth != 1 {
+					t.Errorf("expected the address of the srv target at depth 1, got %d", ev.Depth)
+				}
+			}
+		})
+	}
+}
+
+func TestInChain(t *testing.T) {
+	root := &Node{Name: "Loop.foo.bar.", QType: QTypeCNAME}
+	child := &Node{Name: testDomainLoop2, QType: QTypeCNAME, parent: root, depth: 1}
+	if !inChain(child, QTypeCNAME, testDomainLoop) || inChain(child, QTypeA, testDomainLoop) {
+		t.Errorf("inChain() must compare the names without case and the trailing dot for the same type")
+	}
+}
+
+func TestResolveDepsLimits(t *testing.T) {
+	p := New(Options{Resolver: NewMockResolver(), Full: true, MaxDepth: 1})
+	root := &Node{Name: testDomainNoErr, QType: QTypeA}
+	p.addNode(root)
+	deps := []dependency{{typ: confighandlers.Hosts, name: "FOO.bar."}, {typ: confighandlers.Hosts, name: testDomainMX0}}
+	if err := p.resolveDeps(context.Background(), root, deps); err != nil {
+		t.Fatalf("resolveDeps() error = %v", err)
+	}
+	if len(root.Children) != 2 || !root.Children[0].Cycle || root.Children[1].Depth() != 1 {
+		t.Fatalf("expected a cycle and a lookup below the root, got %+v", root.Children)
+	}
+	child := root.Children[1]
+	if err := p.resolveDeps(context.Background(), child, deps[1:]); err != nil {
+		t.Fatalf("resolveDeps() error = %v", err)
+	}
+	if len(child.Children) != 1 || !child.Children[0].Cycle {
+		t.Errorf("expected the repeated name to be a cycle, got %+v", child.Children)
+	}
+	if err := p.resolveDeps(context.Background(), child, []dependency{{typ: confighandlers.Hosts, name: testDomainNS1}}); err != nil {
+		t.Fatalf("resolveDeps() error = %v", err)
+	}
+	if last := child.Children[len(child.Children)-1]; !last.DepthLimit || last.Name != testDomainNS1 {
+		t.Errorf("expected %s to be deeper than the maximum depth, got %+v", testDomainNS1, last)
+	}
+}
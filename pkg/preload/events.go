@@ -0,0 +1,131 @@
+package preload
+
+import (
+	"context"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+)
+
+// EventKind is the type of an Event.
+type EventKind string
+
+const (
+	// EventTypeStart is sent when the lookups of a query type start, Names lists its names.
+	EventTypeStart EventKind = "type-start"
+	// EventLookup is sent when a lookup completes, Err is set when it failed.
+	EventLookup EventKind = "lookup"
+	// EventSkip is sent for a name that was skipped, Err is the failure that was ignored or nil
+	// when the lookup did not complete because the run was aborted or cancelled.
+	EventSkip EventKind = "skip"
+	// EventTypeDone is sent when every lookup of a query type has completed, Duration is the time
+	// the query type took.
+	EventTypeDone EventKind = "type-done"
+)
+
+// Event reports the progress of a run.
+//
+//nolint:govet // fieldalignment is not required here.
+type Event struct {
+	Kind     EventKind
+	Time     time.Time
+	Name     string
+	QType    string
+	Names    []string
+	Duration time.Duration
+	// Attempts made by the lookup, one unless it was retried.
+	Attempts int
+	Answers  []string
+	// Records are the answers with their TTL when the resolver reports them, for a CNAME lookup
+	// they are the hops of the chain in order.
+	Records []dns.Record
+	Err     error
+	Class   dns.Class
+	// Depth is the number of lookups above a lookup of a dependency with Options.Full, zero for
+	// the names of the configuration.
+	Depth int
+	// Priority and Group of the names of a type-start or type-done event, the group is empty for
+	// the names outside of the groups.
+	Priority int
+	Group    string
+	// Route is the name of the route a lookup or skip took with Options.Routes, empty without routes.
+	Route string
+	// Server is the host:port a lookup or skip was sent to, the server of its route, the nameserver
+	// of its group or Options.Nameserver.
+	Server string
+	// File is the configuration file the name of a lookup or skip is listed in, empty for the
+	// dependencies and the names of a configuration that was not loaded from files.
+	File string
+}
+
+// emit records a lookup or skip event in the result and delivers ev to the Options.OnEvent
+// callback and the Options.Events channel, one event at a time. The event is always recorded, it
+// is not delivered once the context of the run is done and the consumer does not take it.
+func (p *Preloader) emit(ev Event) error {
+	if !p.wait(p.deliver) {
+		p.store(ev)
+		return nil
+	}
+	defer func() { <-p.deliver }()
+	ev = p.store(ev)
+	if p.opts.Events != nil {
+		select {
+		case p.opts.Events <- ev:
+		default:
+			select {
+			case p.opts.Events <- ev:
+			case <-p.done:
+				return nil
+			}
+		}
+	}
+	if p.opts.OnEvent != nil {
+		return p.opts.OnEvent(ev)
+	}
+	return nil
+}
+
+// wait takes the delivery slot, it returns false when the context of the run is done first.
+func (p *Preloader) wait(slot chan struct{}) bool {
+	select {
+	case slot <- struct{}{}:
+		return true
+	default:
+	}
+	select {
+	case slot <- struct{}{}:
+		return true
+	case <-p.done:
+		return false
+	}
+}
+
+// store completes ev and adds a lookup or skip event to the result.
+func (p *Preloader) store(ev Event) Event {
+	ev.Time = time.Now()
+	ev.Class = dns.Classify(ev.Err)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ev.Kind == EventLookup || ev.Kind == EventSkip {
+		if p.router != nil {
+			ev.Route = p.router.Route(ev.Name)
+		}
+		if ev.Depth == 0 {
+			ev.File = p.files[TypeName(ev.QType)+" "+normalize(ev.Name)]
+		}
+	}
+	switch ev.Kind {
+	case EventLookup:
+		p.record.Record(ev.Name, ev.QType, ev.Duration, ev.Attempts, ev.Err)
+		p.lookups = append(p.lookups, ev)
+	case EventSkip:
+		p.record.Skip(ev.Name, ev.QType)
+		p.lookups = append(p.lookups, ev)
+	}
+	return ev
+}
+
+// skip sends the skip event for name in the group of ctx, err is the failure that was ignored or nil.
+func (p *Preloader) skip(ctx context.Context, name, qtype string, err error) error {
+	return p.emit(Event{Kind: EventSkip, Name: name, QType: qtype, Err: err, Server: p.server(ctx, name)})
+}
@@ -0,0 +1,160 @@
+package preload
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+)
+
+// GroupOptions are the settings the names of a group of the configuration and their dependencies
+// are looked up with, a setting that is not set keeps the setting of the run.
+type GroupOptions struct {
+	// Nameserver receives the lookups of the group that no route matches.
+	Nameserver string
+	// Resolver replaces the resolver for Nameserver.
+	Resolver dns.CustomResolver
+	// ServerRateLimits are the token buckets the queries sent to Nameserver wait for.
+	ServerRateLimits []*dns.TokenBucket
+	// Workers is the number of concurrent lookups of the group, it replaces the adaptive workers.
+	Workers int
+	// Timeout bounds the time each lookup of the group spends querying including its retries.
+	Timeout time.Duration
+}
+
+type groupKey struct{}
+
+// withGroup marks ctx as the context of the lookups of the names of group.
+func withGroup(ctx context.Context, group string) context.Context {
+	return context.WithValue(ctx, groupKey{}, group)
+}
+
+// groupOf returns the group set by withGroup, or an empty string outside of the groups.
+func groupOf(ctx context.Context) string {
+	group, _ := ctx.Value(groupKey{}).(string)
+	return group
+}
+
+// group returns the settings of the group of ctx, the zero value outside of the groups.
+func (p *Preloader) group(ctx context.Context) GroupOptions {
+	return p.opts.Groups[groupOf(ctx)]
+}
+
+// timeout returns the timeout of the lookups of the group of ctx.
+func (p *Preloader) timeout(ctx context.Context) time.Duration {
+	if group := p.group(ctx); group.Timeout > 0 {
+		return group.Timeout
+	}
+	return p.opts.Timeout
+}
+
+// server returns the server a lookup of name in the group of ctx is sent to: the server of its
+// route, the nameserver of the group or Options.Nameserver. A route without a server is named by
+// the route and a group with a resolver but no nameserver by the group instead.
+func (p *Preloader) server(ctx context.Context, name string) string {
+	if p.router != nil {
+		if route := p.router.Route(name); route != dns.DefaultRoute {
+			if server := p.router.Server(name); server != "" {
+				return server
+			}
+			return route
+		}
+	}
+	switch group := p.group(ctx); {
+	case group.Nameserver != "":
+		return group.Nameserver
+	case group.Resolver != nil:
+		return "group " + groupOf(ctx)
+	}
+	return p.opts.Nameserver
+}
+
+// groupPools returns a pool for every group with its own workers, the other groups share the pool of the run.
+func (p *Preloader) groupPools() map[string]pool {
+	pools := make(map[string]pool)
+	for name, group := range p.opts.Groups {
+		if group.Workers > 0 {
+			pools[name] = make(semaphore, min(group.Workers, MaxWorkers))
+		}
+	}
+	return pools
+}
+
+// groupPool returns the pool of the group of ctx.
+func (p *Preloader) groupPool(ctx context.Context) pool {
+	if workers, ok := p.pools[groupOf(ctx)]; ok {
+		return workers
+	}
+	return p.pool
+}
+
+// newGroupResolver returns a resolver that sends the lookups of each group to the nameserver of
+// the group, the lookups outside of the groups and of the groups without a nameserver go to next.
+func (p *Preloader) newGroupResolver(next dns.CustomResolver) dns.CustomResolver {
+	r := &groupResolver{next: next, groups: make(map[string]dns.CustomResolver)}
+	for name, group := range p.opts.Groups {
+		resolver := group.Resolver
+		switch {
+		case resolver == nil && group.Nameserver == "":
+			continue
+		case resolver == nil:
+			timeout := group.Timeout
+			if timeout <= 0 {
+				timeout = p.opts.Timeout
+			}
+			resolver = dns.NewResolver(group.Nameserver, timeout)
+		}
+		r.groups[name] = dns.NewRateLimitResolver(resolver, p.rateLimits(group.ServerRateLimits)...)
+	}
+	return r
+}
+
+// groupResolver sends each lookup to the resolver of the group of its context.
+type groupResolver struct {
+	next   dns.CustomResolver
+	groups map[string]dns.CustomResolver
+}
+
+// resolver returns the resolver of the group of ctx.
+func (r *groupResolver) resolver(ctx context.Context) dns.CustomResolver {
+	if resolver, ok := r.groups[groupOf(ctx)]; ok {
+		return resolver
+	}
+	return r.next
+}
+
+// LookupCNAME sends the lookup to the resolver of the group of ctx.
+func (r *groupResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	return r.resolver(ctx).LookupCNAME(ctx, host)
+}
+
+// LookupIPAddr sends the lookup to the resolver of the group of ctx.
+func (r *groupResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return r.resolver(ctx).LookupIPAddr(ctx, host)
+}
+
+// LookupAddr sends the lookup to the resolver of the group of ctx.
+func (r *groupResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return r.resolver(ctx).LookupAddr(ctx, addr)
+}
+
+// LookupNS sends the lookup to the resolver of the group of ctx.
+func (r *groupResolver) LookupNS(ctx context.Context, host string) ([]*net.NS, error) {
+	return r.resolver(ctx).LookupNS(ctx, host)
+}
+
+// LookupTXT sends the lookup to the resolver of the group of ctx.
+func (r *groupResolver) LookupTXT(ctx context.Context, host string) ([]string, error) {
+	return r.resolver(ctx).LookupTXT(ctx, host)
+}
+
+// LookupMX sends the lookup to the resolver of the group of ctx.
+func (r *groupResolver) LookupMX(ctx context.Context, host string) ([]*net.MX, error) {
+	return r.resolver(ctx).LookupMX(ctx, host)
+}
+
+// LookupRecords sends the lookup to the resolver of the group of ctx.
+func (r *groupResolver) LookupRecords(ctx context.Context, name string, qtype dns.Type) ([]dns.Record, error) {
+	return r.resolver(ctx).LookupRecords(ctx, name, qtype)
+}
@@ -0,0 +1,178 @@
+package preload
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+	"github.com/jimmystewpot/dns-preload/pkg/summary"
+)
+
+// planStep is a query type of a run and the names of a priority and group it preloads.
+type planStep struct {
+	rt       *recordType
+	names    []string
+	priority int
+	group    string
+}
+
+// newPlan returns the steps for the query types in Options.Types, or for every query type in the
+// default order when it is empty. There is a step for every priority, group and query type with
+// names: the priorities from the highest across every group so that every name of a priority
+// starts before the names of the next one, and within a priority the names outside of the groups
+// first followed by the groups in order of their name. A query type without names has a step
+// without names ahead of the others.
+func (p *Preloader) newPlan(cfg *confighandlers.Configuration) ([]planStep, error) {
+	types := p.opts.Types
+	if len(types) == 0 {
+		types = confighandlers.QueryTypes
+	}
+	for _, name := range types {
+		if _, ok := recordTypes[name]; !ok { // no known query type fallback error handling.
+			return nil, fmt.Errorf(qTypeErrMessage, name)
+		}
+	}
+	entries := cfg.Entries()
+	groups := make(map[string][]confighandlers.Entry)
+	for _, e := range entries {
+		if slices.Contains(types, e.Type) {
+			groups[e.Group] = append(groups[e.Group], e)
+		}
+	}
+	order := slices.Sorted(maps.Keys(groups))
+	plan := make([]planStep, 0, len(types))
+	for _, name := range types {
+		if !slices.ContainsFunc(entries, func(e confighandlers.Entry) bool { return e.Type == name }) {
+			plan = append(plan, planStep{rt: recordTypes[name]})
+		}
+	}
+	for _, priority := range confighandlers.Priorities(entries) {
+		for _, group := range order {
+			for _, name := range types {
+				step := planStep{rt: recordTypes[name], priority: priority, group: group}
+				for _, e := range groups[group] {
+					if e.Type == name && e.Priority == priority {
+						step.names = append(step.names, e.Name)
+					}
+				}
+				if len(step.names) > 0 {
+					plan = append(plan, step)
+				}
+			}
+		}
+	}
+	return plan, nil
+}
+
+// tiers returns the outcome of every priority of the plan from the highest, batches holds the
+// batch of each step that started.
+func (p *Preloader) tiers(plan []planStep, batches map[int]*batch) []summary.Tier {
+	tiers := make([]summary.Tier, 0)
+	for i, step := range plan {
+		if len(step.names) == 0 {
+			continue
+		}
+		t := slices.IndexFunc(tiers, func(tier summary.Tier) bool { return tier.Priority == step.priority })
+		if t < 0 {
+			tiers = append(tiers, summary.Tier{Priority: step.priority, Warmed: true})
+			t = len(tiers) - 1
+		}
+		tier := &tiers[t]
+		tier.Names += len(step.names)
+		b, ok := batches[i]
+		if !ok {
+			tier.Warmed = false
+			continue
+		}
+		failed, warmed, last := b.outcome()
+		tier.Failed += failed
+		tier.Warmed = tier.Warmed && warmed
+		tier.TimeToWarm = max(tier.TimeToWarm, last.Sub(p.start))
+	}
+	slices.SortFunc(tiers, func(a, b summary.Tier) int {
+		return b.Priority - a.Priority
+	})
+	return tiers
+}
+
+// ParseOrder parses a comma separated list of query types that run first, the types that are not
+// listed follow in the default order.
+func ParseOrder(s string) ([]string, error) {
+	order := make([]string, 0, len(confighandlers.QueryTypes))
+	if s != "" {
+		for _, name := range strings.Split(s, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if _, ok := recordTypes[name]; !ok {
+				return nil, fmt.Errorf("unknown query type %s in --order, expected %s", name, strings.Join(confighandlers.QueryTypes, ", "))
+			}
+			if !slices.Contains(order, name) {
+				order = append(order, name)
+			}
+		}
+	}
+	for _, name := range confighandlers.QueryTypes {
+		if !slices.Contains(order, name) {
+			order = append(order, name)
+		}
+	}
+	return order, nil
+}
+
+// runPlan runs every step of the plan through the shared pool of workers and returns the failures
+// of all of them as a single error. The steps start in order so the lookups of an earlier step are
+// given workers first, a step starts while the previous one is finishing unless Options.Sleep is set
+// in which case each step completes and the run sleeps before the next one starts. Once a step is
+// aborted by the error policy the remaining steps do not start, once ctx is done their names are skipped.
+// The time to warm of each priority is kept for the summary of the run.
+func (p *Preloader) runPlan(ctx context.Context, plan []planStep) error {
+	batches := make([]*batch, 0, len(plan))
+	started := make(map[int]*batch, len(plan))
+	defer func() {
+		p.warm = p.tiers(plan, started)
+	}()
+	errs := make([]error, 0, len(plan))
+	for i, step := range plan {
+		if slices.ContainsFunc(batches, (*batch).aborted) {
+			break
+		}
+		if ctx.Err() != nil {
+			for _, name := range step.names {
+				errs = append(errs, p.skip(withGroup(ctx, step.group), name, step.rt.qtype, nil))
+			}
+			continue
+		}
+		if len(step.names) == 0 {
+			p.debugf(qTypeEmptyErrMessage, step.rt.name)
+			continue
+		}
+		event := Event{Kind: EventTypeStart, QType: step.rt.qtype, Names: step.names, Priority: step.priority, Group: step.group}
+		if err := p.emit(event); err != nil {
+			return err
+		}
+		b := p.submit(withGroup(ctx, step.group), step.rt, step.names)
+		b.priority, b.group = step.priority, step.group
+		started[i] = b
+		if p.opts.Sleep <= 0 {
+			batches = append(batches, b)
+			continue
+		}
+		err := p.finish(b)
+		errs = append(errs, err)
+		if (err != nil && StopRun(err)) || i == len(plan)-1 {
+			break
+		}
+		// a small sleep so that the queries can complete before the next batch.
+		select {
+		case <-time.After(p.opts.Sleep):
+		case <-ctx.Done():
+		}
+	}
+	for _, b := range batches {
+		errs = append(errs, p.finish(b))
+	}
+	return JoinErrors(errs...)
+}
@@ -0,0 +1,207 @@
+package preload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+)
+
+func TestParseOrder(t *testing.T) {
+	tests := []struct {
+		order   string
+		want    []string
+		wantErr bool
+	}{
+		{order: "", want: confighandlers.QueryTypes},
+		{order: "ptr, MX,ptr", want: []string{"ptr", "mx", "hosts", "cname", "ns", "txt", "srv", "https"}},
+		{order: "aaaa", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			got, err := ParseOrder(tt.order)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseOrder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreloaderRunPlan(t *testing.T) {
+	cfg := &confighandlers.Configuration{QueryType: confighandlers.QueryType{
+		Hosts: []string{testDomainNoErr, testDomainWithErr},
+		MX:    []string{testDomainNoErr},
+		TXT:   []string{testDomainWithErr},
+	}}
+	tests := []struct {
+		name       string
+		policy     ErrorPolicy
+		order      string
+		sleep      time.Duration
+		wantFailed int
+		wantTypes  int
+	}{
+		{
+			name:       "continue runs every type and combines the failures",
+			policy:     ErrorPolicy{mode: onErrorContinue},
+			wantFailed: 2,
+			wantTypes:  3,
+		},
+		{
+			name:       "abort stops the types that have not started",
+			policy:     ErrorPolicy{mode: onErrorAbort},
+			order:      "txt",
+			sleep:      time.Millisecond,
+			wantFailed: 1,
+			wantTypes:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := ParseOrder(tt.order)
+			if err != nil {
+				t.Fatalf("ParseOrder() error = %v", err)
+			}
+			p := New(Options{Workers: 2, Resolver: NewMockResolver(), Types: order, OnError: tt.policy, Sleep: tt.sleep})
+			result, err := p.Run(context.Background(), cfg)
+			var agg *AggregateError
+			if !errors.As(err, &agg) || len(agg.Errors) != tt.wantFailed {
+				t.Fatalf("expected %d failures got %v", tt.wantFailed, err)
+			}
+			if types := len(result.Summary.Types); types != tt.wantTypes {
+				t.Errorf("expected %d query types to run got %d", tt.wantTypes, types)
+			}
+		})
+	}
+}
+
+func TestPreloaderRunPriorities(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "priorities.yaml")
+	data := fmt.Sprintf(`version: 2
+names:
+  - name: %[1]s
+    types: [hosts]
+  - name: %[2]s
+    types: [txt]
+  - name: %[1]s
+    types: [mx, txt]
+    priority: 10
+`, testDomainNoErr, testDomainWithErr)
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := confighandlers.LoadConfigFromFile(&file)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	starts := make([]string, 0)
+	p := New(Options{Workers: 1, Resolver: NewMockResolver(), OnError: ErrorPolicy{mode: onErrorContinue}, OnEvent: func(ev Event) error {
+		if ev.Kind == EventTypeStart {
+			starts = append(starts, fmt.Sprintf("%d %s", ev.Priority, ev.QType))
+		}
+		return nil
+	}})
+	result, err := p.Run(context.Background(), cfg)
+	var agg *AggregateError
+	if !errors.As(err, &agg) || len(agg.Errors) != 1 {
+		t.Fatalf("expected a single failure got %v", err)
+	}
+	if want := []string{"10 MX", "10 TXT", "0 A, AAAA", "0 TXT"}; !reflect.DeepEqual(starts, want) {
+		t.Errorf("the query types started in the order %v, want %v", starts, want)
+	}
+	tiers := result.Summary.Tiers
+	if len(tiers) != 2 {
+		t.Fatalf("expected 2 tiers got %+v", tiers)
+	}
+	if tiers[0].Priority != 10 || tiers[0].Names != 2 || tiers[0].Failed != 0 || !tiers[0].Warmed {
+		t.Errorf("tier 10 = %+v", tiers[0])
+	}
+	if tiers[1].Priority != 0 || tiers[1].Names != 2 || tiers[1].Failed != 1 || !tiers[1].Warmed {
+		t.Errorf("tier 0 = %+v", tiers[1])
+	}
+	if tiers[0].TimeToWarm <= 0 || tiers[0].TimeToWarm > tiers[1].TimeToWarm {
+		t.Errorf("priority 10 warmed in %s after priority 0 in %s", tiers[0].TimeToWarm, tiers[1].TimeToWarm)
+	}
+
+	// without priorities the plan has a step per query type and the summary no tiers.
+	result, _ = p.Run(context.Background(), &confighandlers.Configuration{QueryType: confighandlers.QueryType{MX: []string{testDomainNoErr}}})
+	if result.Summary.Tiers != nil {
+		t.Errorf("expected no tiers without priorities got %+v", result.Summary.Tiers)
+	}
+}
+
+func TestPreloaderRunPrioritiesGroups(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "groups.yaml")
+	data := fmt.Sprintf(`version: 2
+names:
+  - name: %[1]s
+    types: [txt]
+groups:
+  work:
+    priority: 10
+    names:
+      - name: %[1]s
+        types: [hosts]
+      - name: %[1]s
+        types: [mx]
+        priority: 0
+  media:
+    priority: 5
+    names:
+      - name: %[1]s
+        types: [ns]
+`, testDomainNoErr)
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := confighandlers.LoadConfigFromFile(&file)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	starts := make([]string, 0)
+	p := New(Options{Workers: 1, Resolver: NewMockResolver(), OnEvent: func(ev Event) error {
+		if ev.Kind == EventTypeStart {
+			starts = append(starts, fmt.Sprintf("%d %s %s", ev.Priority, ev.Group, ev.QType))
+		}
+		return nil
+	}})
+	result, err := p.Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Preloader.Run() error = %v", err)
+	}
+	// the priorities are ordered across the groups, a name of a lower priority in the first group
+	// waits for the higher priorities of the other groups.
+	want := []string{"10 work A, AAAA", "5 media NS", "0  TXT", "0 work MX"}
+	if !reflect.DeepEqual(starts, want) {
+		t.Errorf("the names started in the order %v, want %v", starts, want)
+	}
+	tiers := result.Summary.Tiers
+	if len(tiers) != 3 || tiers[0].Priority != 10 || tiers[1].Priority != 5 || tiers[2].Priority != 0 || tiers[2].Names != 2 {
+		t.Errorf("expected a tier for each priority across the groups, got %+v", tiers)
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	s := make(semaphore, 1)
+	if err := s.Acquire(context.Background()); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Acquire(ctx); err == nil {
+		t.Errorf("Acquire() of a full semaphore expected the context error")
+	}
+	s.Release()
+	if err := s.Acquire(context.Background()); err != nil {
+		t.Errorf("Acquire() after Release() error = %v", err)
+	}
+}
@@ -0,0 +1,378 @@
+// Package preload seeds the cache of a DNS server by looking up every name of a configuration
+// against it. It is the engine of the dns-preload command and can be embedded in other programs.
+package preload
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/adaptive"
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+	"github.com/jimmystewpot/dns-preload/pkg/summary"
+)
+
+const (
+	// DefaultWorkers is the number of concurrent lookups when Options.Workers is not set.
+	DefaultWorkers int = 2
+	// DefaultTimeout bounds each lookup when Options.Timeout is not set.
+	DefaultTimeout time.Duration = 30 * time.Second
+	// MaxWorkers is the largest number of concurrent lookups.
+	MaxWorkers int = adaptive.DefaultMax
+	// DefaultSlowest is the number of slowest lookups in the summary when Options.Slowest is not set.
+	DefaultSlowest int = 5
+	// messages for the query types of a plan.
+	qTypeEmptyErrMessage string = "Preloading error: query type %s has no entries in the configuration"
+	qTypeErrMessage      string = "preloading error: query type %s is not a valid query type"
+)
+
+// Options configures a Preloader, zero values use the defaults.
+//
+//nolint:govet // fieldalignment is not required here.
+type Options struct {
+	// Nameserver is the host:port of the server to preload, localhost:53 when empty.
+	Nameserver string
+	// Resolver replaces the resolver for Nameserver, the retries and rate limits still apply.
+	Resolver dns.CustomResolver
+	// Types are the query types to run in the order they start, every type when empty, see ParseOrder.
+	Types []string
+	// Timeout bounds the time each lookup spends querying including its retries, the waits for the
+	// rate limits and the backoff between the retries are not counted.
+	Timeout time.Duration
+	// Workers is the number of concurrent lookups, AutoWorkers adapts it to the latency and errors.
+	Workers     int
+	AutoWorkers bool
+	// Full preloads the names the answers depend on: the addresses of the CNAME names and of the MX,
+	// NS, SRV and HTTPS targets. The addresses are taken from the A and AAAA records so that a name
+	// that is a CNAME is followed one hop per level, up to MaxDepth levels below the configuration.
+	Full     bool
+	MaxDepth int
+	// ForwardPTR preloads the addresses of the names returned by the PTR lookups with Full.
+	ForwardPTR bool
+	// MaxRuntime bounds the whole run, the lookups that have not completed by then are skipped and
+	// Run returns a CancelledError. Zero is unlimited.
+	MaxRuntime time.Duration
+	// Sleep makes each query type complete and sleeps before the next one starts, by default the
+	// query types share the workers without a pause.
+	Sleep time.Duration
+	// OnError decides when the lookups of a query type stop after a failure.
+	OnError ErrorPolicy
+	// Actions sets what happens to each class of failure.
+	Actions ErrorActions
+	// Retry is the retry policy, its Retryable defaults to Actions.Retryable.
+	Retry dns.RetryPolicy
+	// RateLimits are the token buckets every query waits for, ServerRateLimits are the token buckets
+	// only the queries sent to Nameserver wait for.
+	RateLimits       []*dns.TokenBucket
+	ServerRateLimits []*dns.TokenBucket
+	// Routes send the lookups of the names they match to their own resolver instead of Nameserver,
+	// the first route that matches a name wins. The lookups of dependencies are routed by their own name.
+	Routes []dns.Route
+	// Groups holds the settings of the names of the groups of the configuration by group name, the
+	// names outside of the groups use the settings above. The rate limits and retry budget of the
+	// run are shared by every group, the memo by the groups sent to the same server.
+	Groups map[string]GroupOptions
+	// Slowest is the number of slowest lookups listed in the summary.
+	Slowest int
+	// OnEvent is called for every event of the run one at a time, an error stops the run.
+	OnEvent func(Event) error
+	// Events receives every event of the run when it is not nil, the run blocks while the channel
+	// is full until the context of the run is done and the channel is not closed when the run ends.
+	Events chan<- Event
+	// Debugf receives the debug messages, nil discards them.
+	Debugf func(format string, args ...any)
+}
+
+// Result is the outcome of a run, the summary has the time to warm of every priority when the
+// names of the configuration have priorities.
+type Result struct {
+	// Start is the time the run started.
+	Start   time.Time
+	Summary *summary.Summary
+	// Lookups are the lookup and skip events in the order they completed.
+	Lookups []Event
+	// Retries made during the run and the retries that were not made because the budget was spent.
+	Retries          int64
+	RetriesExhausted int64
+	// Avoided is the number of lookups answered by an identical lookup in flight or earlier in the run.
+	Avoided int64
+	// Tree holds the lookups of the configuration that pulled in dependencies with Options.Full.
+	Tree []*Node
+	// Tiers are the outcome of the names of each priority, a single tier without priorities.
+	Tiers []summary.Tier
+}
+
+// Preloader looks up the names of a configuration, the retry budget, rate limits and adaptive
+// workers are shared by every run of the same Preloader. Runs of the same Preloader must not overlap.
+//
+//nolint:govet // fieldalignment is not required here.
+type Preloader struct {
+	opts     Options
+	resolver dns.CustomResolver
+	router   *dns.RouteResolver
+	retry    *dns.RetryResolver
+	dedup    *dns.DedupResolver
+	workers  *adaptive.Controller
+	pool     pool
+	// pools holds the workers of the groups with their own number of workers.
+	pools map[string]pool
+	// since measures the duration of a lookup and elapsed the time it spent querying for the
+	// adaptive workers, tests replace them for fixed durations.
+	since   func(time.Time) time.Duration
+	elapsed func(ctx context.Context) (time.Duration, bool)
+	// deliver is held while an event is delivered so the events are delivered one at a time, done
+	// is closed when the context of the run is done.
+	deliver chan struct{}
+	done    <-chan struct{}
+	// mu guards the result of the run.
+	mu      sync.Mutex
+	record  *summary.Recorder
+	lookups []Event
+	// files holds the file each name of the configuration is listed in by query type and name.
+	files map[string]string
+	// treeMu guards the dependency tree of the run.
+	treeMu sync.Mutex
+	tree   []*Node
+	// start of the run and the time to warm of each priority.
+	start time.Time
+	warm  []summary.Tier
+}
+
+// New returns a Preloader for opts.
+func New(opts Options) *Preloader {
+	if opts.Nameserver == "" {
+		opts.Nameserver = net.JoinHostPort("localhost", "53")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Workers < 1 {
+		opts.Workers = DefaultWorkers
+	}
+	opts.Workers = min(opts.Workers, MaxWorkers)
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = DefaultMaxDepth
+	}
+	if opts.Slowest <= 0 {
+		opts.Slowest = DefaultSlowest
+	}
+	if opts.Actions == nil {
+		opts.Actions = ErrorActions{}
+	}
+	if opts.Retry.Retryable == nil {
+		opts.Retry.Retryable = opts.Actions.Retryable
+	}
+	p := &Preloader{opts: opts, record: summary.NewRecorder(), since: time.Since, elapsed: dns.Elapsed, deliver: make(chan struct{}, 1)}
+	resolver := opts.Resolver
+	if resolver == nil {
+		resolver = dns.NewResolver(opts.Nameserver, opts.Timeout)
+	}
+	// the rate limits sit right above the resolver of each server, below the routes, groups and
+	// retries, so every attempt waits for the limits of the run and of its server and the timeout
+	// only starts once the tokens are taken. The lookups that are deduplicated skip them.
+	resolver = dns.NewRateLimitResolver(resolver, p.rateLimits(opts.ServerRateLimits)...)
+	if len(opts.Groups) > 0 {
+		resolver = p.newGroupResolver(resolver)
+	}
+	if len(opts.Routes) > 0 {
+		routes := make([]dns.Route, 0, len(opts.Routes))
+		for _, route := range opts.Routes {
+			route.RateLimits = p.rateLimits(route.RateLimits)
+			routes = append(routes, route)
+		}
+		p.router = dns.NewRouteResolver(resolver, routes...)
+		resolver = p.router
+	}
+	p.retry = dns.NewRetryResolver(resolver, opts.Retry)
+	p.dedup = dns.NewDedupResolver(p.retry)
+	p.resolver = p.dedup
+	if opts.AutoWorkers {
+		p.workers = adaptive.New(adaptive.Config{Logf: opts.Debugf})
+	}
+	p.pool = p.newPool()
+	p.pools = p.groupPools()
+	return p
+}
+
+// Run preloads the names of cfg and returns the result, the error lists every failed lookup as an
+// AggregateError. The answers are memoized for the run so each name and record type is queried once per server.
+// Once ctx is done, or Options.MaxRuntime has passed, the lookups in flight are
+// cancelled, the remaining names are skipped and the error is a CancelledError. The result is
+// returned even when the run fails, its summary is marked as partial when the run was cancelled.
+func (p *Preloader) Run(ctx context.Context, cfg *confighandlers.Configuration) (*Result, error) {
+	start := time.Now()
+	p.start, p.warm = start, nil
+	// the events are still delivered after Options.MaxRuntime, only the caller stops the delivery.
+	p.done = ctx.Done()
+	if p.opts.MaxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, p.opts.MaxRuntime, ErrMaxRuntime)
+		defer cancel()
+	}
+	p.mu.Lock()
+	p.record = summary.NewRecorder()
+	p.lookups = make([]Event, 0)
+	p.files = filesOf(cfg)
+	p.mu.Unlock()
+	p.treeMu.Lock()
+	p.tree = make([]*Node, 0)
+	p.treeMu.Unlock()
+	p.dedup.Reset()
+	retries, exhausted, avoided := p.retry.Retries(), p.retry.BudgetExhausted(), p.dedup.Avoided()
+
+	plan, err := p.newPlan(cfg)
+	if err == nil {
+		err = p.runPlan(ctx, plan)
+	}
+	if p.workers != nil {
+		p.debugf("adaptive workers finished at %s", p.workers)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := &Result{
+		Start:            start,
+		Summary:          p.record.Summary(time.Since(start), p.opts.Slowest),
+		Lookups:          p.lookups,
+		Retries:          p.retry.Retries() - retries,
+		RetriesExhausted: p.retry.BudgetExhausted() - exhausted,
+		Avoided:          p.dedup.Avoided() - avoided,
+		Tree:             p.dependencyTree(),
+		Tiers:            p.warm,
+	}
+	result.Summary.Avoided = result.Avoided
+	if summary.Prioritized(result.Tiers) {
+		result.Summary.Tiers = result.Tiers
+	}
+	if ctx.Err() != nil {
+		cause := context.Cause(ctx)
+		result.Summary.Partial = cause.Error()
+		err = &CancelledError{Cause: cause, Err: err}
+	}
+	return result, err
+}
+
+// rateLimits returns the token buckets of the queries sent to a server with the limits of server.
+func (p *Preloader) rateLimits(server []*dns.TokenBucket) []*dns.TokenBucket {
+	return append(slices.Clone(p.opts.RateLimits), server...)
+}
+
+// filesOf returns the file each name of cfg is listed in by query type and name, the first file
+// wins for a name listed in several of them.
+func filesOf(cfg *confighandlers.Configuration) map[string]string {
+	files := make(map[string]string)
+	for _, e := range cfg.Entries() {
+		key := e.Type + " " + normalize(e.Name)
+		if _, ok := files[key]; !ok && e.File != "" {
+			files[key] = e.File
+		}
+	}
+	return files
+}
+
+// preload looks up every host as the record type rt in a single batch.
+func (p *Preloader) preload(ctx context.Context, rt *recordType, hosts []string) error {
+	return p.finish(p.submit(ctx, rt, hosts))
+}
+
+// submit starts the lookups of every host as the record type rt, it returns once the last lookup
+// has a worker.
+func (p *Preloader) submit(ctx context.Context, rt *recordType, hosts []string) *batch {
+	b := p.newBatch(ctx, rt.qtype, len(hosts))
+	timeout := p.timeout(ctx)
+	for _, host := range hosts {
+		b.Go(host, func(batchCtx context.Context) error {
+			node := p.newNode(batchCtx, rt, host)
+			s := time.Now()
+			// the lookups of a name are only shared by the lookups sent to the same server.
+			lookupCtx := dns.WithScope(dns.WithAttempts(batchCtx), p.server(batchCtx, host))
+			lookupCtx = dns.WithTimeout(lookupCtx, timeout)
+			answers, records, err := rt.lookupWith(&p.opts)(lookupCtx, p.resolver, host)
+			p.complete(node, answers, err)
+			if batchCtx.Err() == nil {
+				p.observe(lookupCtx, err)
+			}
+			switch {
+			case err != nil && batchCtx.Err() != nil:
+				// the lookup was interrupted because the run was cancelled or the batch aborted.
+				return b.skip(host)
+			case err != nil:
+				return p.lookupFailed(batchCtx, node, host, rt.qtype, p.since(s), dns.Attempts(lookupCtx), err)
+			}
+			return p.succeeded(batchCtx, node, host, rt, p.since(s), dns.Attempts(lookupCtx), answers, records)
+		})
+	}
+	return b
+}
+
+// observe reports the time the lookup of ctx spent querying to the adaptive workers, the lookups
+// answered by an identical lookup in flight or by the memo sent no query and are left out.
+func (p *Preloader) observe(ctx context.Context, err error) {
+	if elapsed, ok := p.elapsed(ctx); ok {
+		p.workers.Observe(elapsed, err != nil && dns.Classify(err).Retryable())
+	}
+}
+
+// finish waits for the lookups of a batch to complete.
+func (p *Preloader) finish(b *batch) error {
+	// wait for all of the goroutines in the error group to complete, any errors are handled uniformly.
+	if err := b.Wait(); err != nil {
+		return err
+	}
+	return p.emit(Event{Kind: EventTypeDone, QType: b.qtype, Duration: time.Since(b.start), Priority: b.priority, Group: b.group})
+}
+
+// succeeded sends the event for a lookup that returned answers, with Options.Full the names the
+// answers depend on are preloaded first. ctx is the context of the batch so the lookups of the
+// dependencies are cancelled with it. The event is sent when a dependency fails as well, the
+// failures of the dependencies are returned with the error of the event.
+func (p *Preloader) succeeded(ctx context.Context, node *Node, hostname string, rt *recordType, duration time.Duration, attempts int, answers []string, records []dns.Record) error {
+	var depErr error
+	if node != nil && rt.deps != nil {
+		depErr = p.resolveDeps(ctx, node, rt.deps(hostname, answers, records, &p.opts))
+	}
+	return JoinErrors(p.emit(Event{
+		Kind:     EventLookup,
+		Name:     hostname,
+		QType:    rt.qtype,
+		Duration: duration,
+		Attempts: attempts,
+		Answers:  answers,
+		Records:  records,
+		Depth:    node.Depth(),
+		Server:   p.server(ctx, hostname),
+	}), depErr)
+}
+
+// lookupFailed sends the event for a failed query and returns the original error, failures of a
+// class that is skipped are sent as skipped and nil is returned.
+func (p *Preloader) lookupFailed(ctx context.Context, node *Node, hostname string, qtype string, duration time.Duration, attempts int, err error) error {
+	ev := Event{
+		Kind:     EventLookup,
+		Name:     hostname,
+		QType:    qtype,
+		Duration: duration,
+		Attempts: attempts,
+		Err:      err,
+		Depth:    node.Depth(),
+		Server:   p.server(ctx, hostname),
+	}
+	if p.opts.Actions.Skip(dns.Classify(err)) {
+		ev.Kind = EventSkip
+		return p.emit(ev)
+	}
+	if emitErr := p.emit(ev); emitErr != nil {
+		return emitErr
+	}
+	return err
+}
+
+// debugf sends a debug message to Options.Debugf.
+func (p *Preloader) debugf(format string, args ...any) {
+	if p.opts.Debugf != nil {
+		p.opts.Debugf(format, args...)
+	}
+}
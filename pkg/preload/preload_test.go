@@ -0,0 +1,680 @@
+package preload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/adaptive"
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+)
+
+const (
+	testDomainNoErr   string = "foo.bar"
+	testDomainWithErr string = "bar.foo"
+	googlePubDNS1     string = "8.8.4.4"
+	googlePubDNS2     string = "8.8.8.8"
+	googleIpv6        string = "2404:6800:4006:804::200e"
+	testDNSServer     string = "9.9.9.9"
+	testDNSServerPort string = "53"
+	nxDomainErr       string = "nxdomain %s"
+	testDomainMX0     string = "mx0.foo.bar"
+	testDomainMX1     string = "mx1.foo.bar"
+	testDomainNS1     string = "ns1.foo.bar"
+	testDomainSRV     string = "_sip._tcp.foo.bar"
+	testDomainChain   string = "chain.foo.bar"
+	testDomainLoop    string = "loop.foo.bar"
+	testDomainLoop2   string = "loop2.foo.bar"
+)
+
+// NewMockResolver returns the mock resolver.
+func NewMockResolver() *Mockresolver {
+	return &Mockresolver{}
+}
+
+// Mock the above resolver interface
+type Mockresolver struct{}
+
+func (m *Mockresolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	defer ctx.Done()
+	switch host {
+	case "www.foo.bar":
+		return testDomainNoErr, nil
+	case "www.bar.foo":
+		return "", fmt.Errorf(nxDomainErr, host)
+	}
+	return "", fmt.Errorf(nxDomainErr, host)
+}
+
+func (m *Mockresolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	defer ctx.Done()
+	switch host {
+	case testDomainNoErr, testDomainMX0, testDomainNS1, "www.foo.bar", testDomainChain:
+		ip1 := net.ParseIP(googlePubDNS1)
+		return []net.IPAddr{
+			{
+				IP: ip1,
+			},
+		}, nil
+	case "ns2.foo.bar":
+		ip2 := net.ParseIP(googlePubDNS2)
+		return []net.IPAddr{
+			{
+				IP: ip2,
+			},
+		}, nil
+	case "dns.oranged.to":
+		ip1 := net.ParseIP(googlePubDNS1)
+		ip2 := net.ParseIP(googlePubDNS2)
+		return []net.IPAddr{
+			{
+				IP: ip1,
+			},
+			{
+				IP: ip2,
+			},
+		}, nil
+	case testDomainWithErr:
+		return []net.IPAddr{}, fmt.Errorf(nxDomainErr, host)
+	}
+	return []net.IPAddr{}, fmt.Errorf(nxDomainErr, host)
+}
+
+func (m *Mockresolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	defer ctx.Done()
+	if addr != googleIpv6 {
+		return []string{}, fmt.Errorf("%s ptr not found", addr)
+	}
+	return []string{"ipv6.google.com"}, nil
+}
+
+//nolint:gocritic // uses switch to expand on test cases in the future.
+func (m *Mockresolver) LookupMX(ctx context.Context, host string) ([]*net.MX, error) {
+	defer ctx.Done()
+	switch host {
+	case testDomainNoErr:
+		return []*net.MX{
+			{
+				Host: testDomainMX0,
+				Pref: 10,
+			},
+			{
+				Host: testDomainMX1,
+				Pref: 10,
+			},
+		}, nil
+	}
+	return []*net.MX{}, fmt.Errorf(nxDomainErr, host)
+}
+
+//nolint:gocritic // uses switch to expand on test cases in the future.
+func (m *Mockresolver) LookupTXT(ctx context.Context, host string) ([]string, error) {
+	defer ctx.Done()
+	switch host {
+	case testDomainNoErr:
+		return []string{
+			"v=spf1 -all",
+		}, nil
+	}
+	return []string{}, fmt.Errorf(nxDomainErr, host)
+}
+
+//nolint:gocritic // uses switch to expand on test cases in the future.
+func (m *Mockresolver) LookupNS(ctx context.Context, host string) ([]*net.NS, error) {
+	defer ctx.Done()
+	switch host {
+	case testDomainNoErr:
+		return []*net.NS{
+			{
+				Host: "ns1.foo.bar",
+			},
+			{
+				Host: "ns2.foo.bar",
+			},
+		}, nil
+	}
+	return []*net.NS{}, fmt.Errorf(nxDomainErr, host)
+}
+
+func (m *Mockresolver) LookupRecords(ctx context.Context, name string, qtype dns.Type) ([]dns.Record, error) {
+	defer ctx.Done()
+	record := func(owner string, t dns.Type, target string) dns.Record {
+		return dns.Record{Name: owner, Type: t, TTL: 300, Target: target, Data: target}
+	}
+	address := dns.Record{Name: testDomainNoErr, Type: dns.TypeA, TTL: 60, Data: googlePubDNS1}
+	switch {
+	case name == testDomainWithErr || name == "www.bar.foo":
+		return nil, &dns.ResponseError{Name: name, Rcode: dns.RcodeNameError}
+	case qtype == dns.TypeA && name == "www.foo.bar":
+		return []dns.Record{record(name, dns.TypeCNAME, testDomainNoErr), address}, nil
+	case qtype == dns.TypeA && name == testDomainNoErr:
+		return []dns.Record{address}, nil
+	// the answers for the chain and the loop stop after the first hop like a resolver that limits
+	// the length of a chain.
+	case qtype == dns.TypeA && name == testDomainChain:
+		return []dns.Record{record(name, dns.TypeCNAME, "www.foo.bar")}, nil
+	case qtype == dns.TypeA && name == testDomainLoop:
+		return []dns.Record{record(name, dns.TypeCNAME, testDomainLoop2)}, nil
+	case qtype == dns.TypeA && name == testDomainLoop2:
+		return []dns.Record{record(name, dns.TypeCNAME, testDomainLoop)}, nil
+	case qtype == dns.TypeSRV && name == testDomainSRV:
+		return []dns.Record{record(name, qtype, testDomainMX0)}, nil
+	case qtype == dns.TypeHTTPS && name == testDomainNoErr:
+		return []dns.Record{record(name, qtype, ".")}, nil
+	case qtype == dns.TypeA || qtype == dns.TypeAAAA:
+		return addressRecords(ctx, m, name, qtype)
+	}
+	return nil, &dns.ResponseError{Name: name, NoData: true}
+}
+
+// addressRecords answers the A and AAAA queries of the mock with the addresses of LookupIPAddr.
+func addressRecords(ctx context.Context, r dns.CustomResolver, name string, qtype dns.Type) ([]dns.Record, error) {
+	addrs, err := r.LookupIPAddr(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]dns.Record, 0, len(addrs))
+	for _, addr := range addrs {
+		if (addr.IP.To4() != nil) == (qtype == dns.TypeA) {
+			records = append(records, dns.Record{Name: name, Type: qtype, TTL: 60, Data: addr.IP.String()})
+		}
+	}
+	if len(records) == 0 {
+		return nil, &dns.ResponseError{Name: name, NoData: true}
+	}
+	return records, nil
+}
+
+func TestPreloaderTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		qtype   string
+		full    bool
+		hosts   []string
+		wantErr bool
+	}{
+		{name: "Hosts Test Case Without Error", qtype: confighandlers.Hosts, hosts: []string{testDomainNoErr}},
+		{name: "Hosts Test Case With Error", qtype: confighandlers.Hosts, hosts: []string{testDomainWithErr}, wantErr: true},
+		{name: "PTR Test Case Without Error", qtype: confighandlers.Ptr, hosts: []string{googleIpv6}},
+		{name: "PTR Test Case With Error", qtype: confighandlers.Ptr, hosts: []string{testDomainNoErr}, wantErr: true},
+		{name: "IN MX", qtype: confighandlers.Mx, hosts: []string{testDomainNoErr}},
+		{name: "IN MX full recursion with error", qtype: confighandlers.Mx, full: true, hosts: []string{testDomainNoErr}, wantErr: true},
+		{name: "IN MX with Error", qtype: confighandlers.Mx, hosts: []string{testDomainWithErr}, wantErr: true},
+		{name: "IN TXT", qtype: confighandlers.Txt, full: true, hosts: []string{testDomainNoErr}},
+		{name: "IN TXT error", qtype: confighandlers.Txt, hosts: []string{testDomainWithErr}, wantErr: true},
+		{name: "IN NS", qtype: confighandlers.Ns, full: true, hosts: []string{testDomainNoErr}},
+		{name: "IN NS error", qtype: confighandlers.Ns, hosts: []string{testDomainWithErr}, wantErr: true},
+		{name: "IN CNAME", qtype: confighandlers.Cname, full: true, hosts: []string{"www.foo.bar"}},
+		{name: "IN CNAME error", qtype: confighandlers.Cname, hosts: []string{"www.bar.foo"}, wantErr: true},
+		{name: "IN CNAME end of the chain", qtype: confighandlers.Cname, hosts: []string{testDomainNoErr}},
+		{name: "IN CNAME chain", qtype: confighandlers.Cname, full: true, hosts: []string{testDomainChain}},
+		{name: "IN CNAME loop", qtype: confighandlers.Cname, full: true, hosts: []string{testDomainLoop}},
+		{name: "IN SRV full", qtype: confighandlers.Srv, full: true, hosts: []string{testDomainSRV}},
+		{name: "IN SRV error", qtype: confighandlers.Srv, hosts: []string{testDomainNoErr}, wantErr: true},
+		{name: "IN HTTPS full", qtype: confighandlers.HTTPS, full: true, hosts: []string{testDomainNoErr}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(Options{
+				Nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
+				Resolver:   NewMockResolver(),
+				Workers:    1,
+				Full:       tt.full,
+			})
+			if err := p.preload(context.Background(), recordTypes[tt.qtype], tt.hosts); (err != nil) != tt.wantErr {
+				t.Errorf("Preloader.preload(%s) error = %v, wantErr %v", tt.qtype, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPreloaderRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		configFile string
+		types      []string
+		wantErr    bool
+	}{
+		{name: "good config test - cname", configFile: "basic_test_data_config.yaml", types: []string{confighandlers.Cname}},
+		{name: "good config test - hosts", configFile: "basic_test_data_config.yaml", types: []string{confighandlers.Hosts}},
+		{name: "good config test - txt", configFile: "basic_test_data_config.yaml", types: []string{confighandlers.Txt}},
+		{name: "good config test - mx", configFile: "basic_test_data_config.yaml", types: []string{confighandlers.Mx}},
+		{name: "good config test - ns", configFile: "basic_test_data_config.yaml", types: []string{confighandlers.Ns}},
+		{name: "good config test - wrong cmd", configFile: "basic_test_data_config.yaml", types: []string{"foo"}, wantErr: true},
+		{name: "good config test - cname with no entries", configFile: "basic_test_no_cname_config.yaml", types: []string{confighandlers.Cname}},
+		{name: "good config test - ptr with entries", configFile: "basic_test_data_config.yaml", types: []string{confighandlers.Ptr}},
+		{name: "good config test - ptr with no entries", configFile: "basic_test_no_cname_config.yaml", types: []string{confighandlers.Ptr}},
+		{name: "good config test - srv", configFile: "basic_test_data_config.yaml", types: []string{confighandlers.Srv}},
+		{name: "good config test - https", configFile: "basic_test_data_config.yaml", types: []string{confighandlers.HTTPS}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgFile := "../confighandlers/test_data/" + tt.configFile
+			cfg, err := confighandlers.LoadConfigFromFile(&cfgFile)
+			if err != nil {
+				t.Fatalf("LoadConfigFromFile() error = %v", err)
+			}
+			var debug []string
+			p := New(Options{
+				Nameserver: net.JoinHostPort(testDNSServer, testDNSServerPort),
+				Resolver:   NewMockResolver(),
+				Workers:    1,
+				Types:      tt.types,
+				Debugf:     func(format string, args ...any) { debug = append(debug, fmt.Sprintf(format, args...)) },
+			})
+			result, err := p.Run(context.Background(), cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Preloader.Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result == nil || result.Summary == nil {
+				t.Fatalf("Preloader.Run() must return a result")
+			}
+			if len(result.Lookups) == 0 && len(debug) == 0 && !tt.wantErr {
+				t.Errorf("expected lookups or a debug message for an empty query type")
+			}
+		})
+	}
+}
+
+func TestPreloaderEvents(t *testing.T) {
+	cfg := &confighandlers.Configuration{QueryType: confighandlers.QueryType{NS: []string{testDomainNoErr}}}
+	events := make(chan Event, 16)
+	kinds := make([]EventKind, 0)
+	p := New(Options{
+		Resolver: NewMockResolver(),
+		Types:    []string{confighandlers.Ns},
+		Full:     true,
+		Events:   events,
+		OnEvent: func(ev Event) error {
+			kinds = append(kinds, ev.Kind)
+			return nil
+		},
+	})
+	result, err := p.Run(context.Background(), cfg)
+	close(events)
+	if err != nil {
+		t.Fatalf("Preloader.Run() error = %v", err)
+	}
+	// the ns lookup is sent after the follow up lookups of the addresses of its targets.
+	want := []EventKind{EventTypeStart, EventLookup, EventLookup, EventTypeDone, EventLookup, EventTypeDone}
+	if fmt.Sprint(kinds) != fmt.Sprint(want) {
+		t.Errorf("OnEvent() received %v, want %v", kinds, want)
+	}
+	received := make([]EventKind, 0)
+	for ev := range events {
+		received = append(received, ev.Kind)
+	}
+	if fmt.Sprint(received) != fmt.Sprint(want) {
+		t.Errorf("Events received %v, want %v", received, want)
+	}
+	if result.Summary.Total.Lookups != 3 || len(result.Lookups) != 3 {
+		t.Errorf("expected 3 lookups in the result, got %+v", result.Summary.Total)
+	}
+	if last := result.Lookups[len(result.Lookups)-1]; last.QType != QTypeNS || last.Name != testDomainNoErr {
+		t.Errorf("expected the ns lookup last, got %s %s", last.Name, last.QType)
+	}
+}
+
+func TestPreloaderOnEventError(t *testing.T) {
+	cfg := &confighandlers.Configuration{QueryType: confighandlers.QueryType{Hosts: []string{testDomainNoErr}}}
+	stop := fmt.Errorf("stop")
+	p := New(Options{
+		Resolver: NewMockResolver(),
+		OnEvent:  func(Event) error { return stop },
+	})
+	if _, err := p.Run(context.Background(), cfg); err != stop {
+		t.Errorf("expected the OnEvent error to stop the run got %v", err)
+	}
+}
+
+func TestPreloaderRunFailedDependency(t *testing.T) {
+	// mx1.foo.bar has no addresses, the mx lookup that pulled it in still succeeded.
+	p := New(Options{Resolver: NewMockResolver(), Full: true, OnError: ErrorPolicy{mode: onErrorContinue}})
+	cfg := &confighandlers.Configuration{QueryType: confighandlers.QueryType{MX: []string{testDomainNoErr}}}
+	result, err := p.Run(context.Background(), cfg)
+	if err == nil {
+		t.Fatalf("Preloader.Run() expected the error of the failed dependency")
+	}
+	var mx *Event
+	for i, ev := range result.Lookups {
+		if ev.QType == QTypeMX {
+			mx = &result.Lookups[i]
+		}
+	}
+	if mx == nil || mx.Err != nil || len(mx.Answers) != 2 {
+		t.Fatalf("expected the successful mx lookup in the result, got %+v", result.Lookups)
+	}
+	if result.Summary.Total.Lookups != 3 || result.Summary.Total.Failed != 1 {
+		t.Errorf("expected 3 lookups with 1 failure in the summary, got %+v", result.Summary.Total)
+	}
+}
+
+func TestPreloaderRunEventsCancelled(t *testing.T) {
+	// nobody reads the events, the run must still end once its context is done.
+	events := make(chan Event)
+	p := New(Options{Resolver: NewMockResolver(), Types: []string{confighandlers.Hosts}, Events: events, OnError: ErrorPolicy{mode: onErrorContinue}})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	var result *Result
+	go func() {
+		defer close(done)
+		result, _ = p.Run(ctx, &confighandlers.Configuration{QueryType: confighandlers.QueryType{Hosts: []string{testDomainNoErr, googlePubDNS1}}})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Preloader.Run() did not return after its context was done")
+	}
+	if result.Summary.Partial == "" || len(result.Lookups) != 2 {
+		t.Errorf("expected a partial result with both names, got %+v", result.Lookups)
+	}
+}
+
+func TestPreloaderAutoWorkers(t *testing.T) {
+	p := New(Options{
+		Resolver:    NewMockResolver(),
+		AutoWorkers: true,
+		Full:        true,
+		Actions:     ErrorActions{dns.ClassUnknown: ActionSkip},
+	})
+	p.workers = adaptive.New(adaptive.Config{Initial: 1})
+	p.pool = p.newPool()
+	// every query takes the same time so the scheduler can not make the latency look like it rose.
+	p.elapsed = func(ctx context.Context) (time.Duration, bool) {
+		_, ok := dns.Elapsed(ctx)
+		return time.Millisecond, ok
+	}
+	hosts := []string{testDomainNoErr, testDomainNoErr, testDomainNoErr, testDomainNoErr}
+	if err := p.preload(context.Background(), recordTypes[confighandlers.Hosts], hosts); err != nil {
+		t.Fatalf("preload() error = %v", err)
+	}
+	// the follow up lookups of MX records run while their parent holds the only worker.
+	if err := p.preload(context.Background(), recordTypes[confighandlers.Mx], []string{testDomainNoErr}); err != nil {
+		t.Fatalf("preload() error = %v", err)
+	}
+	if p.workers.Limit() < 2 {
+		t.Errorf("expected healthy lookups to grow the limit, got %d", p.workers.Limit())
+	}
+}
+
+func TestPreloaderObserveQueries(t *testing.T) {
+	p := New(Options{
+		Resolver:   NewMockResolver(),
+		Workers:    1,
+		RateLimits: []*dns.TokenBucket{dns.NewTokenBucket(20, 1)},
+	})
+	var (
+		mu       sync.Mutex
+		observed []time.Duration
+	)
+	p.elapsed = func(ctx context.Context) (time.Duration, bool) {
+		elapsed, ok := dns.Elapsed(ctx)
+		if ok {
+			mu.Lock()
+			observed = append(observed, elapsed)
+			mu.Unlock()
+		}
+		return elapsed, ok
+	}
+	hosts := []string{testDomainNoErr, testDomainMX0, "FOO.BAR.", testDomainNoErr}
+	if err := p.preload(context.Background(), recordTypes[confighandlers.Hosts], hosts); err != nil {
+		t.Fatalf("preload() error = %v", err)
+	}
+	// the repeated names are answered by the memo, the wait of 100ms for the tokens of mx0.foo.bar
+	// is not part of the time of its query.
+	if len(observed) != 2 || slices.Max(observed) > 50*time.Millisecond {
+		t.Errorf("expected the time of the 2 queries without the rate limit, got %v", observed)
+	}
+}
+
+// blockingResolver answers like the Mockresolver but its address lookups wait for ctx to be done.
+type blockingResolver struct {
+	*Mockresolver
+}
+
+func (b blockingResolver) LookupIPAddr(ctx context.Context, _ string) ([]net.IPAddr, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (b blockingResolver) LookupRecords(ctx context.Context, _ string, _ dns.Type) ([]dns.Record, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestPreloaderRunCancelled(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		maxRuntime time.Duration
+		qtype      string
+		wantCode   int
+		wantSkip   int
+	}{
+		{name: "cancelled before the run", ctx: cancelled, qtype: confighandlers.Hosts, wantCode: ExitCodeCancelled, wantSkip: 1},
+		{name: "max runtime cancels the follow up lookups", ctx: context.Background(), maxRuntime: 20 * time.Millisecond,
+			qtype: confighandlers.Mx, wantCode: ExitCodeMaxRuntime, wantSkip: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &confighandlers.Configuration{QueryType: confighandlers.QueryType{
+				Hosts: []string{testDomainNoErr},
+				MX:    []string{testDomainNoErr},
+			}}
+			p := New(Options{
+				Resolver:   blockingResolver{NewMockResolver()},
+				Types:      []string{tt.qtype},
+				Timeout:    time.Minute,
+				Full:       true,
+				MaxRuntime: tt.maxRuntime,
+			})
+			result, err := p.Run(tt.ctx, cfg)
+			var cancelErr *CancelledError
+			if !errors.As(err, &cancelErr) {
+				t.Fatalf("Preloader.Run() error = %v, want a CancelledError", err)
+			}
+			if cancelErr.ExitCode() != tt.wantCode {
+				t.Errorf("ExitCode() = %d, want %d", cancelErr.ExitCode(), tt.wantCode)
+			}
+			if result.Summary.Partial == "" || result.Summary.Total.Skipped != tt.wantSkip || result.Summary.Total.Failed != 0 {
+				t.Errorf("unexpected partial summary %q %+v", result.Summary.Partial, result.Summary.Total)
+			}
+		})
+	}
+}
+
+func TestPreloaderRunAvoided(t *testing.T) {
+	cfg := &confighandlers.Configuration{QueryType: confighandlers.QueryType{
+		Hosts: []string{testDomainNoErr, testDomainMX0, "FOO.BAR."},
+		MX:    []string{testDomainNoErr},
+	}}
+	p := New(Options{
+		Resolver: NewMockResolver(),
+		Types:    []string{confighandlers.Hosts, confighandlers.Mx},
+		Full:     true,
+		OnError:  ErrorPolicy{mode: onErrorContinue},
+	})
+	for run := 0; run < 2; run++ {
+		result, err := p.Run(context.Background(), cfg)
+		if err == nil {
+			t.Fatalf("expected the failed follow up lookup of %s", testDomainMX1)
+		}
+		// FOO.BAR. repeats the A and AAAA queries of foo.bar and the follow up lookup of mx0.foo.bar
+		// those of the hosts entry, the memo of the previous run is not used.
+		if result.Avoided != 4 || result.Summary.Avoided != 4 {
+			t.Errorf("run %d avoided %d queries, want 4", run, result.Avoided)
+		}
+	}
+}
+
+// vpnResolver answers every address lookup with a private address.
+type vpnResolver struct {
+	*Mockresolver
+}
+
+func (v vpnResolver) LookupIPAddr(_ context.Context, _ string) ([]net.IPAddr, error) {
+	return []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}, nil
+}
+
+func (v vpnResolver) LookupRecords(ctx context.Context, name string, qtype dns.Type) ([]dns.Record, error) {
+	if qtype == dns.TypeA || qtype == dns.TypeAAAA {
+		return addressRecords(ctx, v, name, qtype)
+	}
+	return v.Mockresolver.LookupRecords(ctx, name, qtype)
+}
+
+func TestPreloaderRunRoutes(t *testing.T) {
+	p := New(Options{
+		Resolver: NewMockResolver(),
+		Workers:  1,
+		Full:     true,
+		Routes:   []dns.Route{{Name: "vpn", Match: dns.MatchSuffix(testDomainMX0), Resolver: vpnResolver{NewMockResolver()}}},
+	})
+	cfg := &confighandlers.Configuration{QueryType: confighandlers.QueryType{SRV: []string{testDomainSRV}}}
+	result, err := p.Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Preloader.Run() error = %v", err)
+	}
+	got := make(map[string]string)
+	for _, ev := range result.Lookups {
+		got[ev.QType+" "+ev.Name] = ev.Route + " " + fmt.Sprint(ev.Answers)
+	}
+	// the srv target is a dependency of the lookup and takes its own route.
+	want := map[string]string{
+		QTypeSRV + " " + testDomainSRV: "default [mx0.foo.bar]",
+		QTypeA + " " + testDomainMX0:   "vpn [10.0.0.1]",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Preloader.Run() looked up %v, want %v", got, want)
+	}
+	for name, w := range want {
+		if got[name] != w {
+			t.Errorf("lookup of %s = %s, want %s", name, got[name], w)
+		}
+	}
+}
+
+func TestPreloaderRunFiles(t *testing.T) {
+	dir := t.TempDir()
+	main, included := filepath.Join(dir, "main.yaml"), filepath.Join(dir, "mail.yaml")
+	if err := os.WriteFile(main, []byte("query_type:\n  hosts: [foo.bar]\ninclude: [mail.yaml]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(included, []byte("query_type:\n  mx: [foo.bar]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := confighandlers.LoadConfigFromFiles(main, "")
+	if err != nil {
+		t.Fatalf("LoadConfigFromFiles() error = %v", err)
+	}
+	result, _ := New(Options{Resolver: NewMockResolver(), Workers: 1, Full: true, OnError: ErrorPolicy{mode: onErrorContinue}}).Run(context.Background(), cfg)
+	want := map[string]string{"foo.bar A, AAAA": main, "foo.bar MX": included, "mx0.foo.bar A, AAAA": ""}
+	for _, ev := range result.Lookups {
+		if w, ok := want[ev.Name+" "+ev.QType]; ok && ev.File != w {
+			t.Errorf("lookup of %s %s came from %q, want %q", ev.Name, ev.QType, ev.File, w)
+		}
+	}
+}
+
+func TestPreloaderRunGroups(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "groups.yaml")
+	data := "query_type:\n  hosts: [foo.bar]\ngroups:\n  vpn:\n    query_type:\n      hosts: [mx0.foo.bar, foo.bar]\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := confighandlers.LoadConfigFromFile(&file)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	groups := make([]string, 0)
+	p := New(Options{
+		Resolver: NewMockResolver(),
+		Workers:  1,
+		Sleep:    time.Millisecond,
+		Groups:   map[string]GroupOptions{"vpn": {Resolver: vpnResolver{NewMockResolver()}, Workers: 2, Timeout: time.Second}},
+		OnEvent: func(ev Event) error {
+			if ev.Kind == EventTypeStart {
+				groups = append(groups, ev.Group)
+			}
+			return nil
+		},
+	})
+	result, err := p.Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Preloader.Run() error = %v", err)
+	}
+	if fmt.Sprint(groups) != fmt.Sprint([]string{"", "vpn"}) {
+		t.Errorf("expected the names outside of the groups first, got the groups %q", groups)
+	}
+	got := make(map[string]string)
+	for _, ev := range result.Lookups {
+		got[ev.Name] = fmt.Sprint(ev.Answers)
+	}
+	// foo.bar of the group is sent to the resolver of the group, the memo is not shared across servers.
+	if got[testDomainMX0] != "[10.0.0.1]" || got[testDomainNoErr] != "[10.0.0.1]" || result.Avoided != 0 {
+		t.Errorf("expected the group to use its resolver, got %v with %d avoided", got, result.Avoided)
+	}
+}
+
+// serverResolver records the names of the address lookups it answers.
+type serverResolver struct {
+	*Mockresolver
+	mu    sync.Mutex
+	names []string
+}
+
+func (s *serverResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	s.mu.Lock()
+	s.names = append(s.names, host)
+	s.mu.Unlock()
+	return s.Mockresolver.LookupIPAddr(ctx, host)
+}
+
+func TestPreloaderRunGroupServers(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "groups.yaml")
+	data := "groups:\n  a:\n    query_type:\n      hosts: [foo.bar]\n  b:\n    query_type:\n      hosts: [foo.bar]\n  c:\n    query_type:\n      hosts: [foo.bar]\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := confighandlers.LoadConfigFromFile(&file)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile() error = %v", err)
+	}
+	first, second := &serverResolver{Mockresolver: NewMockResolver()}, &serverResolver{Mockresolver: NewMockResolver()}
+	p := New(Options{
+		Resolver: NewMockResolver(),
+		Workers:  1,
+		Groups: map[string]GroupOptions{
+			"a": {Nameserver: "192.0.2.1:53", Resolver: first},
+			"b": {Nameserver: "192.0.2.2:53", Resolver: second},
+			"c": {Nameserver: "192.0.2.2:53", Resolver: second},
+		},
+	})
+	result, err := p.Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Preloader.Run() error = %v", err)
+	}
+	// the groups b and c share the server so the second of them is answered from the memo.
+	if len(first.names) != 1 || len(second.names) != 1 || result.Avoided != 1 {
+		t.Errorf("expected one query for each server and 1 avoided, got %q, %q and %d avoided", first.names, second.names, result.Avoided)
+	}
+	servers := make([]string, 0, len(result.Lookups))
+	for _, ev := range result.Lookups {
+		servers = append(servers, ev.Server)
+	}
+	slices.Sort(servers)
+	if want := []string{"192.0.2.1:53", "192.0.2.2:53", "192.0.2.2:53"}; !slices.Equal(servers, want) {
+		t.Errorf("expected the lookups to report the server of their group, got %q", servers)
+	}
+}
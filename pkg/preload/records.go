@@ -0,0 +1,229 @@
+package preload
+
+import (
+	"cmp"
+	"context"
+	"net"
+	"slices"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+)
+
+const (
+	// these const strings are the DNS query types shown in the events.
+	QTypeA     string = "A, AAAA"
+	QTypeCNAME string = "CNAME"
+	QTypeMX    string = "MX"
+	QTypeNS    string = "NS"
+	QTypeTXT   string = "TXT"
+	QTypePTR   string = "PTR"
+	QTypeSRV   string = "SRV"
+	QTypeHTTPS string = "HTTPS"
+	// maxChain bounds the hops of a CNAME chain.
+	maxChain int = 16
+)
+
+// recordType describes how a query type is preloaded, adding a query type only needs an entry in
+// recordTypes along with its list in the configuration file.
+type recordType struct {
+	// name is the command and configuration key of the type.
+	name string
+	// qtype is the query type shown in the output.
+	qtype string
+	// lookup queries the resolver and formats the answers, records are the answers with their TTL
+	// when the resolver reports them.
+	lookup func(ctx context.Context, r dns.CustomResolver, name string) (answers []string, records []dns.Record, err error)
+	// fullLookup replaces lookup with Options.Full when it is set, its records show the CNAME chain
+	// the dependencies follow.
+	fullLookup func(ctx context.Context, r dns.CustomResolver, name string) (answers []string, records []dns.Record, err error)
+	// deps returns the names the answers of name depend on, they are preloaded with Options.Full.
+	deps func(name string, answers []string, records []dns.Record, opts *Options) []dependency
+}
+
+// recordTypes is the registry of query types keyed by command name.
+var recordTypes = map[string]*recordType{
+	confighandlers.Hosts: newRecordType(confighandlers.Hosts, QTypeA,
+		dns.CustomResolver.LookupIPAddr, formatIPAddrs, hostsDeps).withFull(adapt(lookupAddrs, formatAddrs)),
+	confighandlers.Cname: newRecordType(confighandlers.Cname, QTypeCNAME,
+		lookupCNAME, formatTargets, cnameDeps),
+	confighandlers.Mx: newRecordType(confighandlers.Mx, QTypeMX,
+		dns.CustomResolver.LookupMX, formatMX, targetDeps),
+	confighandlers.Ns: newRecordType(confighandlers.Ns, QTypeNS,
+		dns.CustomResolver.LookupNS, formatNS, targetDeps),
+	confighandlers.Txt: newRecordType(confighandlers.Txt, QTypeTXT,
+		dns.CustomResolver.LookupTXT, formatStrings, nil),
+	confighandlers.Ptr: newRecordType(confighandlers.Ptr, QTypePTR,
+		dns.CustomResolver.LookupAddr, formatStrings, ptrDeps),
+	confighandlers.Srv: newRecordType(confighandlers.Srv, QTypeSRV,
+		lookupRecords(dns.TypeSRV), formatTargets, targetDeps),
+	confighandlers.HTTPS: newRecordType(confighandlers.HTTPS, QTypeHTTPS,
+		lookupRecords(dns.TypeHTTPS), formatTargets, httpsDeps),
+}
+
+// newRecordType builds a recordType from a resolver method and the formatter of its answers.
+func newRecordType[T any](name, qtype string,
+	lookup func(r dns.CustomResolver, ctx context.Context, name string) (T, error), format func(T) []string,
+	deps func(name string, answers []string, records []dns.Record, opts *Options) []dependency) *recordType {
+	return &recordType{
+		name:   name,
+		qtype:  qtype,
+		lookup: adapt(lookup, format),
+		deps:   deps,
+	}
+}
+
+// adapt returns the lookup of a recordType for a resolver method and the formatter of its answers.
+func adapt[T any](lookup func(r dns.CustomResolver, ctx context.Context, name string) (T, error),
+	format func(T) []string) func(ctx context.Context, r dns.CustomResolver, name string) ([]string, []dns.Record, error) {
+	return func(ctx context.Context, r dns.CustomResolver, name string) ([]string, []dns.Record, error) {
+		result, err := lookup(r, ctx, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		records, _ := any(result).([]dns.Record)
+		return format(result), records, nil
+	}
+}
+
+// withFull sets the lookup of rt with Options.Full.
+func (rt *recordType) withFull(lookup func(ctx context.Context, r dns.CustomResolver, name string) ([]string, []dns.Record, error)) *recordType {
+	rt.fullLookup = lookup
+	return rt
+}
+
+// lookupWith returns the lookup of rt for opts.
+func (rt *recordType) lookupWith(opts *Options) func(ctx context.Context, r dns.CustomResolver, name string) ([]string, []dns.Record, error) {
+	if opts.Full && rt.fullLookup != nil {
+		return rt.fullLookup
+	}
+	return rt.lookup
+}
+
+// lookupRecords returns the resolver method for the records of qtype, the records of the CNAME
+// chain to the answer are left out.
+func lookupRecords(qtype dns.Type) func(r dns.CustomResolver, ctx context.Context, name string) ([]dns.Record, error) {
+	return func(r dns.CustomResolver, ctx context.Context, name string) ([]dns.Record, error) {
+		records, err := r.LookupRecords(ctx, name, qtype)
+		if err != nil {
+			return nil, err
+		}
+		return slices.DeleteFunc(records, func(rr dns.Record) bool { return rr.Type != qtype }), nil
+	}
+}
+
+// lookupCNAME returns the whole CNAME chain of name, one record per hop with its TTL. The chain is
+// taken from the answer to the address query of name, when the resolver returned only part of it the
+// query is repeated from the last hop. A name without a CNAME record has an empty chain.
+func lookupCNAME(r dns.CustomResolver, ctx context.Context, name string) ([]dns.Record, error) {
+	chain := make([]dns.Record, 0)
+	for current := name; len(chain) < maxChain; {
+		records, err := r.LookupRecords(ctx, current, dns.TypeA)
+		if dns.Classify(err) == dns.ClassNoData {
+			return chain, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		hops, terminal := dns.CNAMEChain(current, records)
+		chain = append(chain, hops...)
+		if len(hops) == 0 || len(terminal) > 0 {
+			return chain, nil
+		}
+		current = hops[len(hops)-1].Target
+		if slices.ContainsFunc(chain, func(rr dns.Record) bool { return normalize(rr.Name) == normalize(current) }) {
+			// the chain loops back to one of its hops.
+			return chain, nil
+		}
+	}
+	return chain, nil
+}
+
+// lookupAddrs returns the A and AAAA records of name with the CNAME chain to them. Like
+// LookupIPAddr it only fails when neither query has an answer.
+func lookupAddrs(r dns.CustomResolver, ctx context.Context, name string) ([]dns.Record, error) {
+	records := make([]dns.Record, 0)
+	var first error
+	for _, qtype := range []dns.Type{dns.TypeA, dns.TypeAAAA} {
+		answer, err := r.LookupRecords(ctx, name, qtype)
+		if err != nil {
+			first = cmp.Or(first, err)
+			continue
+		}
+		for _, rr := range answer {
+			// both answers hold the chain, each hop is kept once.
+			if rr.Type != dns.TypeCNAME || !slices.Contains(records, rr) {
+				records = append(records, rr)
+			}
+		}
+	}
+	if len(records) == 0 && first != nil {
+		return nil, first
+	}
+	return records, nil
+}
+
+// TypeName returns the command and configuration name of the query type shown as qtype, or an
+// empty string for an unknown query type.
+func TypeName(qtype string) string {
+	if rt := recordTypeForQType(qtype); rt != nil {
+		return rt.name
+	}
+	return ""
+}
+
+// recordTypeForQType returns the record type shown as qtype in the output, or nil.
+func recordTypeForQType(qtype string) *recordType {
+	for _, rt := range recordTypes {
+		if rt.qtype == qtype {
+			return rt
+		}
+	}
+	return nil
+}
+
+func formatStrings(s []string) []string {
+	return append(make([]string, 0, len(s)), s...)
+}
+
+func formatMX(records []*net.MX) []string {
+	str := make([]string, 0, len(records))
+	for _, mx := range records {
+		str = append(str, mx.Host)
+	}
+	return str
+}
+
+func formatNS(records []*net.NS) []string {
+	str := make([]string, 0, len(records))
+	for _, ns := range records {
+		str = append(str, ns.Host)
+	}
+	return str
+}
+
+func formatIPAddrs(addrs []net.IPAddr) []string {
+	str := make([]string, 0, len(addrs))
+	for _, ip := range addrs {
+		str = append(str, ip.IP.String())
+	}
+	return str
+}
+
+func formatAddrs(records []dns.Record) []string {
+	str := make([]string, 0, len(records))
+	for _, rr := range records {
+		if rr.Type == dns.TypeA || rr.Type == dns.TypeAAAA {
+			str = append(str, rr.Data)
+		}
+	}
+	return str
+}
+
+func formatTargets(records []dns.Record) []string {
+	str := make([]string, 0, len(records))
+	for _, rr := range records {
+		str = append(str, rr.Target)
+	}
+	return str
+}
@@ -0,0 +1,78 @@
+package preload
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/jimmystewpot/dns-preload/pkg/confighandlers"
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+)
+
+func TestRecordTypes(t *testing.T) {
+	for _, name := range confighandlers.QueryTypes {
+		rt, ok := recordTypes[name]
+		if !ok {
+			t.Errorf("query type %s has no entry in the record type registry", name)
+			continue
+		}
+		if rt.name != name || recordTypeForQType(rt.qtype) != rt {
+			t.Errorf("record type %s is registered as %s with qtype %s", name, rt.name, rt.qtype)
+		}
+		if rt.deps == nil {
+			continue
+		}
+		for _, dep := range rt.deps(testDomainNoErr, []string{testDomainMX0}, nil, &Options{ForwardPTR: true}) {
+			if recordTypes[dep.typ] == nil {
+				t.Errorf("record type %s depends on the unknown type %s", name, dep.typ)
+			}
+		}
+	}
+}
+
+func TestRecordTypeLookup(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		want    []string
+		wantErr bool
+	}{
+		{name: confighandlers.Hosts, host: testDomainNoErr, want: []string{googlePubDNS1}},
+		{name: confighandlers.Cname, host: "www.foo.bar", want: []string{testDomainNoErr}},
+		{name: confighandlers.Mx, host: testDomainNoErr, want: []string{testDomainMX0, testDomainMX1}},
+		{name: confighandlers.Ns, host: testDomainNoErr, want: []string{testDomainNS1, "ns2.foo.bar"}},
+		{name: confighandlers.Txt, host: testDomainNoErr, want: []string{"v=spf1 -all"}},
+		{name: confighandlers.Ptr, host: googleIpv6, want: []string{"ipv6.google.com"}},
+		{name: confighandlers.Cname, host: testDomainNoErr},
+		{name: confighandlers.Cname, host: testDomainChain, want: []string{"www.foo.bar", testDomainNoErr}},
+		{name: confighandlers.Cname, host: testDomainLoop, want: []string{testDomainLoop2, testDomainLoop}},
+		{name: confighandlers.Srv, host: testDomainSRV, want: []string{testDomainMX0}},
+		{name: confighandlers.HTTPS, host: testDomainNoErr, want: []string{"."}},
+		{name: confighandlers.Mx, host: testDomainWithErr, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+tt.host, func(t *testing.T) {
+			got, _, err := recordTypes[tt.name].lookup(context.Background(), NewMockResolver(), tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lookup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(got)+len(tt.want) > 0 && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lookup() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupCNAMEChain(t *testing.T) {
+	_, records, err := recordTypes[confighandlers.Cname].lookup(context.Background(), NewMockResolver(), testDomainChain)
+	if err != nil {
+		t.Fatalf("lookup() error = %v", err)
+	}
+	want := []dns.Record{
+		{Name: testDomainChain, Type: dns.TypeCNAME, TTL: 300, Target: "www.foo.bar", Data: "www.foo.bar"},
+		{Name: "www.foo.bar", Type: dns.TypeCNAME, TTL: 300, Target: testDomainNoErr, Data: testDomainNoErr},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("lookup() records = %+v, want %+v", records, want)
+	}
+}
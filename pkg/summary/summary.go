@@ -0,0 +1,331 @@
+package summary
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jimmystewpot/dns-preload/pkg/dns"
+)
+
+const (
+	// percentiles reported in the latency statistics.
+	p50 float64 = 0.50
+	p95 float64 = 0.95
+	// tabwriter settings for the text summary.
+	tabMinWidth int  = 0
+	tabWidth    int  = 8
+	tabPadding  int  = 2
+	tabPadChar  byte = ' '
+)
+
+// Counts are the outcome totals for a set of lookups, NXDomain failures are not included in Failed.
+// Classes counts every failure, including NXDomain, by its dns.Class.
+//
+//nolint:govet // fieldalignment is not required here, field order matches the json output.
+type Counts struct {
+	Lookups   int               `json:"lookups"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	NXDomain  int               `json:"nxdomain"`
+	Skipped   int               `json:"skipped"`
+	Retries   int               `json:"retries"`
+	Classes   map[dns.Class]int `json:"classes,omitempty"`
+}
+
+// add merges o into c.
+func (c *Counts) add(o *Counts) {
+	c.Lookups += o.Lookups
+	c.Succeeded += o.Succeeded
+	c.Failed += o.Failed
+	c.NXDomain += o.NXDomain
+	c.Skipped += o.Skipped
+	c.Retries += o.Retries
+	for class, n := range o.Classes {
+		c.addClass(class, n)
+	}
+}
+
+// addClass adds n failures of class.
+func (c *Counts) addClass(class dns.Class, n int) {
+	if c.Classes == nil {
+		c.Classes = make(map[dns.Class]int)
+	}
+	c.Classes[class] += n
+}
+
+// copy returns a deep copy of c.
+func (c *Counts) copy() Counts {
+	cp := Counts{}
+	cp.add(c)
+	return cp
+}
+
+// Latency statistics for the lookups of a query type, the durations are reported in nanoseconds.
+type Latency struct {
+	Min time.Duration `json:"min_ns"`
+	P50 time.Duration `json:"p50_ns"`
+	P95 time.Duration `json:"p95_ns"`
+	Max time.Duration `json:"max_ns"`
+}
+
+// TypeSummary holds the totals and the latency statistics for a single query type.
+//
+//nolint:govet // fieldalignment is not required here, field order matches the json output.
+type TypeSummary struct {
+	QType string `json:"qtype"`
+	Counts
+	Latency Latency `json:"latency"`
+}
+
+// Lookup is a single completed lookup.
+//
+//nolint:govet // fieldalignment is not required here, field order matches the json output.
+type Lookup struct {
+	Name     string        `json:"name"`
+	QType    string        `json:"qtype"`
+	Duration time.Duration `json:"duration_ns"`
+	Attempts int           `json:"attempts"`
+	Class    dns.Class     `json:"class,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Tier is the outcome of the names of the configuration with the same priority, TimeToWarm is the
+// time from the start of the run until the last of them completed. Warmed is false when some of them
+// did not complete because the run stopped.
+//
+//nolint:govet // fieldalignment is not required here, field order matches the json output.
+type Tier struct {
+	Priority   int           `json:"priority"`
+	Names      int           `json:"names"`
+	Failed     int           `json:"failed"`
+	Warmed     bool          `json:"warmed"`
+	TimeToWarm time.Duration `json:"time_to_warm_ns"`
+}
+
+// Prioritized returns true when one of the tiers has a priority, the names of a configuration
+// without priorities are a single tier.
+func Prioritized(tiers []Tier) bool {
+	for _, t := range tiers {
+		if t.Priority != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary is the end of run report, types are listed in the order they were first recorded.
+//
+//nolint:govet // fieldalignment is not required here, field order matches the json output.
+type Summary struct {
+	WallTime time.Duration `json:"wall_time_ns"`
+	// Partial is the reason the run stopped before every lookup completed, empty when it completed.
+	Partial string `json:"partial,omitempty"`
+	// Avoided is the number of lookups answered by an identical lookup instead of a query.
+	Avoided int64         `json:"avoided_queries"`
+	Total   Counts        `json:"total"`
+	Types   []TypeSummary `json:"types"`
+	Slowest []Lookup      `json:"slowest"`
+	// Tiers are the priorities of the configuration from the highest, set when it has priorities.
+	Tiers []Tier `json:"tiers,omitempty"`
+}
+
+// Recorder collects the lookup outcomes during a run, it is safe for concurrent use.
+//
+//nolint:govet // fieldalignment is not required here.
+type Recorder struct {
+	mu      sync.Mutex
+	order   []string
+	counts  map[string]*Counts
+	lookups map[string][]Lookup
+}
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{
+		order:   make([]string, 0),
+		counts:  make(map[string]*Counts),
+		lookups: make(map[string][]Lookup),
+	}
+}
+
+// Record adds the outcome of a lookup of name for qtype that took attempts to complete.
+func (r *Recorder) Record(name, qtype string, duration time.Duration, attempts int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.typeCounts(qtype)
+	c.Lookups++
+	c.Retries += max(0, attempts-1)
+	l := Lookup{
+		Name:     name,
+		QType:    qtype,
+		Duration: duration,
+		Attempts: attempts,
+	}
+	if err == nil {
+		c.Succeeded++
+		r.lookups[qtype] = append(r.lookups[qtype], l)
+		return
+	}
+	l.Class = dns.Classify(err)
+	l.Error = err.Error()
+	c.addClass(l.Class, 1)
+	if l.Class == dns.ClassNXDomain {
+		c.NXDomain++
+	} else {
+		c.Failed++
+	}
+	r.lookups[qtype] = append(r.lookups[qtype], l)
+}
+
+// Skip records a name for qtype that was not queried.
+func (r *Recorder) Skip(_, qtype string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.typeCounts(qtype).Skipped++
+}
+
+// Summary builds the report for the lookups recorded so far, listing up to slowest of the slowest lookups.
+func (r *Recorder) Summary(wallTime time.Duration, slowest int) *Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := &Summary{
+		WallTime: wallTime,
+		Types:    make([]TypeSummary, 0, len(r.order)),
+		Slowest:  make([]Lookup, 0),
+	}
+	all := make([]Lookup, 0)
+	for _, qtype := range r.order {
+		c := r.counts[qtype].copy()
+		s.Types = append(s.Types, TypeSummary{
+			QType:   qtype,
+			Counts:  c,
+			Latency: latency(r.lookups[qtype]),
+		})
+		s.Total.add(&c)
+		all = append(all, r.lookups[qtype]...)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Duration > all[j].Duration
+	})
+	if slowest > len(all) {
+		slowest = len(all)
+	}
+	if slowest > 0 {
+		s.Slowest = append(s.Slowest, all[:slowest]...)
+	}
+	return s
+}
+
+// typeCounts returns the counters for qtype, the caller must hold the lock.
+func (r *Recorder) typeCounts(qtype string) *Counts {
+	c, ok := r.counts[qtype]
+	if !ok {
+		c = &Counts{}
+		r.counts[qtype] = c
+		r.order = append(r.order, qtype)
+	}
+	return c
+}
+
+// WriteText writes the summary as an aligned table for the text output mode.
+func (s *Summary) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, tabMinWidth, tabWidth, tabPadding, tabPadChar, 0)
+	if s.Partial != "" {
+		fmt.Fprintf(tw, "\nPartial run summary, stopped after %s: %s\n", s.WallTime, s.Partial)
+	} else {
+		fmt.Fprintf(tw, "\nRun summary, wall time %s\n", s.WallTime)
+	}
+	fmt.Fprintln(tw, "TYPE\tLOOKUPS\tSUCCEEDED\tFAILED\tNXDOMAIN\tSKIPPED\tRETRIES\tMIN\tP50\tP95\tMAX")
+	for i := range s.Types {
+		t := &s.Types[i]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n", t.QType, t.Lookups, t.Succeeded, t.Failed, t.NXDomain, t.Skipped, t.Retries,
+			t.Latency.Min, t.Latency.P50, t.Latency.P95, t.Latency.Max)
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\t%d\t%d\t%d\t%d\t%d\t\t\t\t\n", s.Total.Lookups, s.Total.Succeeded, s.Total.Failed, s.Total.NXDomain, s.Total.Skipped,
+		s.Total.Retries)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(s.Total.Classes) != 0 {
+		classes := make([]string, 0, len(s.Total.Classes))
+		for _, class := range dns.Classes {
+			if n, ok := s.Total.Classes[class]; ok {
+				classes = append(classes, fmt.Sprintf("%s=%d", class, n))
+			}
+		}
+		fmt.Fprintf(w, "\nFailures by class: %s\n", strings.Join(classes, " "))
+	}
+	if err := s.writeTiers(w); err != nil {
+		return err
+	}
+	if s.Avoided > 0 {
+		fmt.Fprintf(w, "\nAvoided queries: %d answered by an identical lookup in flight or earlier in the run\n", s.Avoided)
+	}
+	if len(s.Slowest) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "\nSlowest lookups")
+	for _, l := range s.Slowest {
+		detail := ""
+		if l.Attempts > 1 {
+			detail = fmt.Sprintf(" after %d attempts", l.Attempts)
+		}
+		if l.Class != dns.ClassNone {
+			detail += fmt.Sprintf(" failed with %s", l.Class)
+		}
+		if _, err := fmt.Fprintf(w, "  %s type %s in %s%s\n", l.Name, l.QType, l.Duration, detail); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTiers writes the time to warm of every priority.
+func (s *Summary) writeTiers(w io.Writer) error {
+	if len(s.Tiers) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "\nTime to warm by priority")
+	tw := tabwriter.NewWriter(w, tabMinWidth, tabWidth, tabPadding, tabPadChar, 0)
+	fmt.Fprintln(tw, "PRIORITY\tNAMES\tFAILED\tTIME TO WARM")
+	for _, t := range s.Tiers {
+		warm := "not warmed"
+		if t.Warmed {
+			warm = t.TimeToWarm.String()
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%s\n", t.Priority, t.Names, t.Failed, warm)
+	}
+	return tw.Flush()
+}
+
+// latency calculates the latency statistics using the nearest rank method.
+func latency(lookups []Lookup) Latency {
+	if len(lookups) == 0 {
+		return Latency{}
+	}
+	durations := make([]time.Duration, 0, len(lookups))
+	for _, l := range lookups {
+		durations = append(durations, l.Duration)
+	}
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+	return Latency{
+		Min: durations[0],
+		P50: percentile(durations, p50),
+		P95: percentile(durations, p95),
+		Max: durations[len(durations)-1],
+	}
+}
+
+// percentile returns the nearest rank percentile p of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(float64(len(sorted))*p)) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
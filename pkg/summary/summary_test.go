@@ -0,0 +1,124 @@
+package summary
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testQTypeA  string = "A, AAAA"
+	testQTypeMX string = "MX"
+)
+
+func TestRecorderSummary(t *testing.T) {
+	r := NewRecorder()
+	for i := 1; i <= 20; i++ {
+		r.Record(fmt.Sprintf("host%d.foo.bar", i), testQTypeA, time.Duration(i)*time.Millisecond, 1, nil)
+	}
+	r.Record("bar.foo", testQTypeA, 50*time.Millisecond, 1, &net.DNSError{Err: "no such host", Name: "bar.foo", IsNotFound: true})
+	r.Record("foo.bar", testQTypeMX, 2*time.Millisecond, 3, fmt.Errorf("i/o timeout"))
+	r.Skip("baz.foo", testQTypeMX)
+
+	s := r.Summary(time.Second, 3)
+	if len(s.Types) != 2 || s.Types[0].QType != testQTypeA {
+		t.Fatalf("expected the types in the order they were recorded got %+v", s.Types)
+	}
+	a := s.Types[0]
+	if a.Succeeded != 20 || a.NXDomain != 1 || a.Failed != 0 {
+		t.Errorf("unexpected counts for %s: %+v", testQTypeA, a.Counts)
+	}
+	if a.Latency.Min != time.Millisecond || a.Latency.Max != 50*time.Millisecond {
+		t.Errorf("unexpected min/max latency %+v", a.Latency)
+	}
+	if a.Latency.P50 != 11*time.Millisecond || a.Latency.P95 != 20*time.Millisecond {
+		t.Errorf("unexpected p50/p95 latency %+v", a.Latency)
+	}
+	mx := s.Types[1]
+	if mx.Failed != 1 || mx.Skipped != 1 {
+		t.Errorf("unexpected counts for %s: %+v", testQTypeMX, mx.Counts)
+	}
+	if s.Total.Lookups != 22 || s.Total.Skipped != 1 || s.Total.Retries != 2 {
+		t.Errorf("unexpected totals %+v", s.Total)
+	}
+	if len(s.Slowest) != 3 || s.Slowest[0].Name != "bar.foo" {
+		t.Errorf("expected the 3 slowest lookups starting with bar.foo got %+v", s.Slowest)
+	}
+}
+
+func TestSummaryWriteText(t *testing.T) {
+	r := NewRecorder()
+	r.Record("foo.bar", testQTypeMX, time.Millisecond, 1, nil)
+	buf := &bytes.Buffer{}
+	if err := r.Summary(time.Second, 5).WriteText(buf); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+	for _, want := range []string{"wall time 1s", testQTypeMX, "TOTAL", "Slowest lookups", "foo.bar"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("WriteText() output does not contain %s:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestSummaryWriteTextPartial(t *testing.T) {
+	r := NewRecorder()
+	r.Skip("foo.bar", testQTypeMX)
+	sum := r.Summary(time.Second, 5)
+	sum.Partial = "context canceled"
+	buf := &bytes.Buffer{}
+	if err := sum.WriteText(buf); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "Partial run summary, stopped after 1s: context canceled") {
+		t.Errorf("WriteText() output does not mark the summary as partial:\n%s", buf.String())
+	}
+}
+
+func TestSummaryWriteTextTiers(t *testing.T) {
+	sum := NewRecorder().Summary(time.Second, 5)
+	sum.Tiers = []Tier{
+		{Priority: 10, Names: 2, Warmed: true, TimeToWarm: 35 * time.Millisecond},
+		{Priority: 0, Names: 40, Failed: 1},
+	}
+	buf := &bytes.Buffer{}
+	if err := sum.WriteText(buf); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+	for _, want := range []string{"Time to warm by priority", "10        2      0       35ms", "0         40     1       not warmed"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("WriteText() output does not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		name   string
+		sorted []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{
+			name:   "single value",
+			sorted: []time.Duration{time.Second},
+			p:      p95,
+			want:   time.Second,
+		},
+		{
+			name:   "median of four",
+			sorted: []time.Duration{1, 2, 3, 4},
+			p:      p50,
+			want:   2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.sorted, tt.p); got != tt.want {
+				t.Errorf("percentile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}